@@ -0,0 +1,224 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package audit records who changed which function, when and with what outcome
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/auth"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// The operations which are audited
+const (
+	OperationDeploy = "deploy"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationScale  = "scale"
+)
+
+// Entry is one audited operation
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Principal is who the request was authenticated as, empty without authentication
+	Principal    string `json:"principal,omitempty"`
+	SourceIP     string `json:"sourceIP"`
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+
+	Operation   string `json:"operation"`
+	Function    string `json:"function"`
+	Namespace   string `json:"namespace"`
+	Environment string `json:"environment,omitempty"`
+	// Request summarizes what was asked for, with secrets redacted
+	Request   interface{} `json:"request,omitempty"`
+	ServiceID string      `json:"serviceId,omitempty"`
+
+	// Outcome is success or failure, the Error tells why it failed
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Log writes the entries as JSON lines and keeps the latest ones in memory
+type Log struct {
+	lock    sync.Mutex
+	writer  io.Writer
+	path    string
+	keep    int
+	entries []Entry
+}
+
+// NewLog creates a log writing to writer which keeps the last keep entries for queries
+func NewLog(writer io.Writer, keep int) *Log {
+	return &Log{writer: writer, keep: keep}
+}
+
+// OpenLog creates a log appending to the file at path, which is queried instead of the entries
+// in memory, or writing to stdout when path is "stdout"
+func OpenLog(path string, keep int) (*Log, error) {
+	if path == "stdout" {
+		return NewLog(os.Stdout, keep), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLog(file, keep)
+	l.path = path
+	return l, nil
+}
+
+// Write appends the entry to the log
+func (l *Log) Write(entry Entry) {
+	line, _ := json.Marshal(entry)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, err := l.writer.Write(append(line, '\n')); err != nil {
+		logging.Default().Error("Unable to write the audit log", "error", err)
+	}
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.keep {
+		l.entries = l.entries[len(l.entries)-l.keep:]
+	}
+}
+
+// maxQueried is the most entries Since returns, the latest ones are kept
+var maxQueried = 10000
+
+// Since returns the entries recorded at or after since, oldest first, at most maxQueried of them.
+// When the log is a file all of it is searched, otherwise only the entries kept in memory.
+func (l *Log) Since(since time.Time) ([]Entry, error) {
+	entries := []Entry{}
+	if len(l.path) == 0 {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		for _, entry := range l.entries {
+			if !entry.Time.Before(since) {
+				entries = append(entries, entry)
+			}
+		}
+		return entries, nil
+	}
+
+	// the file is read through its own handle up to the size it had when the query started, so
+	// that entries keep being written while it is searched and only whole lines are read
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	l.lock.Lock()
+	info, err := file.Stat()
+	l.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(file, info.Size()))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+		if len(entries) > maxQueried*2 {
+			entries = append([]Entry{}, entries[len(entries)-maxQueried:]...)
+		}
+	}
+	if len(entries) > maxQueried {
+		entries = entries[len(entries)-maxQueried:]
+	}
+	return entries, scanner.Err()
+}
+
+// requestInfo is what the middleware knows about the request of an entry
+type requestInfo struct {
+	log          *Log
+	sourceIP     string
+	forwardedFor string
+	requestID    string
+	// principal is who operations outside of requests are recorded as, see WithSystem
+	principal string
+}
+
+// SystemPrincipal prefixes the principal of the operations the provider makes on its own
+const SystemPrincipal = "system:"
+
+type requestInfoKey struct{}
+
+// MakeMiddleware makes the operations of the requests recorded into the log, see Record. It has
+// to run after the authentication so that the principal is known.
+func MakeMiddleware(l *Log, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			sourceIP = r.RemoteAddr
+		}
+
+		info := &requestInfo{
+			log:          l,
+			sourceIP:     sourceIP,
+			forwardedFor: r.Header.Get("X-Forwarded-For"),
+			requestID:    r.Header.Get(logging.RequestIDHeader),
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+// WithSystem returns a context in which the operations of a background loop, such as the removals
+// of the janitor, are recorded into the log as made by the named component, e.g. system:janitor.
+// Without a log nothing is recorded.
+func WithSystem(ctx context.Context, l *Log, component string) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, requestInfoKey{}, &requestInfo{log: l, principal: SystemPrincipal + component})
+}
+
+// Record completes the entry with the time, the caller, the namespace and the environment of the
+// request of ctx, and writes it to its log. Operations outside of requests are only recorded in
+// contexts made by WithSystem.
+func Record(ctx context.Context, entry Entry) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Principal = info.principal
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		entry.Principal = principal.Name
+	}
+	entry.SourceIP = info.sourceIP
+	entry.ForwardedFor = info.forwardedFor
+	entry.RequestID = info.requestID
+	entry.Namespace = rancher.NamespaceFromContext(ctx)
+	if environment, ok := rancher.EnvironmentFromContext(ctx); ok {
+		entry.Environment = environment.Name
+	}
+	if len(entry.Outcome) == 0 {
+		entry.Outcome = "success"
+		if len(entry.Error) > 0 {
+			entry.Outcome = "failure"
+		}
+	}
+
+	info.log.Write(entry)
+}
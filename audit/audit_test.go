@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/auth"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordThroughMiddleware records the entry in a request passing through the middleware
+func recordThroughMiddleware(l *Log, req *http.Request, entry Entry) {
+	handler := MakeMiddleware(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Record(r.Context(), entry)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func Test_Record_Completes_Entry_From_Request(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	output := &bytes.Buffer{}
+	l := NewLog(output, 10)
+	req, _ := http.NewRequest("DELETE", "/system/functions", nil)
+	req.RemoteAddr = "10.42.0.7:51234"
+	req.Header.Set("X-Forwarded-For", "192.168.1.20")
+	ctx := auth.WithPrincipal(req.Context(), &auth.Principal{Name: "alice", Method: "basic"})
+	ctx = rancher.WithNamespace(ctx, "team-a")
+
+	// Act
+	recordThroughMiddleware(l, req.WithContext(ctx), Entry{Operation: OperationDelete, Function: "some-function", ServiceID: "1s5"})
+
+	// Assert
+	entry := Entry{}
+	assert.Nil(json.Unmarshal(output.Bytes(), &entry))
+	assert.Equal("alice", entry.Principal)
+	assert.Equal("10.42.0.7", entry.SourceIP)
+	assert.Equal("192.168.1.20", entry.ForwardedFor)
+	assert.Equal("team-a", entry.Namespace)
+	assert.Equal("delete", entry.Operation)
+	assert.Equal("1s5", entry.ServiceID)
+	assert.Equal("success", entry.Outcome)
+	assert.False(entry.Time.IsZero())
+}
+
+func Test_Record_Outside_Of_Requests_Is_Ignored(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NotPanics(func() {
+		Record(context.Background(), Entry{Operation: OperationScale, Function: "some-function"})
+	})
+}
+
+func Test_WithSystem_Records_As_Component(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	l := NewLog(ioutil.Discard, 10)
+	ctx := WithSystem(context.Background(), l, "janitor")
+
+	// Act
+	Record(ctx, Entry{Operation: OperationDelete, Function: "some-function"})
+	Record(WithSystem(context.Background(), nil, "janitor"), Entry{Operation: OperationDelete, Function: "other-function"})
+
+	// Assert
+	entries, _ := l.Since(time.Time{})
+	assert.Len(entries, 1)
+	assert.Equal("system:janitor", entries[0].Principal)
+	assert.Equal("default", entries[0].Namespace)
+	assert.Equal("success", entries[0].Outcome)
+}
+
+func Test_Log_Since_Keeps_Latest_Entries(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	l := NewLog(ioutil.Discard, 2)
+	started := time.Now().UTC()
+	for _, function := range []string{"a", "b", "c"} {
+		l.Write(Entry{Time: started, Function: function})
+	}
+	l.Write(Entry{Time: started.Add(time.Hour), Function: "d"})
+
+	// Act
+	all, _ := l.Since(time.Time{})
+	recent, _ := l.Since(started.Add(time.Minute))
+
+	// Assert
+	assert.Len(all, 2)
+	assert.Equal("c", all[0].Function)
+	assert.Len(recent, 1)
+	assert.Equal("d", recent[0].Function)
+}
+
+func Test_OpenLog_Queries_The_Whole_File(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	l, err := OpenLog(path, 1)
+	assert.Nil(err)
+	req, _ := http.NewRequest("POST", "/system/functions", nil)
+
+	// Act
+	recordThroughMiddleware(l, req, Entry{Operation: OperationDeploy, Function: "a"})
+	recordThroughMiddleware(l, req, Entry{Operation: OperationDeploy, Function: "b", Error: "quota exceeded"})
+	entries, sinceErr := l.Since(time.Time{})
+
+	// Assert
+	assert.Nil(sinceErr)
+	assert.Len(entries, 2)
+	assert.Equal("a", entries[0].Function)
+	assert.Equal("failure", entries[1].Outcome)
+	info, _ := os.Stat(path)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func Test_Log_Since_Bounds_Entries_Read_From_File(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	defer func(previous int) { maxQueried = previous }(maxQueried)
+	maxQueried = 2
+	dir, _ := ioutil.TempDir("", "audit")
+	defer os.RemoveAll(dir)
+	l, _ := OpenLog(filepath.Join(dir, "audit.log"), 1)
+	for _, function := range []string{"a", "b", "c", "d", "e", "f"} {
+		l.Write(Entry{Time: time.Now().UTC(), Function: function})
+	}
+
+	// Act
+	entries, err := l.Since(time.Time{})
+
+	// Assert
+	assert.Nil(err)
+	assert.Len(entries, 2)
+	assert.Equal("e", entries[0].Function)
+	assert.Equal("f", entries[1].Function)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package audit
+
+import (
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/types"
+)
+
+// Redacted replaces the values of sensitive fields
+const Redacted = logging.Redacted
+
+// RedactFunctionRequest returns a copy of the deploy request with the registry credentials and
+// the values of sensitive environment variables redacted
+func RedactFunctionRequest(request types.CreateFunctionRequest) types.CreateFunctionRequest {
+	if len(request.RegistryAuth) > 0 {
+		request.RegistryAuth = Redacted
+	}
+	if len(request.EnvVars) > 0 {
+		envVars := make(map[string]string, len(request.EnvVars))
+		for key, value := range request.EnvVars {
+			if logging.SensitiveKey(key) {
+				value = Redacted
+			}
+			envVars[key] = value
+		}
+		request.EnvVars = envVars
+	}
+	return request
+}
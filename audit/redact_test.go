@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RedactFunctionRequest(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	request := types.CreateFunctionRequest{}
+	request.Service = "some-function"
+	request.RegistryAuth = "dXNlcjpwYXNz"
+	request.EnvVars = map[string]string{"DB_PASSWORD": "hunter2", "API_TOKEN": "abc", "MODE": "fast"}
+	request.Secrets = []string{"api-key"}
+
+	// Act
+	redacted := RedactFunctionRequest(request)
+
+	// Assert
+	assert.Equal(Redacted, redacted.RegistryAuth)
+	assert.Equal(map[string]string{"DB_PASSWORD": Redacted, "API_TOKEN": Redacted, "MODE": "fast"}, redacted.EnvVars)
+	assert.Equal([]string{"api-key"}, redacted.Secrets, "secret names are not secret")
+	assert.Equal("hunter2", request.EnvVars["DB_PASSWORD"], "the request was modified")
+}
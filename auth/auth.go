@@ -0,0 +1,90 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package auth authenticates the requests to the provider's API
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kenfdev/faas-rancher/logging"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries none of the
+// credentials it checks, so that the next one is tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is who a request was authenticated as
+type Principal struct {
+	Name string
+	// Method is the Authenticator which accepted the request, e.g. basic
+	Method string
+}
+
+// Authenticator checks one kind of credentials. The errors it returns are logged, so they must
+// not contain the credentials themselves.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate header asking for its credentials, empty if there is none
+	Challenge() string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext is the principal set by WithPrincipal, nil for unauthenticated requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// MakeMiddleware rejects the requests none of the authenticators accepts with 401, the
+// authenticators are tried in order. Invocations of functions below /function/ are let through
+// unauthenticated when exemptFunctions is set.
+func MakeMiddleware(authenticators []Authenticator, exemptFunctions bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exemptFunctions && strings.HasPrefix(r.URL.Path, "/function/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := ErrNoCredentials
+		for _, authenticator := range authenticators {
+			var principal *Principal
+			principal, err = authenticator.Authenticate(r)
+			if err == nil {
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+				return
+			}
+			if err != ErrNoCredentials {
+				break
+			}
+		}
+
+		logging.FromContext(r.Context()).Warn("Authentication failed", "method", r.Method, "path", r.URL.Path,
+			"remoteHost", remoteHost(r), "reason", err)
+		for _, authenticator := range authenticators {
+			if challenge := authenticator.Challenge(); len(challenge) > 0 {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized"))
+	})
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeProtectedHandler(authenticators []Authenticator, exemptFunctions bool, principals *[]*Principal) http.Handler {
+	return MakeMiddleware(authenticators, exemptFunctions, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*principals = append(*principals, PrincipalFromContext(r.Context()))
+	}))
+}
+
+func Test_MakeMiddleware_Rejects_Missing_Credentials(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tokens, _ := ParseBearerTokens([]byte("ci:some-token"))
+	principals := []*Principal{}
+	handler := makeProtectedHandler([]Authenticator{tokens}, false, &principals)
+	req, _ := http.NewRequest("POST", "/system/functions", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusUnauthorized, rr.Code)
+	assert.Equal(`Bearer realm="faas-rancher"`, rr.Header().Get("WWW-Authenticate"))
+	assert.Empty(principals)
+}
+
+func Test_MakeMiddleware_Sets_Principal_Of_Bearer_Token(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tokens, _ := ParseBearerTokens([]byte("# tokens\nci:some-token\n\nops:other-token\n"))
+	principals := []*Principal{}
+	handler := makeProtectedHandler([]Authenticator{tokens}, false, &principals)
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
+	req.Header.Set("Authorization", "Bearer other-token")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal([]*Principal{{Name: "ops", Method: "bearer"}}, principals)
+}
+
+func Test_MakeMiddleware_Exempts_Functions_Only_When_Asked(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tokens, _ := ParseBearerTokens([]byte("ci:some-token"))
+	principals := []*Principal{}
+	exempting := makeProtectedHandler([]Authenticator{tokens}, true, &principals)
+	protecting := makeProtectedHandler([]Authenticator{tokens}, false, &principals)
+
+	for _, tc := range []struct {
+		handler http.Handler
+		path    string
+		status  int
+	}{
+		{exempting, "/function/some-function", http.StatusOK},
+		{exempting, "/system/functions", http.StatusUnauthorized},
+		{exempting, "/functions", http.StatusUnauthorized},
+		{protecting, "/function/some-function", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest("POST", tc.path, nil)
+		rr := httptest.NewRecorder()
+
+		// Act
+		tc.handler.ServeHTTP(rr, req)
+
+		// Assert
+		assert.Equal(tc.status, rr.Code, tc.path)
+	}
+	assert.Equal([]*Principal{nil}, principals)
+}
+
+func Test_MakeMiddleware_Does_Not_Log_Credentials(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	output := &bytes.Buffer{}
+	logger, _ := logging.New(output, logging.FormatLogfmt, logging.LevelInfo)
+
+	tokens, _ := ParseBearerTokens([]byte("ci:some-token"))
+	principals := []*Principal{}
+	handler := makeProtectedHandler([]Authenticator{tokens}, false, &principals)
+	req, _ := http.NewRequest("DELETE", "/system/functions", nil)
+	req.Header.Set("Authorization", "Bearer guessed-token")
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(logging.WithLogger(req.Context(), logger)))
+
+	// Assert
+	assert.True(strings.Contains(output.String(), "invalid bearer token"))
+	assert.False(strings.Contains(output.String(), "guessed-token"))
+}
+
+func Test_ParseBearerTokens_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParseBearerTokens([]byte("ci-without-token"))
+
+	assert.NotNil(err)
+}
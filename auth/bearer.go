@@ -0,0 +1,77 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// BearerTokens checks static bearer tokens, each of which names who it was given to
+type BearerTokens struct {
+	names  []string
+	tokens [][]byte
+}
+
+// NewBearerTokens reads a tokens file
+func NewBearerTokens(path string) (*BearerTokens, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBearerTokens(data)
+}
+
+// ParseBearerTokens reads the lines name:token of a tokens file, skipping blank lines and comments
+func ParseBearerTokens(data []byte) (*BearerTokens, error) {
+	b := &BearerTokens{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("line %d of the tokens file is not name:token", lineNumber)
+		}
+		b.names = append(b.names, parts[0])
+		b.tokens = append(b.tokens, []byte(parts[1]))
+	}
+	return b, scanner.Err()
+}
+
+// Authenticate checks the bearer token of the Authorization header
+func (b *BearerTokens) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	// every token is compared, so that the time taken doesn't tell which one nearly matched
+	match := -1
+	for i := range b.tokens {
+		if subtle.ConstantTimeCompare(token, b.tokens[i]) == 1 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil, errors.New("invalid bearer token")
+	}
+	return &Principal{Name: b.names[match], Method: "bearer"}, nil
+}
+
+// Challenge asks for a bearer token
+func (b *BearerTokens) Challenge() string {
+	return `Bearer realm="faas-rancher"`
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeSignedRequest(secret string, signedAt time.Time, body string) *http.Request {
+	req, _ := http.NewRequest("POST", "/system/functions?wait=10s", bytes.NewReader([]byte(body)))
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign([]byte(secret), timestamp, "POST", "/system/functions?wait=10s", []byte(body)))
+	return req
+}
+
+func Test_HMAC_Accepts_Signed_Request(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	now := time.Unix(1500000000, 0)
+	authenticator := NewHMAC([]byte("shared"), time.Minute)
+	authenticator.now = func() time.Time { return now }
+	req := makeSignedRequest("shared", now.Add(-time.Second*30), `{"service":"some-function"}`)
+
+	// Act
+	principal, err := authenticator.Authenticate(req)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal(&Principal{Name: "gateway", Method: "hmac"}, principal)
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(`{"service":"some-function"}`, string(body), "the body can't be read again")
+}
+
+func Test_HMAC_Rejects_Invalid_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	now := time.Unix(1500000000, 0)
+	authenticator := NewHMAC([]byte("shared"), time.Minute)
+	authenticator.now = func() time.Time { return now }
+
+	tampered := makeSignedRequest("shared", now, `{"service":"some-function"}`)
+	tampered.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"service":"other-function"}`)))
+	unsigned, _ := http.NewRequest("POST", "/system/functions", nil)
+
+	for name, tc := range map[string]struct {
+		req *http.Request
+		err error
+	}{
+		"wrong secret": {makeSignedRequest("guessed", now, "{}"), nil},
+		"stale":        {makeSignedRequest("shared", now.Add(-time.Minute*2), "{}"), nil},
+		"future":       {makeSignedRequest("shared", now.Add(time.Minute*2), "{}"), nil},
+		"tampered":     {tampered, nil},
+		"unsigned":     {unsigned, ErrNoCredentials},
+	} {
+		// Act
+		principal, err := authenticator.Authenticate(tc.req)
+
+		// Assert
+		assert.Nil(principal, name)
+		assert.NotNil(err, name)
+		if tc.err != nil {
+			assert.Equal(tc.err, err, name)
+		}
+	}
+}
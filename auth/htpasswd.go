@@ -0,0 +1,78 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// unknownUserHash is compared against for unknown users, so that they take as long to reject as
+// wrong passwords and users can't be told apart by timing
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+// Htpasswd checks basic auth against the bcrypt hashes of an htpasswd file
+type Htpasswd struct {
+	users map[string][]byte
+}
+
+// NewHtpasswd reads an htpasswd file, as written by htpasswd -B. Hashes other than bcrypt are rejected.
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHtpasswd(data)
+}
+
+// ParseHtpasswd reads the lines user:hash of an htpasswd file, skipping blank lines and comments
+func ParseHtpasswd(data []byte) (*Htpasswd, error) {
+	h := &Htpasswd{users: make(map[string][]byte)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("line %d of the htpasswd file is not user:hash", lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return nil, fmt.Errorf("the hash of %s is not bcrypt, use htpasswd -B", parts[0])
+		}
+		h.users[parts[0]] = []byte(parts[1])
+	}
+	return h, scanner.Err()
+}
+
+// Authenticate checks the user and password of basic auth
+func (h *Htpasswd) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	hash, known := h.users[user]
+	if !known {
+		hash = unknownUserHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return nil, fmt.Errorf("invalid user or password for %q", user)
+	}
+	return &Principal{Name: user, Method: "basic"}, nil
+}
+
+// Challenge asks for basic auth
+func (h *Htpasswd) Challenge() string {
+	return `Basic realm="faas-rancher"`
+}
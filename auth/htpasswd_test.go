@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func makeHtpasswd(t *testing.T) *Htpasswd {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	htpasswd, err := ParseHtpasswd([]byte("# admins\nalice:" + string(hash) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return htpasswd
+}
+
+func Test_Htpasswd_Authenticate(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	htpasswd := makeHtpasswd(t)
+
+	for _, tc := range []struct {
+		user     string
+		password string
+		valid    bool
+	}{
+		{"alice", "s3cr3t", true},
+		{"alice", "wrong", false},
+		{"bob", "s3cr3t", false},
+	} {
+		req, _ := http.NewRequest("GET", "/system/functions", nil)
+		req.SetBasicAuth(tc.user, tc.password)
+
+		// Act
+		principal, err := htpasswd.Authenticate(req)
+
+		// Assert
+		if tc.valid {
+			assert.Nil(err)
+			assert.Equal(&Principal{Name: "alice", Method: "basic"}, principal)
+		} else {
+			assert.NotNil(err)
+			assert.NotEqual(ErrNoCredentials, err)
+			assert.False(strings.Contains(err.Error(), tc.password))
+		}
+	}
+}
+
+func Test_Htpasswd_Without_Basic_Auth(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
+
+	_, err := makeHtpasswd(t).Authenticate(req)
+
+	assert.Equal(ErrNoCredentials, err)
+}
+
+func Test_ParseHtpasswd_Rejects_Other_Hashes(t *testing.T) {
+	assert := assert.New(t)
+
+	_, md5Err := ParseHtpasswd([]byte("alice:$apr1$O4XkJ5lG$Zf.9yyM8BEJzbTfZeSEu0/"))
+	_, lineErr := ParseHtpasswd([]byte("alice"))
+
+	assert.NotNil(md5Err)
+	assert.NotNil(lineErr)
+}
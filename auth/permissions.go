@@ -0,0 +1,75 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/kenfdev/faas-rancher/types"
+)
+
+// DeployPermissions requires deploy, and secrets when the function mounts secrets.
+// Bodies which can't be parsed are left for the handler to reject.
+func DeployPermissions(r *http.Request) ([]Permission, error) {
+	body, err := peekBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []Permission{PermissionDeploy}
+	request := types.CreateFunctionRequest{}
+	if json.Unmarshal(body, &request) == nil && len(request.Secrets) > 0 {
+		permissions = append(permissions, PermissionSecrets)
+	}
+	return permissions, nil
+}
+
+// StackPermissions requires deploy, secrets when a function of the stack document mounts secrets,
+// and delete when functions missing from it are pruned
+func StackPermissions(r *http.Request) ([]Permission, error) {
+	body, err := peekBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []Permission{PermissionDeploy}
+	if document, err := types.ParseStackDocument(body); err == nil {
+		for _, function := range document.Functions {
+			if len(function.Secrets) > 0 {
+				permissions = append(permissions, PermissionSecrets)
+				break
+			}
+		}
+	}
+	if prune, _ := strconv.ParseBool(r.URL.Query().Get("prune")); prune {
+		permissions = append(permissions, PermissionDelete)
+	}
+	return permissions, nil
+}
+
+// JanitorPermissions requires read to list the failed services and delete to remove them
+func JanitorPermissions(r *http.Request) ([]Permission, error) {
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun")); r.Method != "POST" || dryRun {
+		return []Permission{PermissionRead}, nil
+	}
+	return []Permission{PermissionDelete}, nil
+}
+
+// peekBody reads the body of the request and puts it back for the handler
+func peekBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
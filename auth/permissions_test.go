@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DeployPermissions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	plain, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader([]byte(`{"service":"f","image":"i"}`)))
+	withSecrets, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader([]byte(`{"service":"f","image":"i","secrets":["api-key"]}`)))
+
+	// Act
+	plainPermissions, _ := DeployPermissions(plain)
+	secretsPermissions, _ := DeployPermissions(withSecrets)
+
+	// Assert
+	assert.Equal([]Permission{PermissionDeploy}, plainPermissions)
+	assert.Equal([]Permission{PermissionDeploy, PermissionSecrets}, secretsPermissions)
+	body, _ := ioutil.ReadAll(withSecrets.Body)
+	assert.Equal(`{"service":"f","image":"i","secrets":["api-key"]}`, string(body), "the body wasn't put back")
+}
+
+func Test_StackPermissions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	document := "functions:\n  - service: f\n    image: i\n    secrets: [api-key]\n"
+	req, _ := http.NewRequest("POST", "/system/apply?prune=true", bytes.NewReader([]byte(document)))
+
+	// Act
+	permissions, err := StackPermissions(req)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal([]Permission{PermissionDeploy, PermissionSecrets, PermissionDelete}, permissions)
+}
+
+func Test_JanitorPermissions(t *testing.T) {
+	assert := assert.New(t)
+
+	list, _ := http.NewRequest("GET", "/system/janitor", nil)
+	dryRun, _ := http.NewRequest("POST", "/system/janitor?dryRun=true", nil)
+	collect, _ := http.NewRequest("POST", "/system/janitor", nil)
+
+	listPermissions, _ := JanitorPermissions(list)
+	dryRunPermissions, _ := JanitorPermissions(dryRun)
+	collectPermissions, _ := JanitorPermissions(collect)
+
+	assert.Equal([]Permission{PermissionRead}, listPermissions)
+	assert.Equal([]Permission{PermissionRead}, dryRunPermissions)
+	assert.Equal([]Permission{PermissionDelete}, collectPermissions)
+}
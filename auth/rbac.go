@@ -0,0 +1,265 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Permission is what a route requires of the caller
+type Permission string
+
+// The permissions granted by the roles
+const (
+	PermissionRead    Permission = "read"
+	PermissionInvoke  Permission = "invoke"
+	PermissionDeploy  Permission = "deploy"
+	PermissionScale   Permission = "scale"
+	PermissionDelete  Permission = "delete"
+	PermissionExec    Permission = "exec"
+	PermissionSecrets Permission = "secrets"
+	PermissionAudit   Permission = "audit"
+)
+
+// Roles grant permissions, each role has those of the roles before it as well
+var Roles = map[string][]Permission{
+	"reader":   {PermissionRead, PermissionInvoke},
+	"deployer": {PermissionRead, PermissionInvoke, PermissionDeploy},
+	"operator": {PermissionRead, PermissionInvoke, PermissionDeploy, PermissionScale},
+	"admin":    {PermissionRead, PermissionInvoke, PermissionDeploy, PermissionScale, PermissionDelete, PermissionExec, PermissionSecrets, PermissionAudit},
+}
+
+// Binding grants a role to a principal, * binds every authenticated principal
+type Binding struct {
+	Subject string `json:"subject" yaml:"subject"`
+	Role    string `json:"role" yaml:"role"`
+	// Namespaces limit the role to functions of these namespaces, it applies to all when empty
+	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+	// Environments limit the role to these Rancher environments, it applies to all when empty
+	Environments []string `json:"environments" yaml:"environments"`
+}
+
+// Policy is the set of role bindings
+type Policy struct {
+	Bindings []Binding `json:"bindings" yaml:"bindings"`
+}
+
+// ParsePolicy reads a policy written in YAML or JSON
+func ParsePolicy(data []byte) (*Policy, error) {
+	// JSON is YAML as well
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+
+	for i, binding := range policy.Bindings {
+		if len(binding.Subject) == 0 {
+			return nil, fmt.Errorf("binding %d has no subject", i+1)
+		}
+		if _, ok := Roles[binding.Role]; !ok {
+			return nil, fmt.Errorf("binding %d of %s has the unknown role %q", i+1, binding.Subject, binding.Role)
+		}
+		for _, namespace := range binding.Namespaces {
+			if err := rancher.ValidateNamespace(namespace); err != nil {
+				return nil, err
+			}
+		}
+		for _, environment := range binding.Environments {
+			if len(environment) == 0 {
+				return nil, fmt.Errorf("binding %d of %s names an empty environment", i+1, binding.Subject)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Allowed tells whether a binding of the principal grants the permission in the namespace of the
+// environment
+func (p *Policy) Allowed(principal *Principal, permission Permission, namespace string, environment string) bool {
+	if principal == nil {
+		return false
+	}
+
+	for _, binding := range p.Bindings {
+		if binding.Subject != principal.Name && binding.Subject != "*" {
+			continue
+		}
+		if !contains(binding.Namespaces, namespace) || !contains(binding.Environments, environment) {
+			continue
+		}
+		for _, granted := range Roles[binding.Role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains tells whether a binding limited to the bound names applies to name, it applies to all
+// names when it isn't limited
+func contains(bound []string, name string) bool {
+	if len(bound) == 0 {
+		return true
+	}
+	for _, value := range bound {
+		if value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsFunc tells which permissions a request requires
+type PermissionsFunc func(r *http.Request) ([]Permission, error)
+
+// Requires is the PermissionsFunc of routes which always require the same permissions
+func Requires(permissions ...Permission) PermissionsFunc {
+	return func(r *http.Request) ([]Permission, error) {
+		return permissions, nil
+	}
+}
+
+// Authorizer checks requests against the policy of a file, which is read again when it changes
+type Authorizer struct {
+	path               string
+	defaultEnvironment string
+
+	lock     sync.RWMutex
+	policy   *Policy
+	modified time.Time
+}
+
+// NewAuthorizer reads the policy file. Requests which name no environment are checked as requests
+// for defaultEnvironment.
+func NewAuthorizer(path string, defaultEnvironment string) (*Authorizer, error) {
+	a := &Authorizer{path: path, defaultEnvironment: defaultEnvironment}
+	if _, err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the policy file if it was modified since it was last read, and tells whether it
+// was. An invalid policy is reported and the previous one is kept.
+func (a *Authorizer) Reload() (bool, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+
+	a.lock.RLock()
+	unchanged := a.policy != nil && info.ModTime().Equal(a.modified)
+	a.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return false, err
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return false, err
+	}
+
+	a.lock.Lock()
+	a.policy = policy
+	a.modified = info.ModTime()
+	a.lock.Unlock()
+	return true, nil
+}
+
+// Run looks for changes of the policy file every interval until stop is closed
+func (a *Authorizer) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := a.Reload()
+			if err != nil {
+				logging.Default().Error("Unable to reload the RBAC policy, keeping the previous one", "path", a.path, "error", err)
+			} else if reloaded {
+				logging.Default().Info("Reloaded the RBAC policy", "path", a.path)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ForbiddenError names the permission a principal lacks
+type ForbiddenError struct {
+	Principal   string
+	Permission  Permission
+	Namespace   string
+	Environment string
+}
+
+func (e *ForbiddenError) Error() string {
+	message := fmt.Sprintf("%s lacks the %s permission in namespace %s", e.Principal, e.Permission, e.Namespace)
+	if len(e.Environment) > 0 {
+		message += " of environment " + e.Environment
+	}
+	return message
+}
+
+// Check returns a ForbiddenError for the first permission the principal of the context lacks in
+// the namespace and environment of the context
+func (a *Authorizer) Check(ctx context.Context, permissions []Permission) error {
+	principal := PrincipalFromContext(ctx)
+	namespace := rancher.NamespaceFromContext(ctx)
+	environment := a.defaultEnvironment
+	if named, ok := rancher.EnvironmentFromContext(ctx); ok {
+		environment = named.Name
+	}
+
+	a.lock.RLock()
+	policy := a.policy
+	a.lock.RUnlock()
+
+	for _, permission := range permissions {
+		if !policy.Allowed(principal, permission, namespace, environment) {
+			name := "anonymous"
+			if principal != nil {
+				name = principal.Name
+			}
+			return &ForbiddenError{Principal: name, Permission: permission, Namespace: namespace, Environment: environment}
+		}
+	}
+	return nil
+}
+
+// Require wraps the handler of a route so that requests lacking the permissions it requires are
+// answered with 403, naming the missing permission
+func (a *Authorizer) Require(permissions PermissionsFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		required, err := permissions(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err := a.Check(r.Context(), required); err != nil {
+			logging.FromContext(r.Context()).Warn("Denied request", "method", r.Method, "path", r.URL.Path, "reason", err)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden: " + err.Error()))
+			return
+		}
+		next(w, r)
+	}
+}
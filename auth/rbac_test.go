@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+bindings:
+  - subject: alice
+    role: admin
+  - subject: ci
+    role: deployer
+    namespaces: [team-a]
+  - subject: "*"
+    role: reader
+`
+
+func Test_Policy_Allowed(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	policy, err := ParsePolicy([]byte(testPolicy))
+	assert.Nil(err)
+	alice := &Principal{Name: "alice"}
+	ci := &Principal{Name: "ci"}
+	bob := &Principal{Name: "bob"}
+
+	// Assert
+	assert.True(policy.Allowed(alice, PermissionDelete, "team-b", "production"))
+	assert.True(policy.Allowed(ci, PermissionDeploy, "team-a", "production"))
+	assert.False(policy.Allowed(ci, PermissionDeploy, rancher.DefaultNamespace, "production"))
+	assert.False(policy.Allowed(ci, PermissionScale, "team-a", "production"))
+	assert.True(policy.Allowed(ci, PermissionRead, rancher.DefaultNamespace, "production"), "the wildcard binding wasn't applied")
+	assert.True(policy.Allowed(bob, PermissionInvoke, "team-b", "production"))
+	assert.False(policy.Allowed(bob, PermissionDeploy, "team-b", "production"))
+	assert.False(policy.Allowed(nil, PermissionRead, rancher.DefaultNamespace, "production"))
+}
+
+func Test_Authorizer_Denies_Binding_Of_Other_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "rbac")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yml")
+	writePolicy(t, path, `
+bindings:
+  - subject: ci
+    role: deployer
+    namespaces: [team-a]
+    environments: [staging]
+`, time.Now())
+	authorizer, _ := NewAuthorizer(path, "staging")
+	ctx := rancher.WithNamespace(WithPrincipal(context.Background(), &Principal{Name: "ci"}), "team-a")
+	production := rancher.WithEnvironment(ctx, rancher.Environment{Name: "production"})
+	staging := rancher.WithEnvironment(ctx, rancher.Environment{Name: "staging"})
+
+	// Act
+	defaultErr := authorizer.Check(ctx, []Permission{PermissionDeploy})
+	stagingErr := authorizer.Check(staging, []Permission{PermissionDeploy})
+	productionErr := authorizer.Check(production, []Permission{PermissionDeploy})
+
+	// Assert
+	assert.Nil(defaultErr)
+	assert.Nil(stagingErr)
+	assert.EqualError(productionErr, "ci lacks the deploy permission in namespace team-a of environment production")
+}
+
+func Test_ParsePolicy_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{
+		`bindings: [{subject: alice, role: superuser}]`,
+		`bindings: [{role: admin}]`,
+		`bindings: [{subject: alice, role: admin, namespaces: [Team_A]}]`,
+		`bindings: [{subject: alice, role: admin, environments: [""]}]`,
+		`bindings: alice`,
+	}
+	for _, data := range invalid {
+		_, err := ParsePolicy([]byte(data))
+		assert.NotNil(err, data)
+	}
+}
+
+func writePolicy(t *testing.T, path string, policy string, modified time.Time) {
+	if err := ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Authorizer_Require_Names_Missing_Permission(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "rbac")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yml")
+	writePolicy(t, path, testPolicy, time.Now())
+	authorizer, err := NewAuthorizer(path, "")
+	assert.Nil(err)
+
+	called := false
+	handler := authorizer.Require(Requires(PermissionScale), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	req, _ := http.NewRequest("POST", "/system/scale-function/some-function", nil)
+	ctx := rancher.WithNamespace(WithPrincipal(req.Context(), &Principal{Name: "ci"}), "team-a")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req.WithContext(ctx))
+
+	// Assert
+	assert.Equal(http.StatusForbidden, rr.Code)
+	assert.Equal("Forbidden: ci lacks the scale permission in namespace team-a", rr.Body.String())
+	assert.False(called)
+}
+
+func Test_Authorizer_Reloads_Changed_Policy(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "rbac")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yml")
+	started := time.Now().Add(-time.Minute)
+	writePolicy(t, path, testPolicy, started)
+	authorizer, _ := NewAuthorizer(path, "")
+	ctx := WithPrincipal(context.Background(), &Principal{Name: "ci"})
+
+	// Act
+	unchanged, unchangedErr := authorizer.Reload()
+	writePolicy(t, path, "bindings: [{subject: ci, role: operator}]", started.Add(time.Second))
+	reloaded, reloadErr := authorizer.Reload()
+	scaleErr := authorizer.Check(ctx, []Permission{PermissionScale})
+	writePolicy(t, path, "bindings: [{subject: ci, role: root}]", started.Add(time.Second*2))
+	_, invalidErr := authorizer.Reload()
+	keptErr := authorizer.Check(ctx, []Permission{PermissionScale})
+
+	// Assert
+	assert.False(unchanged)
+	assert.Nil(unchangedErr)
+	assert.True(reloaded)
+	assert.Nil(reloadErr)
+	assert.Nil(scaleErr)
+	assert.NotNil(invalidErr)
+	assert.Nil(keptErr, "the previous policy wasn't kept")
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/logging"
+)
+
+// CertificateReloader serves the certificate of a cert and key file pair, which is read again
+// when either file changes, so that rotated certificates are used without a restart
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock         sync.RWMutex
+	certificate  *tls.Certificate
+	certModified time.Time
+	keyModified  time.Time
+}
+
+// NewCertificateReloader reads the certificate and its key
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	c := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificate if its file or the key file was modified since they were last
+// read, and tells whether it was. A pair which doesn't match, e.g. when only one of the files was
+// replaced yet, is reported and the previous certificate is kept.
+func (c *CertificateReloader) Reload() (bool, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.RLock()
+	unchanged := c.certificate != nil && certInfo.ModTime().Equal(c.certModified) && keyInfo.ModTime().Equal(c.keyModified)
+	c.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	c.certificate = &certificate
+	c.certModified = certInfo.ModTime()
+	c.keyModified = keyInfo.ModTime()
+	c.lock.Unlock()
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config.GetCertificate
+func (c *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.certificate, nil
+}
+
+// Run looks for changes of the certificate and key files every interval until stop is closed
+func (c *CertificateReloader) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := c.Reload()
+			if err != nil {
+				logging.Default().Error("Unable to reload the TLS certificate, keeping the previous one", "certFile", c.certFile, "error", err)
+			} else if reloaded {
+				logging.Default().Info("Reloaded the TLS certificate", "certFile", c.certFile)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// NewTLSConfig creates the TLS config of the provider, which serves the certificates of the
+// reloader. When clientCAFile is set every connection has to present a client certificate
+// signed by one of its CAs.
+func NewTLSConfig(reloader *CertificateReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		// exec upgrades its connection to a websocket, which needs HTTP/1.1
+		NextProtos: []string{"http/1.1"},
+	}
+	if len(clientCAFile) == 0 {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// ClientCertificate accepts the requests made over connections which presented a verified client
+// certificate, as the common name of the certificate
+type ClientCertificate struct{}
+
+// Authenticate checks the verified client certificate of the connection
+func (ClientCertificate) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(name) == 0 {
+		return nil, fmt.Errorf("the client certificate has no common name")
+	}
+	return &Principal{Name: name, Method: "tls"}, nil
+}
+
+// Challenge is empty, a client certificate can't be asked for over HTTP
+func (ClientCertificate) Challenge() string {
+	return ""
+}
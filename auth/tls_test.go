@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA signs the certificates of a test
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+var serialNumber int64
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumber++
+	template.SerialNumber = big.NewInt(serialNumber)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCertificate, parentKey := template, key
+	if parent != nil {
+		parentCertificate, parentKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCertificate, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return certificate, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	certificate, key, certPEM, _ := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	return &testCA{certificate: certificate, key: key, pem: certPEM}
+}
+
+// issue signs a certificate for 127.0.0.1, usable by servers and clients
+func (ca *testCA) issue(t *testing.T, name string) ([]byte, []byte) {
+	_, _, certPEM, keyPEM := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca)
+	return certPEM, keyPEM
+}
+
+func writeFile(t *testing.T, path string, data []byte, modified time.Time) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modified, modified)
+}
+
+// serveTLS answers with the principal of the request over a listener of the config
+func serveTLS(t *testing.T, config *tls.Config) (string, func()) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := ClientCertificate{}.Authenticate(r)
+		if err != nil {
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Write([]byte(principal.Name))
+	})}
+	go server.Serve(listener)
+	return "https://" + listener.Addr().String(), func() { server.Close() }
+}
+
+// get requests the url trusting ca, presenting the client certificate when there is one
+func get(url string, ca *testCA, clientCert []byte, clientKey []byte) (string, error) {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	config := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		certificate, err := tls.X509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return "", err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return string(body), nil
+}
+
+func Test_CertificateReloader_Serves_Rotated_Certificate(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	started := time.Now().Add(-time.Minute)
+	oldCA, newCA := newTestCA(t, "old"), newTestCA(t, "new")
+	oldCert, oldKey := oldCA.issue(t, "provider")
+	writeFile(t, certFile, oldCert, started)
+	writeFile(t, keyFile, oldKey, started)
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	assert.Nil(err)
+	config, _ := NewTLSConfig(reloader, "")
+	url, stop := serveTLS(t, config)
+	defer stop()
+
+	// Act
+	unchanged, unchangedErr := reloader.Reload()
+	_, beforeErr := get(url, oldCA, nil, nil)
+	newCert, newKey := newCA.issue(t, "provider")
+	writeFile(t, certFile, newCert, started.Add(time.Second))
+	_, mismatchErr := reloader.Reload()
+	_, keptErr := get(url, oldCA, nil, nil)
+	writeFile(t, keyFile, newKey, started.Add(time.Second))
+	reloaded, reloadErr := reloader.Reload()
+	_, afterErr := get(url, newCA, nil, nil)
+
+	// Assert
+	assert.False(unchanged)
+	assert.Nil(unchangedErr)
+	assert.Nil(beforeErr)
+	assert.NotNil(mismatchErr, "a certificate without its key was loaded")
+	assert.Nil(keptErr, "the previous certificate wasn't kept")
+	assert.True(reloaded)
+	assert.Nil(reloadErr)
+	assert.Nil(afterErr)
+}
+
+func Test_NewTLSConfig_Requires_Client_Certificate_Of_CA(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	serverCA, clientCA, otherCA := newTestCA(t, "server"), newTestCA(t, "clients"), newTestCA(t, "other")
+	serverCert, serverKey := serverCA.issue(t, "provider")
+	writeFile(t, certFile, serverCert, time.Now())
+	writeFile(t, keyFile, serverKey, time.Now())
+	writeFile(t, caFile, clientCA.pem, time.Now())
+	reloader, _ := NewCertificateReloader(certFile, keyFile)
+	config, err := NewTLSConfig(reloader, caFile)
+	assert.Nil(err)
+	url, stop := serveTLS(t, config)
+	defer stop()
+	gatewayCert, gatewayKey := clientCA.issue(t, "gateway")
+	otherCert, otherKey := otherCA.issue(t, "gateway")
+
+	// Act
+	principal, gatewayErr := get(url, serverCA, gatewayCert, gatewayKey)
+	_, otherErr := get(url, serverCA, otherCert, otherKey)
+	_, anonymousErr := get(url, serverCA, nil, nil)
+
+	// Assert
+	assert.Nil(gatewayErr)
+	assert.Equal("gateway", principal)
+	assert.NotNil(otherErr, "a client certificate of another CA was accepted")
+	assert.NotNil(anonymousErr, "a connection without client certificate was accepted")
+}
+
+func Test_NewTLSConfig_Rejects_Client_CA_File_Without_PEM(t *testing.T) {
+	// Arrange
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, []byte("not a certificate"), time.Now())
+
+	// Act
+	_, err := NewTLSConfig(&CertificateReloader{}, caFile)
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func Test_ClientCertificate_Without_TLS_Has_No_Credentials(t *testing.T) {
+	// Arrange
+	req, _ := http.NewRequest("GET", "/system/functions", nil)
+
+	// Act
+	_, err := ClientCertificate{}.Authenticate(req)
+
+	// Assert
+	assert.Equal(t, ErrNoCredentials, err)
+}
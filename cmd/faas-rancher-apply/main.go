@@ -0,0 +1,261 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// faas-rancher-apply makes the functions of a faas-rancher stack match a stack document by
+// sending it to /system/apply, and prints the changes.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/auth"
+	"github.com/kenfdev/faas-rancher/types"
+)
+
+func main() {
+	provider := flag.String("url", "http://127.0.0.1:8080", "URL of faas-rancher")
+	file := flag.String("f", "stack.yml", "stack document in YAML or JSON, - reads stdin")
+	dryRun := flag.Bool("dry-run", false, "print the changes without making them")
+	prune := flag.Bool("prune", false, "delete the functions missing from the document")
+	namespace := flag.String("namespace", "", "namespace of the functions, the default one when empty")
+	environment := flag.String("environment", "", "Rancher environment of the functions, the default one when empty")
+	timeout := flag.Duration("timeout", time.Minute*5, "how long to wait for the changes to be made")
+	user := flag.String("user", "", "user of basic auth")
+	passwordFile := flag.String("password-file", "", "file holding the password of basic auth")
+	tokenFile := flag.String("token-file", "", "file holding a bearer token")
+	hmacSecretFile := flag.String("hmac-secret-file", "", "file holding the secret requests are signed with")
+	caFile := flag.String("ca", "", "PEM file of the CAs the certificate of faas-rancher is checked against, the system ones when empty")
+	certFile := flag.String("cert", "", "PEM file of the client certificate")
+	keyFile := flag.String("key", "", "PEM file of the key of the client certificate")
+	flag.Parse()
+
+	creds, err := readCredentials(*user, *passwordFile, *tokenFile, *hmacSecretFile)
+	if err != nil {
+		fail(err)
+	}
+	tlsConfig, err := makeTLSConfig(*caFile, *certFile, *keyFile)
+	if err != nil {
+		fail(err)
+	}
+
+	document, err := readDocument(*file)
+	if err != nil {
+		fail(err)
+	}
+
+	query := url.Values{}
+	query.Set("dryRun", fmt.Sprint(*dryRun))
+	if len(*namespace) > 0 {
+		query.Set("namespace", *namespace)
+	}
+	query.Set("prune", fmt.Sprint(*prune))
+	applyURL := strings.TrimRight(*provider, "/") + "/system/apply?" + query.Encode()
+
+	request, err := http.NewRequest("POST", applyURL, bytes.NewReader(document))
+	if err != nil {
+		fail(err)
+	}
+	request.Header.Set("Content-Type", "application/x-yaml")
+	if len(*environment) > 0 {
+		request.Header.Set("X-Rancher-Environment", *environment)
+	}
+	creds.authorize(request, document)
+
+	httpClient := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		fail(err)
+	}
+	result := readResult(resp)
+
+	// the changes are made in the background, their progress is polled until they are done
+	deadline := time.Now().Add(*timeout)
+	for resp.StatusCode == http.StatusAccepted && result.State == "running" {
+		if time.Now().After(deadline) {
+			printResult(result)
+			fail(fmt.Errorf("the apply %s is still running after %s", result.ID, *timeout))
+		}
+		time.Sleep(pollInterval)
+
+		statusRequest, err := http.NewRequest("GET", strings.TrimRight(*provider, "/")+resp.Header.Get("Location")+namespaceQuery(*namespace), nil)
+		if err != nil {
+			fail(err)
+		}
+		if len(*environment) > 0 {
+			statusRequest.Header.Set("X-Rancher-Environment", *environment)
+		}
+		creds.authorize(statusRequest, nil)
+		statusResp, err := httpClient.Do(statusRequest)
+		if err != nil {
+			fail(err)
+		}
+		if statusResp.StatusCode != http.StatusOK {
+			readResult(statusResp)
+			fail(fmt.Errorf("%s: the progress of the apply %s is unknown", statusResp.Status, result.ID))
+		}
+		result = readResult(statusResp)
+	}
+	printResult(result)
+
+	if (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted) || result.State == "failed" {
+		os.Exit(1)
+	}
+}
+
+// pollInterval is how often the progress of an apply is asked for
+const pollInterval = time.Second
+
+// credentials authenticate the requests with the methods the provider was configured with
+type credentials struct {
+	user       string
+	password   string
+	token      string
+	hmacSecret []byte
+}
+
+func readCredentials(user string, passwordFile string, tokenFile string, hmacSecretFile string) (credentials, error) {
+	creds := credentials{user: user}
+	if len(user) > 0 && len(tokenFile) > 0 {
+		return creds, fmt.Errorf("-user and -token-file both set the Authorization header, pass one of them")
+	}
+	var err error
+	if len(passwordFile) > 0 {
+		if creds.password, err = readSecret(passwordFile); err != nil {
+			return creds, err
+		}
+	}
+	if len(tokenFile) > 0 {
+		if creds.token, err = readSecret(tokenFile); err != nil {
+			return creds, err
+		}
+	}
+	if len(hmacSecretFile) > 0 {
+		secret, err := readSecret(hmacSecretFile)
+		if err != nil {
+			return creds, err
+		}
+		creds.hmacSecret = []byte(secret)
+	}
+	return creds, nil
+}
+
+// makeTLSConfig trusts the CAs of caFile, the system ones without it, and presents the client
+// certificate of certFile and keyFile
+func makeTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			return nil, fmt.Errorf("-cert and -key have to be passed together")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// readSecret reads a secret file without its trailing newline
+func readSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// authorize sets the credentials of a request, signing it and its body when there's a secret
+func (c credentials) authorize(request *http.Request, body []byte) {
+	if len(c.user) > 0 {
+		request.SetBasicAuth(c.user, c.password)
+	}
+	if len(c.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if len(c.hmacSecret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(auth.TimestampHeader, timestamp)
+		request.Header.Set(auth.SignatureHeader, "sha256="+auth.Sign(c.hmacSecret, timestamp, request.Method, request.URL.RequestURI(), body))
+	}
+}
+
+// readResult reads the result the response answered with, any other body is a failure
+func readResult(resp *http.Response) types.ApplyResult {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(err)
+	}
+
+	result := types.ApplyResult{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		fail(fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	return result
+}
+
+func namespaceQuery(namespace string) string {
+	if len(namespace) == 0 {
+		return ""
+	}
+	return "?" + url.Values{"namespace": []string{namespace}}.Encode()
+}
+
+func readDocument(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+func printResult(result types.ApplyResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tFUNCTION\tSTATUS")
+	for _, change := range result.Changes {
+		status := ""
+		switch {
+		case len(change.Error) > 0:
+			status = "failed: " + change.Error
+		case change.Applied:
+			status = "applied"
+		case result.DryRun && change.Action != "unchanged":
+			status = "dry run"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", change.Action, change.Name, status)
+	}
+	w.Flush()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
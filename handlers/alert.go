@@ -0,0 +1,187 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexellis/faas/gateway/requests"
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// AlertScaler scales functions in steps as AlertManager reports them firing or resolved
+type AlertScaler struct {
+	client rancher.BridgeClient
+	// scaleFactor is the percentage of a function's maximum replicas added per firing alert
+	scaleFactor int64
+	// cooldown is the minimum time between two scaling operations on the same function
+	cooldown time.Duration
+	// maxReplicas caps the scale of every function, ignored when zero
+	maxReplicas int64
+
+	lock       sync.Mutex
+	lastScaled map[string]time.Time
+	now        func() time.Time
+}
+
+// NewAlertScaler creates a scaler which steps by scaleFactor percent of the maximum replicas
+func NewAlertScaler(client rancher.BridgeClient, scaleFactor int64, cooldown time.Duration, maxReplicas int64) *AlertScaler {
+	return &AlertScaler{
+		client:      client,
+		scaleFactor: scaleFactor,
+		cooldown:    cooldown,
+		maxReplicas: maxReplicas,
+		lastScaled:  make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// MakeAlertHandler handles AlertManager webhooks, scaling the functions named in the alerts
+func MakeAlertHandler(scaler *AlertScaler) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+
+		defer r.Body.Close()
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		alert := requests.PrometheusAlert{}
+		err := json.Unmarshal(body, &alert)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Cannot parse AlertManager payload."))
+			return
+		}
+
+		failed := []string{}
+		for _, inner := range alert.Alerts {
+			functionName := inner.Labels.FunctionName
+			if len(functionName) == 0 {
+				continue
+			}
+
+			status := inner.Status
+			if len(status) == 0 {
+				status = alert.Status
+			}
+
+			if scaleErr := scaler.Scale(r.Context(), functionName, status == "firing"); scaleErr != nil {
+				logging.FromContext(r.Context()).Error("Unable to scale on alert", "function", functionName, "error", scaleErr)
+				failed = append(failed, functionName)
+			}
+		}
+
+		if len(failed) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to scale " + strings.Join(failed, ", ")))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Scale steps the function up while its alert fires and back to its minimum once resolved, a
+// function scaled to zero is left asleep. Resolved alerts aren't held by the cooldown,
+// AlertManager only sends them once. The lock only guards the cooldowns, so that a slow Rancher
+// doesn't hold the alerts of the other functions.
+func (s *AlertScaler) Scale(ctx context.Context, functionName string, firing bool) error {
+	now := s.now()
+	last, scaled, reserved := s.reserve(functionName, firing, now)
+	if !reserved {
+		logging.FromContext(ctx).Info("Not scaling, in cooldown", "function", functionName, "lastScaled", now.Sub(last))
+		return nil
+	}
+
+	changed, err := s.scale(ctx, functionName, firing)
+	if err != nil || !changed {
+		s.release(functionName, now, last, scaled)
+	}
+	return err
+}
+
+// reserve starts the cooldown of the function, unless a firing alert finds it already started.
+// The previous start is returned so that it can be restored when nothing was scaled.
+func (s *AlertScaler) reserve(functionName string, firing bool, now time.Time) (time.Time, bool, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	last, ok := s.lastScaled[functionName]
+	if ok && firing && now.Sub(last) < s.cooldown {
+		return last, ok, false
+	}
+	s.lastScaled[functionName] = now
+	return last, ok, true
+}
+
+// release restores the cooldown the function had before reserve, unless it was reserved again since
+func (s *AlertScaler) release(functionName string, reserved time.Time, last time.Time, scaled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.lastScaled[functionName].Equal(reserved) {
+		return
+	}
+	if scaled {
+		s.lastScaled[functionName] = last
+	} else {
+		delete(s.lastScaled, functionName)
+	}
+}
+
+// scale updates the scale of the function, it tells whether it changed
+func (s *AlertScaler) scale(ctx context.Context, functionName string, firing bool) (bool, error) {
+	service, findErr := s.client.FindServiceByNameWithContext(ctx, functionName)
+	if findErr != nil {
+		return false, findErr
+	}
+	if service == nil || !IsFunction(service) {
+		return false, fmt.Errorf("No function named %s found.", functionName)
+	}
+
+	min, max := ReplicaBounds(service, s.maxReplicas)
+
+	replicas := min
+	if firing {
+		// the steps of functions without a maximum are a percentage of defaultMaxReplicas
+		stepOf := max
+		if max == math.MaxInt64 {
+			stepOf = defaultMaxReplicas
+		}
+		step := (stepOf*s.scaleFactor + 99) / 100
+		replicas = service.Scale + step
+		if replicas > max {
+			replicas = max
+		}
+		if replicas < min {
+			replicas = min
+		}
+	} else if service.Scale == 0 {
+		return false, nil
+	}
+
+	if replicas == service.Scale {
+		return false, nil
+	}
+
+	logging.FromContext(ctx).Info("Scaling on alert", "function", functionName, "from", service.Scale, "to", replicas)
+	updates := make(map[string]string)
+	updates["scale"] = strconv.FormatInt(replicas, 10)
+	_, err := s.client.UpdateServiceWithContext(ctx, service, updates)
+	recordAudit(ctx, audit.OperationScale, functionName, service, scaleAudit{From: service.Scale, To: replicas, Reason: "alert"}, err)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeAlertRequest(status string, functionName string) *http.Request {
+	body := fmt.Sprintf(`{"status":"%s","receiver":"scale-up","alerts":[{"status":"%s","labels":{"alertname":"APIHighInvocationRate","function_name":"%s"}}]}`,
+		status, status, functionName)
+	req, _ := http.NewRequest("POST", "/system/alert", bytes.NewReader([]byte(body)))
+	return req
+}
+
+func makeScalableService(scale int64, min string, max string) *client.Service {
+	return &client.Service{
+		Name:  "some-service",
+		State: "active",
+		Scale: scale,
+		LaunchConfig: &client.LaunchConfig{
+			Labels: map[string]interface{}{
+				FaasFunctionLabel: "some-service",
+				ScaleMinLabel:     min,
+				ScaleMaxLabel:     max,
+			},
+		},
+	}
+}
+
+func Test_MakeAlertHandler_Firing_Steps_Up(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(1, "1", "10")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "3"}).Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAlertHandler_Firing_Respects_Max(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 50, time.Minute, 0))
+
+	service := makeScalableService(4, "1", "5")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "5"}).Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAlertHandler_Resolved_Scales_To_Min(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(8, "2", "10")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "2"}).Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("resolved", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAlertHandler_Cooldown(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	scaler := NewAlertScaler(mockClient, 20, time.Minute, 0)
+	handler := MakeAlertHandler(scaler)
+
+	service := makeScalableService(1, "1", "10")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "3"}).Return(service, nil)
+
+	// Act
+	handler(httptest.NewRecorder(), makeAlertRequest("firing", "some-service"), nil)
+	rr := httptest.NewRecorder()
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertNumberOfCalls(t, "UpdateServiceWithContext", 1)
+}
+
+func Test_MakeAlertHandler_Resolved_Bypasses_Cooldown(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(1, "1", "10")
+	scaledUp := makeScalableService(3, "1", "10")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil).Once()
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "3"}).Return(scaledUp, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(scaledUp, nil).Once()
+	mockClient.On("UpdateServiceWithContext", mock.Anything, scaledUp, map[string]string{"scale": "1"}).Return(service, nil)
+
+	// Act
+	handler(httptest.NewRecorder(), makeAlertRequest("firing", "some-service"), nil)
+	rr := httptest.NewRecorder()
+	handler(rr, makeAlertRequest("resolved", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAlertHandler_Resolved_Leaves_Function_Scaled_To_Zero(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(0, "2", "10")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("resolved", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_MakeAlertHandler_Firing_Without_Max_Is_Bounded_Like_Other_Scaling(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(20, "1", "")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "24"}).Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeAlertHandler_Rejects_Service_Which_Is_Not_A_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+
+	service := makeScalableService(1, "1", "10")
+	delete(service.LaunchConfig.Labels, FaasFunctionLabel)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_AlertScaler_Scale_Does_Not_Wait_For_Other_Functions(t *testing.T) {
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	scaler := NewAlertScaler(mockClient, 20, time.Minute, 0)
+
+	release := make(chan time.Time)
+	slow := makeScalableService(1, "1", "10")
+	fast := makeScalableService(1, "1", "10")
+	fast.Name = "other-service"
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(slow, nil).WaitUntil(release)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "other-service").Return(fast, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "3"}).Return(fast, nil)
+	go scaler.Scale(context.Background(), "some-service", true)
+	time.Sleep(20 * time.Millisecond)
+
+	// Act
+	scaled := make(chan error, 1)
+	go func() { scaled <- scaler.Scale(context.Background(), "other-service", true) }()
+
+	// Assert
+	select {
+	case err := <-scaled:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Error("the alert waited for the scaling of another function")
+	}
+	close(release)
+}
+
+func Test_MakeAlertHandler_Bad_Payload(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+	req, _ := http.NewRequest("POST", "/system/alert", bytes.NewReader([]byte(`{alerts:`)))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
+
+func Test_MakeAlertHandler_Scale_Error(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeAlertHandler(NewAlertScaler(mockClient, 20, time.Minute, 0))
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, fmt.Errorf("Error"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeAlertRequest("firing", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,336 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// The actions of an ApplyChange
+const (
+	applyCreate    = "create"
+	applyUpdate    = "update"
+	applyUnchanged = "unchanged"
+	applyDelete    = "delete"
+)
+
+// plannedChange is an ApplyChange with what is needed to make it
+type plannedChange struct {
+	types.ApplyChange
+
+	existing *client.Service
+	spec     *client.Service
+	request  *types.CreateFunctionRequest
+}
+
+// MakeApplyHandler creates a handler which makes the functions of the stack match a stack document
+// in YAML or JSON. Functions missing from the document are only deleted with ?prune=true, and
+// ?dryRun=true reports the changes without making them. The changes are made in the background,
+// the handler answers 202 with the planned changes once the document is checked, and their
+// progress is reported at /system/apply/{id}.
+func MakeApplyHandler(bridge rancher.BridgeClient, applies *Applies) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		defer r.Body.Close()
+
+		dryRun, dryRunErr := parseBoolQuery(r, "dryRun")
+		if dryRunErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(dryRunErr.Error()))
+			return
+		}
+		prune, pruneErr := parseBoolQuery(r, "prune")
+		if pruneErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(pruneErr.Error()))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		document, err := types.ParseStackDocument(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid stack document: %s", err)))
+			return
+		}
+		if err := validateStackDocument(document); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		services, err := bridge.ListServicesWithContext(r.Context())
+		if err != nil {
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		changes, err := planApply(document, services, prune)
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		status := http.StatusOK
+		result := types.ApplyResult{DryRun: true, Changes: []types.ApplyChange{}}
+		for _, change := range changes {
+			result.Changes = append(result.Changes, change.ApplyChange)
+		}
+		if !dryRun {
+			status = http.StatusAccepted
+			result = applies.start(r.Context(), bridge, changes)
+			w.Header().Set("Location", "/system/apply/"+result.ID)
+		}
+
+		resultBytes, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(resultBytes)
+	}
+}
+
+// MakeApplyStatusHandler creates a handler which reports the progress of an apply made in the
+// background. Applies are only found from the namespace and environment they were made in.
+func MakeApplyStatusHandler(applies *Applies) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		result, ok := applies.result(r.Context(), vars["id"])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("Apply %s not found", vars["id"])))
+			return
+		}
+
+		resultBytes, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resultBytes)
+	}
+}
+
+// validateStackDocument checks the functions of the document the way a deploy request is checked,
+// and that no function is declared twice
+func validateStackDocument(document *types.StackDocument) error {
+	names := make(map[string]bool)
+	for i := range document.Functions {
+		function := &document.Functions[i]
+		if err := validateFunctionRequest(function); err != nil {
+			return err
+		}
+		if names[function.Service] {
+			return fmt.Errorf("(%s) is declared more than once", function.Service)
+		}
+		names[function.Service] = true
+	}
+	return nil
+}
+
+// planApply diffs the document against the services of the stack. The functions of the document
+// come first in their order, followed by the deletions sorted by name when pruning.
+func planApply(document *types.StackDocument, services []client.Service, prune bool) ([]*plannedChange, error) {
+	existing := make(map[string]*client.Service)
+	for i := range services {
+		existing[services[i].Name] = &services[i]
+	}
+
+	changes := []*plannedChange{}
+	declared := make(map[string]bool)
+	for _, function := range document.Functions {
+		declared[function.Service] = true
+		spec := makeServiceSpec(function)
+		summary := audit.RedactFunctionRequest(function)
+		change := &plannedChange{ApplyChange: types.ApplyChange{Name: function.Service}, spec: spec, request: &summary}
+
+		service, ok := existing[function.Service]
+		switch {
+		case !ok:
+			change.Action = applyCreate
+		case !IsFunction(service):
+			return nil, fmt.Errorf("Service %s exists and is not a function", function.Service)
+		case specChanged(service, spec):
+			change.Action = applyUpdate
+			change.existing = service
+		default:
+			change.Action = applyUnchanged
+			change.existing = service
+		}
+		changes = append(changes, change)
+	}
+
+	if !prune {
+		return changes, nil
+	}
+
+	deletions := []*plannedChange{}
+	for _, service := range existing {
+		if IsFunction(service) && !declared[service.Name] {
+			deletions = append(deletions, &plannedChange{
+				ApplyChange: types.ApplyChange{Name: service.Name, Action: applyDelete},
+				existing:    service,
+			})
+		}
+	}
+	sort.Sort(changesByName(deletions))
+
+	return append(changes, deletions...), nil
+}
+
+// The states of an apply made in the background
+const (
+	applyRunning   = "running"
+	applySucceeded = "succeeded"
+	applyFailed    = "failed"
+)
+
+// keptApplies is how many applies Applies reports the progress of, older ones are forgotten
+const keptApplies = 100
+
+// Applies makes the changes of applies in the background and keeps their progress
+type Applies struct {
+	background rancher.Background
+
+	lock    sync.Mutex
+	applies map[string]*applyJob
+	// order has the ids from the oldest apply
+	order []string
+}
+
+// applyJob is an apply made in the background
+type applyJob struct {
+	id          string
+	namespace   string
+	environment string
+	state       string
+	changes     []*plannedChange
+}
+
+// NewApplies creates an Applies without applies, which makes their changes in background
+func NewApplies(background rancher.Background) *Applies {
+	return &Applies{background: background, applies: make(map[string]*applyJob)}
+}
+
+// start makes the changes in the background and returns the result they start from. They outlive
+// the request of ctx, whose namespace, environment, logger and caller they are made and audited with,
+// and are cancelled at shutdown.
+func (a *Applies) start(ctx context.Context, bridge rancher.BridgeClient, changes []*plannedChange) types.ApplyResult {
+	job := &applyJob{
+		id:        logging.NewRequestID(),
+		namespace: rancher.NamespaceFromContext(ctx),
+		state:     applyRunning,
+		changes:   changes,
+	}
+	if environment, ok := rancher.EnvironmentFromContext(ctx); ok {
+		job.environment = environment.Name
+	}
+
+	a.lock.Lock()
+	a.applies[job.id] = job
+	a.order = append(a.order, job.id)
+	if len(a.order) > keptApplies {
+		delete(a.applies, a.order[0])
+		a.order = a.order[1:]
+	}
+	result := job.result()
+	a.lock.Unlock()
+
+	logging.FromContext(ctx).Info("Started apply", "apply", job.id, "changes", len(changes))
+	a.background.Go(func(background context.Context) {
+		a.run(detachedContext{Context: ctx, background: background}, bridge, job)
+	})
+	return result
+}
+
+// result reports the progress of the apply id made from the namespace and environment of ctx
+func (a *Applies) result(ctx context.Context, id string) (types.ApplyResult, bool) {
+	environment, _ := rancher.EnvironmentFromContext(ctx)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	job, ok := a.applies[id]
+	if !ok || job.namespace != rancher.NamespaceFromContext(ctx) || job.environment != environment.Name {
+		return types.ApplyResult{}, false
+	}
+	return job.result(), true
+}
+
+// run makes the changes of the job in order and stops at the first failure, which is recorded on
+// the change
+func (a *Applies) run(ctx context.Context, bridge rancher.BridgeClient, job *applyJob) {
+	state := applySucceeded
+	for _, change := range job.changes {
+		var err error
+		switch change.Action {
+		case applyCreate:
+			var created *client.Service
+			created, err = bridge.CreateServiceWithContext(ctx, change.spec)
+			recordAudit(ctx, audit.OperationDeploy, change.Name, created, change.request, err)
+		case applyUpdate:
+			_, err = bridge.UpgradeServiceWithContext(ctx, change.existing, change.spec.LaunchConfig)
+			recordAudit(ctx, audit.OperationUpdate, change.Name, change.existing, change.request, err)
+		case applyDelete:
+			err = bridge.DeleteServiceWithContext(ctx, change.existing)
+			recordAudit(ctx, audit.OperationDelete, change.Name, change.existing, nil, err)
+		default:
+			continue
+		}
+
+		a.lock.Lock()
+		if err != nil {
+			change.Error = err.Error()
+		} else {
+			change.Applied = true
+		}
+		a.lock.Unlock()
+
+		if err != nil {
+			logging.FromContext(ctx).Error("Unable to apply change", "apply", job.id, "action", change.Action, "function", change.Name, "error", err)
+			state = applyFailed
+			break
+		}
+		logging.FromContext(ctx).Info("Applied change", "apply", job.id, "action", change.Action, "function", change.Name)
+	}
+
+	a.lock.Lock()
+	job.state = state
+	a.lock.Unlock()
+	logging.FromContext(ctx).Info("Finished apply", "apply", job.id, "state", state)
+}
+
+// result has to be called with the lock of the Applies held
+func (j *applyJob) result() types.ApplyResult {
+	result := types.ApplyResult{ID: j.id, State: j.state, Changes: []types.ApplyChange{}}
+	for _, change := range j.changes {
+		result.Changes = append(result.Changes, change.ApplyChange)
+	}
+	return result
+}
+
+// detachedContext has the values of its context, such as the namespace and the caller of a
+// request, without being cancelled with it. It is cancelled with background instead.
+type detachedContext struct {
+	context.Context
+	background context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) { return c.background.Deadline() }
+func (c detachedContext) Done() <-chan struct{}       { return c.background.Done() }
+func (c detachedContext) Err() error                  { return c.background.Err() }
+
+type changesByName []*plannedChange
+
+func (c changesByName) Len() int           { return len(c) }
+func (c changesByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c changesByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const applyDocument = `
+functions:
+  - service: unchanged-fn
+    image: some/unchanged
+  - service: changed-fn
+    image: some/changed
+  - service: new-fn
+    image: some/new
+    envVars:
+      SOME_ENV: SOME_VALUE
+`
+
+func makeApplyServices() []client.Service {
+	request := types.CreateFunctionRequest{}
+	request.Service = "unchanged-fn"
+	request.Image = "some/unchanged"
+	unchanged := makeServiceSpec(request)
+
+	return []client.Service{
+		*unchanged,
+		{Name: "changed-fn", LaunchConfig: &client.LaunchConfig{Labels: map[string]interface{}{"faas_function": "changed-fn"}}},
+		{Name: "stale-fn", LaunchConfig: &client.LaunchConfig{Labels: map[string]interface{}{"faas_function": "stale-fn"}}},
+		{Name: "not-a-function", LaunchConfig: &client.LaunchConfig{}},
+	}
+}
+
+func doApply(handler VarsHandler, query string, document string) (*httptest.ResponseRecorder, types.ApplyResult) {
+	req, _ := http.NewRequest("POST", "/system/apply"+query, bytes.NewReader([]byte(document)))
+	rr := httptest.NewRecorder()
+	handler(rr, req, nil)
+
+	result := types.ApplyResult{}
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	return rr, result
+}
+
+// waitForApply polls the progress of the apply until it finished
+func waitForApply(t *testing.T, applies *Applies, id string) types.ApplyResult {
+	handler := MakeApplyStatusHandler(applies)
+	for i := 0; i < 100; i++ {
+		req, _ := http.NewRequest("GET", "/system/apply/"+id, nil)
+		rr := httptest.NewRecorder()
+		handler(rr, req, map[string]string{"id": id})
+
+		result := types.ApplyResult{}
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		if rr.Code != http.StatusOK || result.State != "running" {
+			return result
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("the apply didn't finish")
+	return types.ApplyResult{}
+}
+
+// shutdownBackground runs work with a context which is cancelled by shutdown
+type shutdownBackground struct {
+	ctx context.Context
+}
+
+func (b shutdownBackground) Go(work func(ctx context.Context)) {
+	go work(b.ctx)
+}
+
+func Test_MakeApplyHandler_Apply_Is_Cancelled_At_Shutdown(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	ctx, shutdown := context.WithCancel(context.Background())
+	applies := NewApplies(shutdownBackground{ctx: ctx})
+	handler := MakeApplyHandler(mockClient, applies)
+	upgrading := make(chan bool, 1)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+		upgrading <- true
+		<-args.Get(0).(context.Context).Done()
+	})
+
+	// Act
+	_, started := doApply(handler, "?prune=true", applyDocument)
+	<-upgrading
+	shutdown()
+	result := waitForApply(t, applies, started.ID)
+
+	// Assert
+	assert.Equal("failed", result.State)
+	assert.Equal("context canceled", result.Changes[1].Error)
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_MakeApplyHandler_Dry_Run_Reports_Diff(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeApplyHandler(mockClient, NewApplies(rancher.Untracked{}))
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+
+	// Act
+	rr, result := doApply(handler, "?dryRun=true&prune=true", applyDocument)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.True(result.DryRun)
+	assert.Equal([]types.ApplyChange{
+		{Name: "unchanged-fn", Action: "unchanged"},
+		{Name: "changed-fn", Action: "update"},
+		{Name: "new-fn", Action: "create"},
+		{Name: "stale-fn", Action: "delete"},
+	}, result.Changes)
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_MakeApplyHandler_Applies_Changes_In_Order(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	applies := NewApplies(rancher.Untracked{})
+	handler := MakeApplyHandler(mockClient, applies)
+	var calls []string
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool { return s.Name == "changed-fn" }),
+		mock.MatchedBy(func(l *client.LaunchConfig) bool { return l.ImageUuid == "docker:some/changed" }),
+	).Return(nil, nil).Run(func(mock.Arguments) { calls = append(calls, "update") })
+	mockClient.On("CreateServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool {
+			return s.Name == "new-fn" && s.LaunchConfig.Environment["SOME_ENV"] == "SOME_VALUE"
+		}),
+	).Return(nil, nil).Run(func(mock.Arguments) { calls = append(calls, "create") })
+	mockClient.On("DeleteServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool { return s.Name == "stale-fn" }),
+	).Return(nil).Run(func(mock.Arguments) { calls = append(calls, "delete") })
+
+	// Act
+	rr, started := doApply(handler, "?prune=true", applyDocument)
+	result := waitForApply(t, applies, started.ID)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Equal("/system/apply/"+started.ID, rr.Header().Get("Location"))
+	assert.Equal("running", started.State)
+	assert.Len(started.Changes, 4)
+	assert.Equal("succeeded", result.State)
+	assert.Equal([]string{"update", "create", "delete"}, calls)
+	assert.False(result.Changes[0].Applied)
+	assert.True(result.Changes[1].Applied)
+	assert.True(result.Changes[3].Applied)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeApplyHandler_Without_Prune_Keeps_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeApplyHandler(mockClient, NewApplies(rancher.Untracked{}))
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+
+	// Act
+	rr, result := doApply(handler, "?dryRun=true", applyDocument)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Len(result.Changes, 3)
+}
+
+func Test_MakeApplyHandler_Stops_At_First_Failure(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	applies := NewApplies(rancher.Untracked{})
+	handler := MakeApplyHandler(mockClient, applies)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Error"))
+
+	// Act
+	rr, started := doApply(handler, "?prune=true", applyDocument)
+	result := waitForApply(t, applies, started.ID)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.Equal("failed", result.State)
+	assert.Equal("Error", result.Changes[1].Error)
+	assert.False(result.Changes[2].Applied)
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "DeleteServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_MakeApplyHandler_Service_Not_A_Function_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeApplyHandler(mockClient, NewApplies(rancher.Untracked{}))
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+
+	// Act
+	rr, _ := doApply(handler, "", `{"functions":[{"service":"not-a-function","image":"some/image"}]}`)
+
+	// Assert
+	assert.Equal(http.StatusConflict, rr.Code)
+}
+
+func Test_MakeApplyHandler_Duplicate_Function_Is_Bad_Request(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeApplyHandler(mockClient, NewApplies(rancher.Untracked{}))
+
+	// Act
+	rr, _ := doApply(handler, "", `{"functions":[{"service":"some-fn"},{"service":"some-fn"}]}`)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertNotCalled(t, "ListServicesWithContext", mock.Anything)
+}
+
+func Test_MakeApplyStatusHandler_Apply_Of_Other_Namespace_Is_Not_Found(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	applies := NewApplies(rancher.Untracked{})
+	handler := MakeApplyHandler(mockClient, applies)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeApplyServices(), nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything, mock.Anything).Return(nil, nil)
+	_, started := doApply(handler, "", applyDocument)
+	waitForApply(t, applies, started.ID)
+
+	// Act
+	req, _ := http.NewRequest("GET", "/system/apply/"+started.ID, nil)
+	req = req.WithContext(rancher.WithNamespace(req.Context(), "team-a"))
+	rr := httptest.NewRecorder()
+	MakeApplyStatusHandler(applies)(rr, req, map[string]string{"id": started.ID})
+
+	// Assert
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// scaleAudit is the request summary of a scale operation
+type scaleAudit struct {
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// recordAudit records an operation on a function in the audit log of the request of ctx.
+// The service is the one operated on, if it is known.
+func recordAudit(ctx context.Context, operation string, function string, service *client.Service, request interface{}, err error) {
+	entry := audit.Entry{Operation: operation, Function: function, Request: request}
+	if service != nil {
+		entry.ServiceID = service.Id
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(ctx, entry)
+}
+
+// RecordScale records the scaling of a function to replicas by a background loop, reason tells
+// which one
+func RecordScale(ctx context.Context, service *client.Service, replicas int64, reason string, err error) {
+	recordAudit(ctx, audit.OperationScale, service.Name, service, scaleAudit{From: service.Scale, To: replicas, Reason: reason}, err)
+}
+
+// MakeAuditHandler creates a handler which lists the audit entries, oldest first. ?since= takes
+// an RFC3339 time or a duration such as 24h counted back from now, all entries are listed without it.
+func MakeAuditHandler(auditLog *audit.Log) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		since, sinceErr := parseSince(r.URL.Query().Get("since"), time.Now())
+		if sinceErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(sinceErr.Error()))
+			return
+		}
+
+		entries, err := auditLog.Since(since)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		entriesBytes, _ := json.Marshal(entries)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(entriesBytes)
+	}
+}
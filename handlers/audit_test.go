@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// serveAudited serves the request through the audit middleware
+func serveAudited(auditLog *audit.Log, handler VarsHandler, req *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	audit.MakeMiddleware(auditLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, vars)
+	})).ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_MakeDeployHandler_Audits_Deploy_With_Redacted_Request(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-function").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything, mock.Anything).Return(&client.Service{Resource: client.Resource{Id: "1s9"}}, nil)
+	body := `{"service":"some-function","image":"some-image","envVars":{"DB_PASSWORD":"hunter2"}}`
+	req, _ := http.NewRequest("POST", "/system/functions", bytes.NewReader([]byte(body)))
+
+	// Act
+	rr := serveAudited(auditLog, MakeDeployHandler(mockClient), req, nil)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	entries, _ := auditLog.Since(time.Time{})
+	assert.Len(entries, 1)
+	assert.Equal("deploy", entries[0].Operation)
+	assert.Equal("some-function", entries[0].Function)
+	assert.Equal("1s9", entries[0].ServiceID)
+	assert.Equal("success", entries[0].Outcome)
+	entryBytes, _ := json.Marshal(entries[0])
+	assert.NotContains(string(entryBytes), "hunter2")
+}
+
+func Test_MakeReplicaUpdater_Audits_Failed_Scale(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	mockClient := new(mocks.BridgeClient)
+	service := &client.Service{Resource: client.Resource{Id: "1s9"}, Name: "some-function", Scale: 1,
+		LaunchConfig: &client.LaunchConfig{Labels: map[string]interface{}{FaasFunctionLabel: "some-function"}}}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-function").Return(service, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, mock.Anything).Return(nil, errors.New("rancher is down"))
+	req, _ := http.NewRequest("POST", "/system/scale-function/some-function", bytes.NewReader([]byte(`{"replicas":3}`)))
+
+	// Act
+	serveAudited(auditLog, MakeReplicaUpdater(mockClient, 0), req, map[string]string{"name": "some-function"})
+
+	// Assert
+	entries, _ := auditLog.Since(time.Time{})
+	assert.Len(entries, 1)
+	assert.Equal("scale", entries[0].Operation)
+	assert.Equal("failure", entries[0].Outcome)
+	assert.Equal("rancher is down", entries[0].Error)
+	assert.Equal(map[string]interface{}{"from": float64(1), "to": float64(3)}, roundTrip(entries[0].Request))
+}
+
+func Test_MakeAuditHandler_Filters_By_Since(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	auditLog.Write(audit.Entry{Time: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), Function: "old"})
+	auditLog.Write(audit.Entry{Time: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), Function: "new"})
+	handler := MakeAuditHandler(auditLog)
+	req, _ := http.NewRequest("GET", "/system/audit?since=2018-03-01T00:00:00Z", nil)
+	invalid, _ := http.NewRequest("GET", "/system/audit?since=yesterday", nil)
+	rr := httptest.NewRecorder()
+	invalidRR := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+	handler(invalidRR, invalid, nil)
+
+	// Assert
+	entries := []audit.Entry{}
+	json.Unmarshal(rr.Body.Bytes(), &entries)
+	assert.Len(entries, 1)
+	assert.Equal("new", entries[0].Function)
+	assert.Equal(http.StatusBadRequest, invalidRR.Code)
+}
+
+func roundTrip(value interface{}) interface{} {
+	b, _ := json.Marshal(value)
+	var decoded interface{}
+	json.Unmarshal(b, &decoded)
+	return decoded
+}
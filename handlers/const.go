@@ -8,4 +8,25 @@ package handlers
 const (
 	// FaasFunctionLabel is the label set to faas function containers
 	FaasFunctionLabel = "faas_function"
+	// ScaleMinLabel is the minimum number of replicas a function is scaled to
+	ScaleMinLabel = "com.openfaas.scale.min"
+	// ScaleMaxLabel is the maximum number of replicas a function is scaled to
+	ScaleMaxLabel = "com.openfaas.scale.max"
+	// ScaleTargetLabel is the number of concurrent invocations each replica should handle,
+	// functions without it are left alone by the autoscaler
+	ScaleTargetLabel = "com.openfaas.scale.target"
+	// ScaleZeroLabel set to "false" opts a function out of being scaled to zero when idle
+	ScaleZeroLabel = "com.openfaas.scale.zero"
+	// SpecHashLabel is the hash of the deployed function spec, comparing it tells whether a
+	// deploy request changes the function
+	SpecHashLabel = "com.openfaas.spec.hash"
+	// SpecLabel holds the deploy request of a function as JSON, drift is corrected back to it
+	SpecLabel = "com.openfaas.spec"
+	// JanitorExcludeLabel set to "true" keeps the janitor from removing a failed function
+	JanitorExcludeLabel = "com.openfaas.janitor.exclude"
+
+	// defaultMinReplicas is used when a function has no ScaleMinLabel
+	defaultMinReplicas = 1
+	// defaultMaxReplicas is what the alert steps of a function without maximum are a percentage of
+	defaultMaxReplicas = 20
 )
@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/alexellis/faas/gateway/requests"
+	"github.com/kenfdev/faas-rancher/audit"
 	"github.com/kenfdev/faas-rancher/rancher"
 )
 
@@ -33,18 +34,19 @@ func MakeDeleteHandler(client rancher.BridgeClient) VarsHandler {
 		}
 
 		// This makes sure we don't delete non-labelled deployments
-		service, findErr := client.FindServiceByName(request.FunctionName)
+		service, findErr := client.FindServiceByNameWithContext(r.Context(), request.FunctionName)
 		if findErr != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			writeBridgeError(w, r, findErr, http.StatusInternalServerError, "")
 			return
 		} else if service == nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		delErr := client.DeleteService(service)
+		delErr := client.DeleteServiceWithContext(r.Context(), service)
+		recordAudit(r.Context(), audit.OperationDelete, request.FunctionName, service, request, delErr)
 		if delErr != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeBridgeError(w, r, delErr, http.StatusBadRequest, "")
 			return
 		}
 
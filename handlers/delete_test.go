@@ -13,6 +13,7 @@ import (
 	"github.com/kenfdev/faas/gateway/requests"
 	"github.com/rancher/go-rancher/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func Test_MakeDeleteHandler_Service_Delete_Success(t *testing.T) {
@@ -40,8 +41,8 @@ func Test_MakeDeleteHandler_Service_Delete_Success(t *testing.T) {
 	expectedService := client.Service{
 		Name: "some_rancher_service",
 	}
-	mockClient.On("FindServiceByName", functionName).Return(&expectedService, nil)
-	mockClient.On("DeleteService", &expectedService).Return(nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, functionName).Return(&expectedService, nil)
+	mockClient.On("DeleteServiceWithContext", mock.Anything, &expectedService).Return(nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -123,7 +124,7 @@ func Test_MakeDeleteHandler_Service_Find_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("FindServiceByName", functionName).Return(nil, fmt.Errorf("Internal Server Error"))
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, functionName).Return(nil, fmt.Errorf("Internal Server Error"))
 
 	// Act
 	handler(rr, req, nil)
@@ -155,7 +156,7 @@ func Test_MakeDeleteHandler_Service_Nil_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("FindServiceByName", functionName).Return(nil, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, functionName).Return(nil, nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -189,8 +190,8 @@ func Test_MakeDeleteHandler_Service_Delete_Error(t *testing.T) {
 	expectedService := client.Service{
 		Name: "some_rancher_service",
 	}
-	mockClient.On("FindServiceByName", functionName).Return(&expectedService, nil)
-	mockClient.On("DeleteService", &expectedService).Return(fmt.Errorf("Service Delete Failed"))
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, functionName).Return(&expectedService, nil)
+	mockClient.On("DeleteServiceWithContext", mock.Anything, &expectedService).Return(fmt.Errorf("Service Delete Failed"))
 
 	// Act
 	handler(rr, req, nil)
@@ -4,15 +4,22 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/alexellis/faas/gateway/requests"
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
 	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
 	"github.com/rancher/go-rancher/v2"
 )
 
@@ -27,7 +34,9 @@ func ValidateDeployRequest(request *requests.CreateFunctionRequest) error {
 	return fmt.Errorf("(%s) must be a valid DNS entry for service name", request.Service)
 }
 
-// MakeDeployHandler creates a handler to create new functions in the cluster
+// MakeDeployHandler creates a handler to create new functions in the cluster. Deploying a function
+// which exists with the same spec changes nothing, a different spec is a conflict unless the
+// request passes upsert=true to upgrade the function.
 func MakeDeployHandler(client rancher.BridgeClient) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
@@ -35,38 +44,200 @@ func MakeDeployHandler(client rancher.BridgeClient) VarsHandler {
 
 		body, _ := ioutil.ReadAll(r.Body)
 
-		request := requests.CreateFunctionRequest{}
+		request := types.CreateFunctionRequest{}
 		err := json.Unmarshal(body, &request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if err := ValidateDeployRequest(&request); err != nil {
+		if err := validateFunctionRequest(&request); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		serviceSpec := makeServiceSpec(request)
+		wait, waitErr := parseWait(r)
+		if waitErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(waitErr.Error()))
+			return
+		}
 
-		_, err = client.CreateService(serviceSpec)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+		upsert, upsertErr := parseBoolQuery(r, "upsert")
+		if upsertErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(upsertErr.Error()))
 			return
 		}
 
-		log.Println("Created service - " + request.Service)
-		log.Println(string(body))
+		outcome := deployFunction(r.Context(), client, request, upsert)
+		if outcome.err != nil {
+			writeBridgeError(w, r, outcome.err, outcome.status, outcome.message)
+			return
+		}
 
-		w.WriteHeader(http.StatusAccepted)
+		if wait > 0 && outcome.status < http.StatusMultipleChoices {
+			respondWhenReady(r.Context(), w, client, request.Service, outcome.replicas, wait)
+			return
+		}
 
+		w.WriteHeader(outcome.status)
+		if len(outcome.message) > 0 {
+			w.Write([]byte(outcome.message))
+		}
 	}
 }
 
-func makeServiceSpec(request requests.CreateFunctionRequest) *client.Service {
+// deployOutcome is the answer to a deploy request
+type deployOutcome struct {
+	// status is 202 when the function was created or upgraded, 200 when it was unchanged and
+	// 409 when it conflicts with the deployed service
+	status  int
+	message string
+	// err is the failed call to Rancher, if any
+	err error
+	// replicas is the scale of the deployed function
+	replicas int64
+}
+
+// deployFunction creates the function unless it exists. An existing function with the same spec
+// is left alone, one with a different spec is a conflict unless upsert is set to upgrade it.
+// Functions deployed before their spec was hashed are adopted, i.e. upgraded to the request.
+// The request must have been validated.
+func deployFunction(ctx context.Context, bridge rancher.BridgeClient, request types.CreateFunctionRequest, upsert bool) deployOutcome {
+	spec := makeServiceSpec(request)
+	summary := audit.RedactFunctionRequest(request)
+
+	existing, err := bridge.FindServiceByNameWithContext(ctx, request.Service)
+	if err != nil {
+		return deployOutcome{status: http.StatusInternalServerError, message: err.Error(), err: err}
+	}
+
+	if existing == nil {
+		created, err := bridge.CreateServiceWithContext(ctx, spec)
+		recordAudit(ctx, audit.OperationDeploy, request.Service, created, summary, err)
+		if err != nil {
+			if alreadyExists(err) {
+				return deployOutcome{status: http.StatusConflict, message: fmt.Sprintf("Function %s already exists", request.Service), err: err}
+			}
+			return deployOutcome{status: http.StatusInternalServerError, message: err.Error(), err: err}
+		}
+
+		logging.FromContext(ctx).Info("Created function", "function", request.Service, "image", request.Image)
+		return deployOutcome{status: http.StatusAccepted, replicas: spec.Scale}
+	}
+
+	if !IsFunction(existing) {
+		conflict := fmt.Errorf("Service %s exists and is not a function", request.Service)
+		recordAudit(ctx, audit.OperationDeploy, request.Service, existing, summary, conflict)
+		return deployOutcome{status: http.StatusConflict, message: conflict.Error()}
+	}
+
+	if !specChanged(existing, spec) {
+		return deployOutcome{status: http.StatusOK, replicas: existing.Scale}
+	}
+
+	adopt := !specKnown(existing)
+	if !upsert && !adopt {
+		conflict := fmt.Errorf("Function %s exists with a different spec, deploy with upsert=true to upgrade it", request.Service)
+		recordAudit(ctx, audit.OperationUpdate, request.Service, existing, summary, conflict)
+		return deployOutcome{status: http.StatusConflict, message: conflict.Error()}
+	}
+
+	_, err = bridge.UpgradeServiceWithContext(ctx, existing, spec.LaunchConfig)
+	recordAudit(ctx, audit.OperationUpdate, request.Service, existing, summary, err)
+	if rancher.UpgradeInProgress(err) {
+		return deployOutcome{status: http.StatusConflict, message: err.Error()}
+	}
+	if err != nil {
+		return deployOutcome{status: http.StatusInternalServerError, message: err.Error(), err: err}
+	}
+
+	logging.FromContext(ctx).Info("Started upgrading function", "function", request.Service, "image", request.Image, "adopted", adopt)
+	return deployOutcome{status: http.StatusAccepted, replicas: existing.Scale}
+}
+
+// parseBoolQuery reads a boolean query parameter, which defaults to false
+func parseBoolQuery(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return false, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("(%s) is not a valid %s value", value, name)
+	}
+	return parsed, nil
+}
+
+// alreadyExists tells whether Rancher refused to create a service because its name is taken
+func alreadyExists(err error) bool {
+	apiErr, ok := err.(*client.ApiError)
+	if !ok {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusConflict ||
+		(apiErr.StatusCode == 422 && strings.Contains(apiErr.Body, "NotUnique"))
+}
+
+// specKnown tells whether the spec the service was deployed from was hashed, which functions
+// deployed before the hash label existed lack
+func specKnown(existing *client.Service) bool {
+	_, ok := serviceLabel(existing, SpecHashLabel)
+	return ok
+}
+
+// specChanged tells whether the deployed service was deployed from another spec
+func specChanged(existing *client.Service, spec *client.Service) bool {
+	hash, _ := serviceLabel(existing, SpecHashLabel)
+	return hash != spec.LaunchConfig.Labels[SpecHashLabel]
+}
+
+// specHash hashes the name and launch config of a service spec
+func specHash(spec *client.Service) string {
+	b, _ := json.Marshal(struct {
+		Name         string               `json:"name"`
+		LaunchConfig *client.LaunchConfig `json:"launchConfig"`
+	}{spec.Name, spec.LaunchConfig})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashedValuePrefix marks the values of sensitive environment variables hashed in SpecLabel
+const hashedValuePrefix = "sha256:"
+
+// specLabel is the deploy request as stored in SpecLabel, without the scale which changes with
+// the load, without the registry credentials and with the values of sensitive environment
+// variables hashed, since everyone who reads the service reads its labels
+func specLabel(request types.CreateFunctionRequest) string {
+	request.Scale = 0
+	request.RegistryAuth = ""
+	request.EnvVars = hashSensitiveEnvVars(request.EnvVars)
+	b, _ := json.Marshal(request)
+	return string(b)
+}
+
+// hashSensitiveEnvVars returns a copy of the environment variables in which the values of the
+// sensitive ones, those the audit log redacts, are hashed
+func hashSensitiveEnvVars(envVars map[string]string) map[string]string {
+	if envVars == nil {
+		return nil
+	}
+	hashed := make(map[string]string, len(envVars))
+	for key, value := range envVars {
+		if logging.SensitiveKey(key) {
+			sum := sha256.Sum256([]byte(value))
+			value = hashedValuePrefix + hex.EncodeToString(sum[:])
+		}
+		hashed[key] = value
+	}
+	return hashed
+}
+
+func makeServiceSpec(request types.CreateFunctionRequest) *client.Service {
 
 	envVars := make(map[string]interface{})
 	for k, v := range request.EnvVars {
@@ -78,6 +249,9 @@ func makeServiceSpec(request requests.CreateFunctionRequest) *client.Service {
 	}
 
 	labels := make(map[string]interface{})
+	for k, v := range request.Labels {
+		labels[k] = v
+	}
 	labels[FaasFunctionLabel] = request.Service
 	labels["io.rancher.container.pull_image"] = "always"
 
@@ -87,12 +261,20 @@ func makeServiceSpec(request requests.CreateFunctionRequest) *client.Service {
 		Labels:      labels,
 	}
 
+	scale := request.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
 	serviceSpec := &client.Service{
 		Name:          request.Service,
-		Scale:         1,
+		Scale:         scale,
 		StartOnCreate: true,
 		LaunchConfig:  launchConfig,
 	}
+	applyResources(request, launchConfig)
+	labels[SpecHashLabel] = specHash(serviceSpec)
+	labels[SpecLabel] = specLabel(request)
 
 	return serviceSpec
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
 	"github.com/kenfdev/faas/gateway/requests"
 	"github.com/rancher/go-rancher/v2"
 	"github.com/stretchr/testify/assert"
@@ -40,7 +42,8 @@ func Test_MakeDeployHandler_Create_Service_Success(t *testing.T) {
 		log.Fatal(reqErr)
 	}
 
-	mockClient.On("CreateService",
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything,
 		mock.MatchedBy(func(s *client.Service) bool {
 			return s.Name == request.Service &&
 				s.Scale == 1 &&
@@ -127,7 +130,8 @@ func Test_MakeDeployHandler_Create_Service_Error(t *testing.T) {
 		log.Fatal(reqErr)
 	}
 
-	mockClient.On("CreateService",
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything,
 		mock.MatchedBy(func(s *client.Service) bool { return s.Name == request.Service }),
 	).Return(nil, fmt.Errorf("Error"))
 	rr := httptest.NewRecorder()
@@ -138,3 +142,242 @@ func Test_MakeDeployHandler_Create_Service_Error(t *testing.T) {
 	// Assert
 	assert.Equal(rr.Code, http.StatusInternalServerError)
 }
+
+func Test_MakeDeployHandler_Labels_Are_Added_To_LaunchConfig(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	b := []byte(`{"service":"some-service","image":"some/image","labels":{"com.openfaas.scale.zero":"false"}}`)
+	req, reqErr := http.NewRequest("POST", "/system/functions", bytes.NewReader(b))
+	if reqErr != nil {
+		log.Fatal(reqErr)
+	}
+
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool {
+			return s.LaunchConfig.Labels["com.openfaas.scale.zero"] == "false" &&
+				s.LaunchConfig.Labels["faas_function"] == "some-service"
+		}),
+	).Return(nil, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func makeDeployedService(hash string) *client.Service {
+	return &client.Service{
+		Name:  "some-service",
+		Scale: 2,
+		LaunchConfig: &client.LaunchConfig{
+			Labels: map[string]interface{}{
+				"faas_function":          "some-service",
+				"com.openfaas.spec.hash": hash,
+			},
+		},
+	}
+}
+
+func Test_MakeDeployHandler_Spec_Hash_Label_Is_Stable(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	b := []byte(`{"service":"some-service","image":"some/image","envVars":{"A":"1","B":"2"},"labels":{"x":"y"}}`)
+	request := types.CreateFunctionRequest{}
+	json.Unmarshal(b, &request)
+	changed := request
+	changed.Image = "some/other-image"
+
+	// Act
+	first := makeServiceSpec(request)
+	second := makeServiceSpec(request)
+	third := makeServiceSpec(changed)
+
+	// Assert
+	hash := first.LaunchConfig.Labels["com.openfaas.spec.hash"]
+	assert.NotEmpty(hash)
+	assert.Equal(hash, second.LaunchConfig.Labels["com.openfaas.spec.hash"])
+	assert.NotEqual(hash, third.LaunchConfig.Labels["com.openfaas.spec.hash"])
+}
+
+func Test_MakeServiceSpec_Spec_Label_Hashes_Sensitive_Values(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	request := types.CreateFunctionRequest{Service: "some-service", Image: "some/image",
+		EnvVars: map[string]string{"SOME_ENV": "SOME_VALUE", "DB_PASSWORD": "hunter2"}}
+
+	// Act
+	spec := makeServiceSpec(request)
+
+	// Assert
+	label := spec.LaunchConfig.Labels[SpecLabel].(string)
+	assert.NotContains(label, "hunter2")
+	assert.Contains(label, `"SOME_ENV":"SOME_VALUE"`)
+	assert.Contains(label, `"DB_PASSWORD":"sha256:`)
+	assert.Equal("hunter2", spec.LaunchConfig.Environment["DB_PASSWORD"])
+}
+
+func Test_MakeDeployHandler_Identical_Spec_Is_Ok(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	req := makeDeployRequest("/system/functions", "some-service")
+	request := types.CreateFunctionRequest{}
+	request.Service = "some-service"
+	hash := makeServiceSpec(request).LaunchConfig.Labels["com.openfaas.spec.hash"].(string)
+
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(makeDeployedService(hash), nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_MakeDeployHandler_Different_Spec_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(makeDeployedService("other"), nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Contains(rr.Body.String(), "upsert=true")
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_MakeDeployHandler_Different_Spec_With_Upsert_Upgrades(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	existing := makeDeployedService("other")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, existing,
+		mock.MatchedBy(func(l *client.LaunchConfig) bool {
+			return l.Labels["faas_function"] == "some-service" &&
+				l.Labels["com.openfaas.spec.hash"] != "other"
+		}),
+	).Return(existing, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions?upsert=true", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeDeployHandler_Adopts_Function_Without_Spec_Hash(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	existing := makeDeployedService("")
+	delete(existing.LaunchConfig.Labels, "com.openfaas.spec.hash")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, existing,
+		mock.MatchedBy(func(l *client.LaunchConfig) bool {
+			return l.Labels["com.openfaas.spec.hash"] != nil
+		}),
+	).Return(existing, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusAccepted, rr.Code)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeDeployHandler_Upgrade_In_Progress_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	existing := makeDeployedService("other")
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, existing, mock.Anything).
+		Return(nil, &rancher.UpgradeInProgressError{Name: "some-service"})
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions?upsert=true", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusConflict, rr.Code)
+	assert.Contains(rr.Body.String(), "in progress")
+}
+
+func Test_MakeDeployHandler_Existing_Service_Not_A_Function_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	existing := &client.Service{Name: "some-service", LaunchConfig: &client.LaunchConfig{}}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions?upsert=true", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusConflict, rr.Code)
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_MakeDeployHandler_Create_Not_Unique_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything, mock.Anything).
+		Return(nil, &client.ApiError{StatusCode: 422, Body: `{"code":"NotUnique","fieldName":"name"}`})
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusConflict, rr.Code)
+}
+
+func Test_MakeDeployHandler_Invalid_Upsert(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, makeDeployRequest("/system/functions?upsert=maybe", "some-service"), nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+}
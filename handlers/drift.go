@@ -0,0 +1,304 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// DriftReconciler compares the services of functions with the spec they were deployed with, which
+// is kept in their labels, to notice changes made in Rancher directly. When enforcing, the spec is
+// restored by upgrading the service and the scale is brought back within the function's bounds.
+type DriftReconciler struct {
+	client      rancher.BridgeClient
+	enforce     bool
+	maxReplicas int64
+	// auditLog records the corrections of Run, if set
+	auditLog *audit.Log
+
+	lock sync.Mutex
+	// drifted counts the drifted functions of each namespace, see scopeOf
+	drifted  map[string]int
+	checks   *metrics.Counter
+	corrects *metrics.Counter
+}
+
+// NewDriftReconciler creates a reconciler, which only corrects drift when enforce is set. The
+// corrections of Run are recorded into auditLog unless it is nil.
+func NewDriftReconciler(client rancher.BridgeClient, enforce bool, maxReplicas int64, auditLog *audit.Log, registry *metrics.Registry) *DriftReconciler {
+	r := &DriftReconciler{
+		client:      client,
+		enforce:     enforce,
+		maxReplicas: maxReplicas,
+		auditLog:    auditLog,
+		drifted:     make(map[string]int),
+		checks:      registry.NewCounter("faas_rancher_drift_checks_total", "Drift checks by result.", "result"),
+		corrects:    registry.NewCounter("faas_rancher_drift_corrections_total", "Corrections of drifted functions by result.", "result"),
+	}
+	registry.NewGaugeFunc("faas_rancher_drifted_functions", "Functions which drifted from their spec at the last check.", func() float64 {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		drifted := 0
+		for _, count := range r.drifted {
+			drifted += count
+		}
+		return float64(drifted)
+	})
+	return r
+}
+
+// Run reconciles every interval until stop is closed
+func (r *DriftReconciler) Run(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(audit.WithSystem(context.Background(), r.auditLog, "drift"))
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.Reconcile(ctx, r.enforce); err != nil {
+				logging.FromContext(ctx).Error("Unable to check functions for drift", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Reconcile reports the functions which drifted, and corrects them when correct is set
+func (r *DriftReconciler) Reconcile(ctx context.Context, correct bool) (types.DriftReport, error) {
+	report := types.DriftReport{Checked: time.Now(), Functions: []types.FunctionDrift{}}
+
+	services, err := r.client.ListServicesWithContext(ctx)
+	if err != nil {
+		r.checks.Inc("error")
+		return report, err
+	}
+	r.checks.Inc("success")
+
+	for i := range services {
+		service := &services[i]
+		// services being upgraded or stopped are not compared until they settle
+		if service.State != "active" || !IsFunction(service) {
+			continue
+		}
+
+		drift, desired := r.driftOf(service)
+		if len(drift.Fields) == 0 {
+			continue
+		}
+
+		if correct {
+			if err := r.correct(ctx, service, drift.Fields, desired); err != nil {
+				logging.FromContext(ctx).Error("Unable to correct drift", "function", service.Name, "error", err)
+				drift.Error = err.Error()
+				r.corrects.Inc("error")
+			} else {
+				logging.FromContext(ctx).Info("Corrected drift", "function", service.Name, "fields", drift.Fields)
+				drift.Corrected = true
+				r.corrects.Inc("success")
+			}
+		}
+		report.Functions = append(report.Functions, drift)
+	}
+
+	r.lock.Lock()
+	r.drifted[scopeOf(ctx)] = len(report.Functions)
+	r.lock.Unlock()
+	return report, nil
+}
+
+// driftOf compares a function with its spec, which is returned when it is known.
+// Functions deployed before the spec hash was stored are never reported.
+func (r *DriftReconciler) driftOf(service *client.Service) (types.FunctionDrift, *types.CreateFunctionRequest) {
+	drift := types.FunctionDrift{Name: service.Name, Fields: []string{}}
+
+	hash, ok := serviceLabel(service, SpecHashLabel)
+	if !ok {
+		return drift, nil
+	}
+
+	var desired *types.CreateFunctionRequest
+	if value, ok := serviceLabel(service, SpecLabel); ok {
+		desired = &types.CreateFunctionRequest{}
+		if err := json.Unmarshal([]byte(value), desired); err != nil {
+			desired = nil
+		}
+	}
+
+	actual := functionRequest(service)
+	if hash != makeServiceSpec(actual).LaunchConfig.Labels[SpecHashLabel] {
+		if desired == nil {
+			drift.Fields = append(drift.Fields, "spec")
+		} else {
+			labelled := actual
+			labelled.EnvVars = hashSensitiveEnvVars(actual.EnvVars)
+			drift.Fields = append(drift.Fields, changedFields(*desired, labelled)...)
+		}
+	}
+
+	if min, max := ReplicaBounds(service, r.maxReplicas); !(service.Scale == 0 && ScaleToZeroAllowed(service)) &&
+		(service.Scale < min || service.Scale > max) {
+		drift.Fields = append(drift.Fields, "scale")
+	}
+	return drift, desired
+}
+
+// changedFields names the fields of a deploy request which differ, empty maps and lists equal unset ones
+func changedFields(desired types.CreateFunctionRequest, actual types.CreateFunctionRequest) []string {
+	fields := []string{}
+	compare := func(name string, a interface{}, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+
+	compare("image", desired.Image, actual.Image)
+	compare("envProcess", desired.EnvProcess, actual.EnvProcess)
+	compare("envVars", emptyToNilMap(desired.EnvVars), emptyToNilMap(actual.EnvVars))
+	compare("labels", emptyToNilMap(desired.Labels), emptyToNilMap(actual.Labels))
+	compare("constraints", emptyToNilList(desired.Constraints), emptyToNilList(actual.Constraints))
+	compare("limits", normalizedLimits(desired.Limits), normalizedLimits(actual.Limits))
+	compare("secrets", emptyToNilList(desired.Secrets), emptyToNilList(actual.Secrets))
+
+	if len(fields) == 0 {
+		// something changed which isn't part of a deploy request
+		fields = append(fields, "spec")
+	}
+	return fields
+}
+
+func emptyToNilMap(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func emptyToNilList(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// normalizedLimits writes the limits the way exportFunction does, so that e.g. 1024m equals 1g
+func normalizedLimits(limits *types.FunctionResources) types.FunctionResources {
+	normalized := types.FunctionResources{}
+	if limits == nil {
+		return normalized
+	}
+	if memory, err := parseMemory(limits.Memory); err == nil {
+		normalized.Memory = formatMemory(memory)
+	}
+	if quota, err := parseCPU(limits.CPU); err == nil {
+		normalized.CPU = formatCPU(quota, cpuPeriod)
+	}
+	return normalized
+}
+
+// correct restores the spec of a drifted function and clamps its scale
+func (r *DriftReconciler) correct(ctx context.Context, service *client.Service, fields []string, desired *types.CreateFunctionRequest) error {
+	specDrifted, scaleDrifted := false, false
+	for _, field := range fields {
+		if field == "scale" {
+			scaleDrifted = true
+		} else {
+			specDrifted = true
+		}
+	}
+
+	if specDrifted {
+		if desired == nil {
+			return fmt.Errorf("the spec of %s is unknown, deploy it again with upsert=true", service.Name)
+		}
+		restored := *desired
+		envVars, err := restoreHashedEnvVars(service.Name, desired.EnvVars, functionRequest(service).EnvVars)
+		if err != nil {
+			return err
+		}
+		restored.EnvVars = envVars
+		desired = &restored
+		upgraded, err := r.client.UpgradeServiceWithContext(ctx, service, makeServiceSpec(*desired).LaunchConfig)
+		recordAudit(ctx, audit.OperationUpdate, service.Name, service, audit.RedactFunctionRequest(*desired), err)
+		if err != nil {
+			return err
+		}
+		service = upgraded
+	}
+
+	if !scaleDrifted {
+		return nil
+	}
+
+	min, max := ReplicaBounds(service, r.maxReplicas)
+	scale := service.Scale
+	if scale < min {
+		scale = min
+	}
+	if scale > max {
+		scale = max
+	}
+	updates := map[string]string{"scale": strconv.FormatInt(scale, 10)}
+	_, err := r.client.UpdateServiceWithContext(ctx, service, updates)
+	recordAudit(ctx, audit.OperationScale, service.Name, service, scaleAudit{From: service.Scale, To: scale, Reason: "drift"}, err)
+	return err
+}
+
+// restoreHashedEnvVars replaces the hashed values of the desired environment variables by the
+// actual ones, which only the function holds. A hashed value which changed can't be restored.
+func restoreHashedEnvVars(name string, desired map[string]string, actual map[string]string) (map[string]string, error) {
+	if desired == nil {
+		return nil, nil
+	}
+	hashedActual := hashSensitiveEnvVars(actual)
+	restored := make(map[string]string, len(desired))
+	for key, value := range desired {
+		if logging.SensitiveKey(key) && strings.HasPrefix(value, hashedValuePrefix) {
+			if hashedActual[key] != value {
+				return nil, fmt.Errorf("the value of %s of %s changed and only its hash is known, deploy it again with upsert=true", key, name)
+			}
+			value = actual[key]
+		}
+		restored[key] = value
+	}
+	return restored, nil
+}
+
+// MakeDriftHandler creates a handler which checks the functions for drift and reports it.
+// It never corrects the drift, which only the reconcile loop does when enforcing.
+func MakeDriftHandler(reconciler *DriftReconciler) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		report, err := reconciler.Reconcile(r.Context(), false)
+		if err != nil {
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		reportBytes, _ := json.Marshal(report)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(reportBytes)
+	}
+}
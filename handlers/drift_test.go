@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeDeployedFunction(name string, image string, labels map[string]string) *client.Service {
+	request := types.CreateFunctionRequest{Labels: labels}
+	request.Service = name
+	request.Image = image
+	request.EnvVars = map[string]string{"SOME_ENV": "SOME_VALUE", "DB_PASSWORD": "hunter2"}
+	service := makeServiceSpec(request)
+	service.State = "active"
+	return service
+}
+
+func Test_DriftReconciler_Reports_Changed_Fields(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	reconciler := NewDriftReconciler(mockClient, false, 0, nil, metrics.NewRegistry())
+
+	untouched := makeDeployedFunction("untouched", "some/image", nil)
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	edited.LaunchConfig.ImageUuid = "docker:some/other-image"
+	edited.LaunchConfig.Environment["OTHER_ENV"] = "OTHER_VALUE"
+	overScaled := makeDeployedFunction("over-scaled", "some/image", map[string]string{ScaleMaxLabel: "2"})
+	overScaled.Scale = 5
+	idle := makeDeployedFunction("idle", "some/image", nil)
+	idle.Scale = 0
+	legacy := &client.Service{Name: "legacy", State: "active", LaunchConfig: &client.LaunchConfig{
+		Labels: map[string]interface{}{FaasFunctionLabel: "legacy"},
+	}}
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(
+		[]client.Service{*untouched, *edited, *overScaled, *idle, *legacy}, nil)
+
+	// Act
+	report, err := reconciler.Reconcile(context.Background(), false)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal([]types.FunctionDrift{
+		{Name: "edited", Fields: []string{"image", "envVars"}},
+		{Name: "over-scaled", Fields: []string{"scale"}},
+	}, report.Functions)
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_DriftReconciler_Enforce_Restores_Spec_And_Scale(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	reconciler := NewDriftReconciler(mockClient, true, 0, nil, metrics.NewRegistry())
+
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	edited.LaunchConfig.ImageUuid = "docker:some/other-image"
+	edited.LaunchConfig.Labels[ScaleMinLabel] = "3"
+	edited.Scale = 1
+	upgraded := *edited
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*edited}, nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool { return s.Name == "edited" }),
+		mock.MatchedBy(func(l *client.LaunchConfig) bool {
+			return l.ImageUuid == "docker:some/image" && l.Labels[ScaleMinLabel] == nil && l.Environment["DB_PASSWORD"] == "hunter2"
+		}),
+	).Return(&upgraded, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, &upgraded, map[string]string{"scale": "3"}).Return(&upgraded, nil)
+
+	// Act
+	report, err := reconciler.Reconcile(context.Background(), true)
+
+	// Assert
+	assert.Nil(err)
+	assert.Len(report.Functions, 1)
+	assert.Equal([]string{"image", "labels", "scale"}, report.Functions[0].Fields)
+	assert.True(report.Functions[0].Corrected)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_DriftReconciler_Changed_Sensitive_Value_Is_Not_Corrected(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	reconciler := NewDriftReconciler(mockClient, true, 0, nil, metrics.NewRegistry())
+
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	edited.LaunchConfig.Environment["DB_PASSWORD"] = "changed"
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*edited}, nil)
+
+	// Act
+	report, _ := reconciler.Reconcile(context.Background(), true)
+
+	// Assert
+	assert.Equal([]string{"envVars"}, report.Functions[0].Fields)
+	assert.False(report.Functions[0].Corrected)
+	assert.Contains(report.Functions[0].Error, "DB_PASSWORD")
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_DriftReconciler_Unknown_Spec_Is_Not_Corrected(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	reconciler := NewDriftReconciler(mockClient, true, 0, nil, metrics.NewRegistry())
+
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	delete(edited.LaunchConfig.Labels, SpecLabel)
+	edited.LaunchConfig.ImageUuid = "docker:some/other-image"
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*edited}, nil)
+
+	// Act
+	report, _ := reconciler.Reconcile(context.Background(), true)
+
+	// Assert
+	assert.Equal([]string{"spec"}, report.Functions[0].Fields)
+	assert.False(report.Functions[0].Corrected)
+	assert.NotEmpty(report.Functions[0].Error)
+}
+
+func Test_MakeDriftHandler_Reports_Without_Correcting(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	registry := metrics.NewRegistry()
+	handler := MakeDriftHandler(NewDriftReconciler(mockClient, true, 0, nil, registry))
+
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	edited.LaunchConfig.ImageUuid = "docker:some/other-image"
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*edited}, nil)
+	req, _ := http.NewRequest("GET", "/system/drift", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	report := types.DriftReport{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	assert.Equal("edited", report.Functions[0].Name)
+	assert.False(report.Functions[0].Corrected)
+	mockClient.AssertNotCalled(t, "UpgradeServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+
+	metricsRecorder := httptest.NewRecorder()
+	registry.ServeHTTP(metricsRecorder, req)
+	assert.Contains(metricsRecorder.Body.String(), "faas_rancher_drifted_functions 1")
+}
+
+func Test_DriftReconciler_Counts_Drift_Of_Each_Namespace(t *testing.T) {
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	registry := metrics.NewRegistry()
+	reconciler := NewDriftReconciler(mockClient, false, 0, nil, registry)
+	teamA := rancher.WithNamespace(context.Background(), "team-a")
+	edited := makeDeployedFunction("edited", "some/image", nil)
+	edited.LaunchConfig.ImageUuid = "docker:some/other-image"
+	mockClient.On("ListServicesWithContext", teamA).Return([]client.Service{}, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*edited}, nil)
+
+	// Act
+	reconciler.Reconcile(context.Background(), false)
+	reconciler.Reconcile(teamA, false)
+
+	// Assert
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	metricsRecorder := httptest.NewRecorder()
+	registry.ServeHTTP(metricsRecorder, req)
+	assert.Contains(t, metricsRecorder.Body.String(), "faas_rancher_drifted_functions 1")
+}
+
+func Test_MakeDriftHandler_List_Error(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDriftHandler(NewDriftReconciler(mockClient, false, 0, nil, metrics.NewRegistry()))
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(nil, fmt.Errorf("Error"))
+	req, _ := http.NewRequest("GET", "/system/drift", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,34 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// EnvironmentHeader names the Rancher environment a request is for
+const EnvironmentHeader = "X-Rancher-Environment"
+
+// MakeEnvironmentMiddleware resolves the environment of a request from the EnvironmentHeader or
+// the namespace of the request, which MakeNamespaceMiddleware has to have read already.
+// Unknown environments and a namespace named with another environment are rejected with 400.
+// Requests for the default environment are passed on as they are.
+func MakeEnvironmentMiddleware(router *rancher.EnvironmentRouter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := rancher.NamespaceFromContext(r.Context())
+		environment, err := router.Resolve(r.Header.Get(EnvironmentHeader), namespace)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if environment.Name == router.DefaultName() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(rancher.WithEnvironment(r.Context(), environment)))
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeEnvironmentHandler(environments *[]string) http.Handler {
+	router := rancher.NewEnvironmentRouter("staging")
+	router.Add(rancher.Environment{Name: "staging", FunctionsStackName: "faas-functions"}, new(mocks.BridgeClient))
+	router.Add(rancher.Environment{Name: "prod", FunctionsStackName: "faas-prod", Namespaces: []string{"team-a"}}, new(mocks.BridgeClient))
+
+	return MakeNamespaceMiddleware(MakeEnvironmentMiddleware(router, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "none"
+		if environment, ok := rancher.EnvironmentFromContext(r.Context()); ok {
+			name = environment.Name
+		}
+		*environments = append(*environments, name)
+	})))
+}
+
+func Test_MakeEnvironmentMiddleware_Routes_By_Header_And_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	environments := []string{}
+	handler := makeEnvironmentHandler(&environments)
+
+	plain, _ := http.NewRequest("GET", "/system/functions", nil)
+	named, _ := http.NewRequest("GET", "/system/functions", nil)
+	named.Header.Set(EnvironmentHeader, "prod")
+	mapped, _ := http.NewRequest("GET", "/system/functions?namespace=team-a", nil)
+
+	// Act
+	for _, req := range []*http.Request{plain, named, mapped} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	assert.Equal([]string{"none", "prod", "prod"}, environments)
+}
+
+func Test_MakeEnvironmentMiddleware_Rejects_Unknown_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	environments := []string{}
+	handler := makeEnvironmentHandler(&environments)
+
+	unknown, _ := http.NewRequest("GET", "/system/functions", nil)
+	unknown.Header.Set(EnvironmentHeader, "dev")
+	conflicting, _ := http.NewRequest("GET", "/system/functions?namespace=team-a", nil)
+	conflicting.Header.Set(EnvironmentHeader, "staging")
+
+	for _, req := range []*http.Request{unknown, conflicting} {
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		assert.Equal(http.StatusBadRequest, rr.Code)
+	}
+	assert.Empty(environments)
+}
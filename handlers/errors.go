@@ -0,0 +1,30 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// writeBridgeError answers a failed call to Rancher made for the request with the status code and
+// message, or with 503 and Retry-After while calls to Cattle fail fast
+func writeBridgeError(w http.ResponseWriter, r *http.Request, err error, statusCode int, message string) {
+	logging.FromContext(r.Context()).Error("Rancher call failed", "method", r.Method, "path", r.URL.Path, "error", err)
+
+	if retryAfter, open := rancher.CircuitOpen(err); open {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		statusCode = http.StatusServiceUnavailable
+		message = err.Error()
+	}
+
+	w.WriteHeader(statusCode)
+	if len(message) > 0 {
+		w.Write([]byte(message))
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/rancher/go-rancher/v2"
+)
+
+var execUpgrader = websocket.Upgrader{}
+
+// MakeExecHandler runs a command inside an instance of a function and bridges its stdin and
+// stdout over a websocket. Callers authenticate with "Authorization: Bearer <adminToken>", an empty
+// adminToken leaves it to the auth middleware, which reads the same header.
+// Query parameters: instance (id or name, defaults to the first running one), command
+// (repeated for each argument, defaults to /bin/sh) and tty. Sessions are ended when streams are
+// closed.
+func MakeExecHandler(client rancher.BridgeClient, adminToken string, streams *Streams) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		if len(adminToken) > 0 && !validAdminToken(r, adminToken) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		functionName := vars["name"]
+
+		service, findErr := client.FindServiceByNameWithContext(r.Context(), functionName)
+		if findErr != nil {
+			writeBridgeError(w, r, findErr, http.StatusInternalServerError, "")
+			return
+		}
+		if service == nil || !IsFunction(service) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		containers, listErr := client.ListInstancesWithContext(r.Context(), service)
+		if listErr != nil {
+			writeBridgeError(w, r, listErr, http.StatusInternalServerError, "")
+			return
+		}
+
+		container := pickInstance(containers, r.URL.Query().Get("instance"))
+		if container == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("No running instance of " + functionName + " found"))
+			return
+		}
+
+		access, execErr := client.ContainerExecWithContext(r.Context(), container, makeContainerExec(r))
+		if execErr != nil {
+			writeBridgeError(w, r, execErr, http.StatusInternalServerError, "")
+			return
+		}
+
+		upstream, dialErr := rancher.DialHostAccess(access)
+		if dialErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to open exec", "instance", container.Name, "error", dialErr)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		conn, upgradeErr := execUpgrader.Upgrade(w, r, nil)
+		if upgradeErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to upgrade exec to a websocket", "error", upgradeErr)
+			return
+		}
+		defer conn.Close()
+		if !streams.track(conn) {
+			closeExec(conn)
+			return
+		}
+		defer streams.untrack(conn)
+
+		logging.FromContext(r.Context()).Info("Exec into instance", "instance", container.Name, "containerId", container.Id)
+		bridgeExec(conn, upstream, streams.Done())
+	}
+}
+
+// validAdminToken checks the bearer token of the request
+func validAdminToken(r *http.Request, adminToken string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// pickInstance returns the running container matching instance by id or name, or the first running one
+func pickInstance(containers []client.Container, instance string) *client.Container {
+	for i := range containers {
+		container := &containers[i]
+		if container.State != "running" {
+			continue
+		}
+		if len(instance) == 0 || container.Id == instance || container.Name == instance {
+			return container
+		}
+	}
+	return nil
+}
+
+func makeContainerExec(r *http.Request) *client.ContainerExec {
+	query := r.URL.Query()
+
+	command := query["command"]
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
+	}
+
+	return &client.ContainerExec{
+		AttachStdin:  true,
+		AttachStdout: true,
+		Command:      command,
+		Tty:          query.Get("tty") != "false",
+	}
+}
+
+// closeExec tells the client the session ends because the provider is going away
+func closeExec(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "the server is shutting down")
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
+// bridgeExec copies messages both ways until either side closes or stop is. Rancher's exec
+// websocket carries base64 encoded data while the client is sent and sends raw bytes.
+func bridgeExec(conn *websocket.Conn, upstream *websocket.Conn, stop <-chan struct{}) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			encoded := base64.StdEncoding.EncodeToString(data)
+			if err := upstream.WriteMessage(websocket.TextMessage, []byte(encoded)); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			_, data, err := upstream.ReadMessage()
+			if err != nil {
+				return
+			}
+			decoded, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				decoded = data
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, decoded); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-stop:
+		closeExec(conn)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// generatedLabels are set on every function by makeServiceSpec, so they are left out of exports
+var generatedLabels = map[string]bool{
+	FaasFunctionLabel:                 true,
+	SpecHashLabel:                     true,
+	SpecLabel:                         true,
+	HostAffinityLabel:                 true,
+	"io.rancher.container.pull_image": true,
+}
+
+// MakeExportHandler creates a handler which writes the functions of the stack as a stack document.
+// Secrets are exported by name, their values never leave Rancher, and the values of sensitive
+// environment variables are redacted.
+func MakeExportHandler(bridge rancher.BridgeClient) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		services, err := bridge.ListServicesWithContext(r.Context())
+		if err != nil {
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		document := types.StackDocument{Functions: []types.CreateFunctionRequest{}}
+		for i := range services {
+			if IsFunction(&services[i]) {
+				document.Functions = append(document.Functions, exportFunction(&services[i]))
+			}
+		}
+		sort.Sort(functionsByName(document.Functions))
+
+		documentBytes, _ := json.MarshalIndent(document, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(documentBytes)
+	}
+}
+
+// exportFunction is the deploy request of a function, with the values of its sensitive environment
+// variables redacted
+func exportFunction(service *client.Service) types.CreateFunctionRequest {
+	return audit.RedactFunctionRequest(functionRequest(service))
+}
+
+// functionRequest turns a function's service back into the deploy request it was made of. The
+// scale of a function scaled to zero is the minimum it wakes up to.
+func functionRequest(service *client.Service) types.CreateFunctionRequest {
+	function := types.CreateFunctionRequest{}
+	function.Service = service.Name
+	function.Scale = service.Scale
+	if function.Scale == 0 {
+		function.Scale = MinReplicas(service)
+	}
+
+	launchConfig := service.LaunchConfig
+	function.Image = strings.TrimPrefix(launchConfig.ImageUuid, "docker:")
+
+	for key, value := range launchConfig.Environment {
+		if key == "fprocess" {
+			function.EnvProcess = fmt.Sprint(value)
+			continue
+		}
+		if function.EnvVars == nil {
+			function.EnvVars = make(map[string]string)
+		}
+		function.EnvVars[key] = fmt.Sprint(value)
+	}
+
+	for key, value := range launchConfig.Labels {
+		if generatedLabels[key] {
+			continue
+		}
+		if function.Labels == nil {
+			function.Labels = make(map[string]string)
+		}
+		function.Labels[key] = fmt.Sprint(value)
+	}
+
+	if affinity, ok := serviceLabel(service, HostAffinityLabel); ok && len(affinity) > 0 {
+		function.Constraints = strings.Split(affinity, ",")
+	}
+
+	if launchConfig.Memory > 0 || launchConfig.CpuQuota > 0 {
+		function.Limits = &types.FunctionResources{}
+		if launchConfig.Memory > 0 {
+			function.Limits.Memory = formatMemory(launchConfig.Memory)
+		}
+		if launchConfig.CpuQuota > 0 && launchConfig.CpuPeriod > 0 {
+			function.Limits.CPU = formatCPU(launchConfig.CpuQuota, launchConfig.CpuPeriod)
+		}
+	}
+
+	for _, secret := range launchConfig.Secrets {
+		function.Secrets = append(function.Secrets, secret.Name)
+	}
+
+	return function
+}
+
+// MakeImportHandler creates a handler which deploys the functions of a stack document one by one,
+// as the deploy handler does, and reports the result of each. With ?upsert=true functions which
+// exist with a different spec are upgraded, the handler doesn't wait for the upgrades to finish.
+// Functions whose environment still holds values redacted by the export are rejected.
+func MakeImportHandler(bridge rancher.BridgeClient) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		defer r.Body.Close()
+
+		upsert, upsertErr := parseBoolQuery(r, "upsert")
+		if upsertErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(upsertErr.Error()))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		document, err := types.ParseStackDocument(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid stack document: %s", err)))
+			return
+		}
+
+		status := http.StatusOK
+		results := []types.ImportResult{}
+		for _, function := range document.Functions {
+			result := types.ImportResult{Name: function.Service}
+			if err := validateFunctionRequest(&function); err != nil {
+				result.Status = http.StatusBadRequest
+				result.Message = err.Error()
+			} else if key, redacted := redactedEnvVar(function); redacted {
+				result.Status = http.StatusBadRequest
+				result.Message = fmt.Sprintf("The value of %s was redacted by the export, set it before importing", key)
+			} else {
+				outcome := deployFunction(r.Context(), bridge, function, upsert)
+				result.Status = outcome.status
+				result.Message = outcome.message
+				if _, open := rancher.CircuitOpen(outcome.err); open {
+					result.Status = http.StatusServiceUnavailable
+					result.Message = outcome.err.Error()
+				}
+			}
+
+			if result.Status >= http.StatusMultipleChoices {
+				status = http.StatusMultiStatus
+			}
+			results = append(results, result)
+		}
+
+		resultBytes, _ := json.Marshal(results)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(resultBytes)
+	}
+}
+
+// redactedEnvVar names an environment variable of the function whose value was redacted
+func redactedEnvVar(function types.CreateFunctionRequest) (string, bool) {
+	for key, value := range function.EnvVars {
+		if value == logging.Redacted {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+type functionsByName []types.CreateFunctionRequest
+
+func (f functionsByName) Len() int           { return len(f) }
+func (f functionsByName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f functionsByName) Less(i, j int) bool { return f[i].Service < f[j].Service }
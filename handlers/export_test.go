@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const exportedFunction = `{
+  "service": "some-fn",
+  "image": "some/image:1.0",
+  "envProcess": "cat",
+  "envVars": {"SOME_ENV": "SOME_VALUE", "DB_PASSWORD": "hunter2"},
+  "labels": {"com.openfaas.scale.min": "2"},
+  "constraints": ["zone=a", "ssd=true"],
+  "limits": {"memory": "128m", "cpu": "0.5"},
+  "secrets": ["api-key"],
+  "scale": 3
+}`
+
+func makeExportedService(t *testing.T) *client.Service {
+	request := types.CreateFunctionRequest{}
+	if err := json.Unmarshal([]byte(exportedFunction), &request); err != nil {
+		t.Fatal(err)
+	}
+	service := makeServiceSpec(request)
+	// Rancher fills in the id of the secret
+	service.LaunchConfig.Secrets[0].SecretId = "1se1"
+	return service
+}
+
+func Test_MakeExportHandler_Exports_Functions(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeExportHandler(mockClient)
+	services := []client.Service{
+		*makeExportedService(t),
+		{Name: "not-a-function", LaunchConfig: &client.LaunchConfig{}},
+	}
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
+	req, _ := http.NewRequest("GET", "/system/export", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	document, err := types.ParseStackDocument(rr.Body.Bytes())
+	assert.Nil(err)
+	assert.Len(document.Functions, 1)
+
+	expected := types.CreateFunctionRequest{}
+	json.Unmarshal([]byte(exportedFunction), &expected)
+	expected.EnvVars["DB_PASSWORD"] = logging.Redacted
+	assert.Equal(expected, document.Functions[0])
+	assert.NotContains(rr.Body.String(), "1se1")
+	assert.NotContains(rr.Body.String(), "hunter2")
+}
+
+func Test_ExportFunction_Keeps_Spec_Hash(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	request := types.CreateFunctionRequest{}
+	json.Unmarshal([]byte(exportedFunction), &request)
+	// redacted values don't come back
+	delete(request.EnvVars, "DB_PASSWORD")
+	service := makeServiceSpec(request)
+
+	// Act
+	reimported := makeServiceSpec(exportFunction(service))
+
+	// Assert
+	assert.False(specChanged(service, reimported))
+}
+
+func Test_ExportFunction_Exports_Minimum_Scale_Of_Function_Scaled_To_Zero(t *testing.T) {
+	// Arrange
+	service := makeExportedService(t)
+	service.Scale = 0
+
+	// Act
+	function := exportFunction(service)
+
+	// Assert
+	assert.Equal(t, int64(2), function.Scale)
+}
+
+func Test_ExportFunction_Exports_Deployed_Scale(t *testing.T) {
+	// Arrange
+	service := makeExportedService(t)
+	delete(service.LaunchConfig.Labels, ScaleMinLabel)
+
+	// Act
+	function := exportFunction(service)
+
+	// Assert
+	assert.Equal(t, int64(3), function.Scale)
+}
+
+func Test_MakeImportHandler_Rejects_Redacted_Values(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeImportHandler(mockClient)
+	body := `{"functions":[{"service":"some-fn","image":"some/image:1.0","envVars":{"DB_PASSWORD":"` + logging.Redacted + `"}}]}`
+	req, _ := http.NewRequest("POST", "/system/import", bytes.NewReader([]byte(body)))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusMultiStatus, rr.Code)
+	results := []types.ImportResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if assert.Len(results, 1) {
+		assert.Equal(http.StatusBadRequest, results[0].Status)
+		assert.Contains(results[0].Message, "DB_PASSWORD")
+	}
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_MakeImportHandler_Upgrade_In_Progress_Is_Conflict(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeImportHandler(mockClient)
+	existing := makeExportedService(t)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-fn").Return(existing, nil)
+	mockClient.On("UpgradeServiceWithContext", mock.Anything, existing, mock.Anything).
+		Return(nil, &rancher.UpgradeInProgressError{Name: "some-fn"})
+	document := `{"functions":[{"service":"some-fn","image":"some/image:2.0"}]}`
+	req, _ := http.NewRequest("POST", "/system/import?upsert=true", bytes.NewReader([]byte(document)))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusMultiStatus, rr.Code)
+	results := []types.ImportResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	assert.Len(results, 1)
+	assert.Equal(http.StatusConflict, results[0].Status)
+}
+
+func Test_MakeImportHandler_Reports_Each_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeImportHandler(mockClient)
+	existing := makeExportedService(t)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-fn").Return(existing, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "new-fn").Return(nil, nil)
+	mockClient.On("CreateServiceWithContext", mock.Anything,
+		mock.MatchedBy(func(s *client.Service) bool { return s.Name == "new-fn" && s.Scale == 2 }),
+	).Return(nil, nil)
+	document := `{"functions":[` + exportedFunction + `,
+		{"service":"new-fn","image":"some/image","scale":2},
+		{"service":"bad_name","image":"some/image"}]}`
+	req, _ := http.NewRequest("POST", "/system/import", bytes.NewReader([]byte(document)))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusMultiStatus, rr.Code)
+	results := []types.ImportResult{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	assert.Len(results, 3)
+	assert.Equal(types.ImportResult{Name: "some-fn", Status: http.StatusOK}, results[0])
+	assert.Equal(types.ImportResult{Name: "new-fn", Status: http.StatusAccepted}, results[1])
+	assert.Equal(http.StatusBadRequest, results[2].Status)
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,270 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// failure is how and since when the janitor saw a service failing
+type failure struct {
+	reason string
+	since  time.Time
+}
+
+// failedService is a failing service along with its failure
+type failedService struct {
+	*client.Service
+	failure
+}
+
+// Janitor finds the function services left inactive, errored or never healthy, typically by failed
+// deploys, and removes those which failed for longer than the TTL. It only knows what it observed
+// since the provider started, so a service has to fail for a whole TTL in front of it. What it
+// observed is kept per namespace, see scopeOf, since a check only lists the services of one.
+type Janitor struct {
+	client rancher.BridgeClient
+	ttl    time.Duration
+	dryRun bool
+	clock  Clock
+	// auditLog records the removals of Run, if set
+	auditLog *audit.Log
+
+	lock   sync.Mutex
+	scopes map[string]*janitorScope
+
+	removed *metrics.Counter
+}
+
+// janitorScope is what the janitor observed of the services of one namespace
+type janitorScope struct {
+	failures    map[string]failure
+	everHealthy map[string]bool
+	expired     int
+}
+
+// NewJanitor creates a janitor, which only reports the garbage it finds when dryRun is set. The
+// removals of Run are recorded into auditLog unless it is nil.
+func NewJanitor(client rancher.BridgeClient, ttl time.Duration, dryRun bool, auditLog *audit.Log, registry *metrics.Registry) *Janitor {
+	j := &Janitor{
+		client:   client,
+		ttl:      ttl,
+		dryRun:   dryRun,
+		clock:    SystemClock{},
+		auditLog: auditLog,
+		scopes:   make(map[string]*janitorScope),
+		removed:  registry.NewCounter("faas_rancher_janitor_removals_total", "Removals of failed function services by result.", "result"),
+	}
+	registry.NewGaugeFunc("faas_rancher_janitor_expired_services", "Function services which failed for longer than the TTL at the last check.", func() float64 {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		expired := 0
+		for _, scope := range j.scopes {
+			expired += scope.expired
+		}
+		return float64(expired)
+	})
+	return j
+}
+
+// Run collects the garbage every interval until stop is closed
+func (j *Janitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(audit.WithSystem(context.Background(), j.auditLog, "janitor"))
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := j.Collect(ctx, j.dryRun); err != nil {
+				logging.FromContext(ctx).Error("Unable to look for failed functions", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Collect lists the failed function services and removes the expired ones unless dryRun is set.
+// Services labelled com.openfaas.janitor.exclude=true are left alone.
+func (j *Janitor) Collect(ctx context.Context, dryRun bool) (types.JanitorReport, error) {
+	now := j.clock.Now()
+	report := types.JanitorReport{Checked: now, DryRun: dryRun, Services: []types.FailedService{}}
+
+	services, err := j.client.ListServicesWithContext(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	scope := scopeOf(ctx)
+	for _, failed := range j.observe(scope, services, now) {
+		entry := types.FailedService{
+			Name:        failed.Name,
+			State:       failed.State,
+			HealthState: failed.HealthState,
+			Reason:      failed.reason,
+			Since:       failed.since,
+			Expired:     now.Sub(failed.since) >= j.ttl,
+		}
+
+		if entry.Expired && !dryRun {
+			err := j.client.DeleteServiceWithContext(ctx, failed.Service)
+			recordAudit(ctx, audit.OperationDelete, failed.Name, failed.Service, entry, err)
+			if err != nil {
+				logging.FromContext(ctx).Error("Unable to remove failed function", "function", failed.Name, "error", err)
+				entry.Error = err.Error()
+				j.removed.Inc("error")
+			} else {
+				logging.FromContext(ctx).Info("Removed failed function", "function", failed.Name, "reason", entry.Reason, "since", entry.Since)
+				entry.Removed = true
+				j.removed.Inc("success")
+			}
+		}
+		report.Services = append(report.Services, entry)
+	}
+
+	expired := 0
+	for _, entry := range report.Services {
+		if entry.Expired && !entry.Removed {
+			expired++
+		}
+	}
+	j.lock.Lock()
+	j.scope(scope).expired = expired
+	j.lock.Unlock()
+
+	return report, nil
+}
+
+// scope returns what was observed of the namespace named by scopeOf, it has to be called with the
+// lock held
+func (j *Janitor) scope(name string) *janitorScope {
+	scope, ok := j.scopes[name]
+	if !ok {
+		scope = &janitorScope{failures: make(map[string]failure), everHealthy: make(map[string]bool)}
+		j.scopes[name] = scope
+	}
+	return scope
+}
+
+// observe updates what is known about the function services of the scope and returns the failing
+// ones, sorted by name
+func (j *Janitor) observe(name string, services []client.Service, now time.Time) []failedService {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	scope := j.scope(name)
+
+	seen := make(map[string]bool)
+	failed := []failedService{}
+	for i := range services {
+		service := &services[i]
+		if !IsFunction(service) {
+			continue
+		}
+		seen[service.Id] = true
+		if service.HealthState == "healthy" {
+			scope.everHealthy[service.Id] = true
+		}
+
+		reason := scope.failureOf(service)
+		if len(reason) == 0 || janitorExcluded(service) {
+			delete(scope.failures, service.Id)
+			continue
+		}
+
+		// a service failing in another way starts over
+		if previous, ok := scope.failures[service.Id]; !ok || previous.reason != reason {
+			scope.failures[service.Id] = failure{reason: reason, since: now}
+		}
+		failed = append(failed, failedService{service, scope.failures[service.Id]})
+	}
+
+	for id := range scope.failures {
+		if !seen[id] {
+			delete(scope.failures, id)
+		}
+	}
+	for id := range scope.everHealthy {
+		if !seen[id] {
+			delete(scope.everHealthy, id)
+		}
+	}
+
+	sort.Sort(failedByName(failed))
+	return failed
+}
+
+// failureOf tells how a service failed, if it did. Functions scaled to zero are idle, not failed.
+func (scope *janitorScope) failureOf(service *client.Service) string {
+	switch {
+	case service.State == "error":
+		return "error"
+	case service.State == "inactive":
+		return "inactive"
+	case service.State == "active" && service.Scale > 0 && service.HealthState != "healthy" && !scope.everHealthy[service.Id]:
+		return "never-healthy"
+	}
+	return ""
+}
+
+func janitorExcluded(service *client.Service) bool {
+	value, ok := serviceLabel(service, JanitorExcludeLabel)
+	if !ok {
+		return false
+	}
+	excluded, err := strconv.ParseBool(value)
+	return err == nil && excluded
+}
+
+type failedByName []failedService
+
+func (f failedByName) Len() int           { return len(f) }
+func (f failedByName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f failedByName) Less(i, j int) bool { return f[i].Name < f[j].Name }
+
+// MakeJanitorHandler creates a handler which lists the failed function services on GET and removes
+// the expired ones on POST, unless ?dryRun=true is passed
+func MakeJanitorHandler(janitor *Janitor) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		dryRun := true
+		if r.Method == "POST" {
+			parsed, err := parseBoolQuery(r, "dryRun")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			dryRun = parsed
+		}
+
+		report, err := janitor.Collect(r.Context(), dryRun)
+		if err != nil {
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		reportBytes, _ := json.Marshal(report)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(reportBytes)
+	}
+}
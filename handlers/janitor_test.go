@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func makeJanitorService(id string, state string, healthState string, scale int64, labels map[string]interface{}) client.Service {
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	labels[FaasFunctionLabel] = id
+	service := client.Service{
+		Name:         id,
+		State:        state,
+		HealthState:  healthState,
+		Scale:        scale,
+		LaunchConfig: &client.LaunchConfig{Labels: labels},
+	}
+	service.Id = id
+	return service
+}
+
+func makeJanitorServices() []client.Service {
+	return []client.Service{
+		makeJanitorService("healthy", "active", "healthy", 1, nil),
+		makeJanitorService("idle", "active", "unhealthy", 0, nil),
+		makeJanitorService("errored", "error", "unhealthy", 1, nil),
+		makeJanitorService("stopped", "inactive", "", 1, nil),
+		makeJanitorService("starting", "active", "initializing", 1, nil),
+		makeJanitorService("kept", "error", "unhealthy", 1, map[string]interface{}{JanitorExcludeLabel: "true"}),
+		{Name: "not-a-function", State: "error", LaunchConfig: &client.LaunchConfig{}},
+	}
+}
+
+func Test_Janitor_Removes_Services_Failed_Beyond_TTL(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	janitor := NewJanitor(mockClient, time.Hour, false, nil, metrics.NewRegistry())
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	janitor.clock = clock
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeJanitorServices(), nil)
+	removed := []string{}
+	mockClient.On("DeleteServiceWithContext", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		removed = append(removed, args.Get(1).(*client.Service).Name)
+	})
+
+	// Act
+	first, _ := janitor.Collect(context.Background(), false)
+	clock.now = clock.now.Add(time.Hour)
+	second, _ := janitor.Collect(context.Background(), false)
+
+	// Assert
+	assert.Len(first.Services, 3)
+	for _, service := range first.Services {
+		assert.False(service.Expired)
+	}
+	assert.Equal([]string{"errored", "starting", "stopped"}, removed)
+	assert.Equal(types.FailedService{
+		Name:        "starting",
+		State:       "active",
+		HealthState: "initializing",
+		Reason:      "never-healthy",
+		Since:       time.Unix(0, 0),
+		Expired:     true,
+		Removed:     true,
+	}, second.Services[1])
+}
+
+func Test_Janitor_Forgets_Services_Which_Recover(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	janitor := NewJanitor(mockClient, time.Hour, false, nil, metrics.NewRegistry())
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	janitor.clock = clock
+	starting := makeJanitorService("starting", "active", "initializing", 1, nil)
+	healthy := makeJanitorService("starting", "active", "healthy", 1, nil)
+	degraded := makeJanitorService("starting", "active", "degraded", 1, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{starting}, nil).Once()
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{healthy}, nil).Once()
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{degraded}, nil)
+
+	// Act
+	janitor.Collect(context.Background(), false)
+	clock.now = clock.now.Add(time.Minute)
+	janitor.Collect(context.Background(), false)
+	clock.now = clock.now.Add(time.Hour * 2)
+	report, _ := janitor.Collect(context.Background(), false)
+
+	// Assert
+	assert.Empty(report.Services)
+	mockClient.AssertNotCalled(t, "DeleteServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_Janitor_Run_Records_Removals(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	janitor := NewJanitor(mockClient, 0, false, auditLog, metrics.NewRegistry())
+	errored := makeJanitorService("errored", "error", "unhealthy", 1, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{errored}, nil)
+	removed := make(chan bool, 10)
+	mockClient.On("DeleteServiceWithContext", mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		removed <- true
+	})
+	stop := make(chan struct{})
+	stopped := make(chan bool)
+
+	// Act
+	go func() {
+		janitor.Run(time.Millisecond, stop)
+		stopped <- true
+	}()
+	<-removed
+	close(stop)
+	<-stopped
+
+	// Assert
+	entries, _ := auditLog.Since(time.Time{})
+	assert.NotEmpty(entries)
+	assert.Equal("system:janitor", entries[0].Principal)
+	assert.Equal("delete", entries[0].Operation)
+	assert.Equal("errored", entries[0].Function)
+}
+
+func Test_Janitor_Keeps_Failures_Of_Namespaces_Apart(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	janitor := NewJanitor(mockClient, time.Hour, true, nil, metrics.NewRegistry())
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	janitor.clock = clock
+	teamA := rancher.WithNamespace(context.Background(), "team-a")
+	errored := makeJanitorService("errored", "error", "unhealthy", 1, nil)
+	mockClient.On("ListServicesWithContext", teamA).Return([]client.Service{}, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{errored}, nil)
+
+	// Act
+	janitor.Collect(context.Background(), true)
+	clock.now = clock.now.Add(time.Hour)
+	janitor.Collect(teamA, true)
+	report, _ := janitor.Collect(context.Background(), true)
+
+	// Assert
+	assert.Equal(time.Unix(0, 0), report.Services[0].Since)
+	assert.True(report.Services[0].Expired)
+}
+
+func Test_MakeJanitorHandler_Dry_Run(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	janitor := NewJanitor(mockClient, 0, false, nil, metrics.NewRegistry())
+	handler := MakeJanitorHandler(janitor)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(makeJanitorServices(), nil)
+
+	getReq, _ := http.NewRequest("GET", "/system/janitor", nil)
+	postReq, _ := http.NewRequest("POST", "/system/janitor?dryRun=true", nil)
+
+	for _, req := range []*http.Request{getReq, postReq} {
+		rr := httptest.NewRecorder()
+
+		// Act
+		handler(rr, req, nil)
+
+		// Assert
+		assert.Equal(http.StatusOK, rr.Code)
+		report := types.JanitorReport{}
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		assert.True(report.DryRun)
+		assert.Len(report.Services, 3)
+		assert.True(report.Services[0].Expired)
+	}
+	mockClient.AssertNotCalled(t, "DeleteServiceWithContext", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+// serviceLabel returns the label of the service's launch config as a string
+func serviceLabel(service *client.Service, key string) (string, bool) {
+	if service.LaunchConfig == nil || service.LaunchConfig.Labels == nil {
+		return "", false
+	}
+	value, ok := service.LaunchConfig.Labels[key]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
+
+// MinReplicas returns the minimum scale of a function, read from ScaleMinLabel
+func MinReplicas(service *client.Service) int64 {
+	value, ok := serviceLabel(service, ScaleMinLabel)
+	if !ok {
+		return defaultMinReplicas
+	}
+	min, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || min < 1 {
+		return defaultMinReplicas
+	}
+	return min
+}
+
+// ReplicaBounds returns the range a function may be scaled within. Without ScaleMaxLabel the
+// maximum is only bounded by globalMax, which is ignored when it isn't positive.
+func ReplicaBounds(service *client.Service, globalMax int64) (int64, int64) {
+	min := MinReplicas(service)
+
+	max, ok := maxReplicasLabel(service)
+	if !ok {
+		max = math.MaxInt64
+	}
+	if globalMax > 0 && max > globalMax {
+		max = globalMax
+	}
+	if max < min {
+		min = max
+	}
+	return min, max
+}
+
+func maxReplicasLabel(service *client.Service) (int64, bool) {
+	value, ok := serviceLabel(service, ScaleMaxLabel)
+	if !ok {
+		return 0, false
+	}
+	max, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || max < 1 {
+		return 0, false
+	}
+	return max, true
+}
+
+// TargetConcurrency returns the number of concurrent invocations per replica the autoscaler
+// aims for, read from ScaleTargetLabel
+func TargetConcurrency(service *client.Service) (float64, bool) {
+	value, ok := serviceLabel(service, ScaleTargetLabel)
+	if !ok {
+		return 0, false
+	}
+	target, err := strconv.ParseFloat(value, 64)
+	if err != nil || target <= 0 {
+		return 0, false
+	}
+	return target, true
+}
+
+// ScaleToZeroAllowed tells whether an idle function may be scaled to zero
+func ScaleToZeroAllowed(service *client.Service) bool {
+	value, ok := serviceLabel(service, ScaleZeroLabel)
+	if !ok {
+		return true
+	}
+	allowed, err := strconv.ParseBool(value)
+	return err != nil || allowed
+}
+
+// IsFunction tells whether the service was deployed as a faas function
+func IsFunction(service *client.Service) bool {
+	_, ok := serviceLabel(service, FaasFunctionLabel)
+	return ok
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// MakeLogsHandler streams the logs of every instance of a function as newline delimited JSON.
+// Query parameters: name (required), since (RFC3339 time or duration), tail and follow. Followed
+// logs end when streams are closed.
+func MakeLogsHandler(client rancher.BridgeClient, streams *Streams) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		query := r.URL.Query()
+
+		functionName := query.Get("name")
+		if len(functionName) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("name is required"))
+			return
+		}
+
+		since, sinceErr := parseSince(query.Get("since"), time.Now())
+		if sinceErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(sinceErr.Error()))
+			return
+		}
+
+		logsRequest, requestErr := parseLogsRequest(query)
+		if requestErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(requestErr.Error()))
+			return
+		}
+
+		service, findErr := client.FindServiceByNameWithContext(r.Context(), functionName)
+		if findErr != nil {
+			writeBridgeError(w, r, findErr, http.StatusInternalServerError, "")
+			return
+		}
+		if service == nil || !IsFunction(service) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		containers, listErr := client.ListInstancesWithContext(r.Context(), service)
+		if listErr != nil {
+			writeBridgeError(w, r, listErr, http.StatusInternalServerError, "")
+			return
+		}
+
+		conns := openLogs(r.Context(), client, containers, logsRequest)
+		if len(containers) > 0 && len(conns) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to open the logs of " + functionName))
+			return
+		}
+
+		out, streamErr := openStream(w, r, "application/x-ndjson", streams)
+		if streamErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to stream logs", "function", functionName, "error", streamErr)
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return
+		}
+		defer out.Close()
+
+		done := out.done
+		messages := make(chan types.LogMessage)
+
+		wg := sync.WaitGroup{}
+		for instance, conn := range conns {
+			wg.Add(1)
+			go func(instance string, conn *websocket.Conn) {
+				defer wg.Done()
+				readLogs(conn, functionName, instance, since, messages, done)
+			}(instance, conn)
+		}
+		go func() {
+			wg.Wait()
+			close(messages)
+		}()
+
+		encoder := json.NewEncoder(out)
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				if err := encoder.Encode(message); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// parseLogsRequest reads the tail and follow query parameters
+func parseLogsRequest(query url.Values) (*client.ContainerLogs, error) {
+	logsRequest := &client.ContainerLogs{}
+	if tail := query.Get("tail"); len(tail) > 0 {
+		lines, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil || lines < 0 {
+			return nil, fmt.Errorf("tail must be a positive number")
+		}
+		logsRequest.Lines = lines
+	}
+	if follow := query.Get("follow"); len(follow) > 0 {
+		followValue, err := strconv.ParseBool(follow)
+		if err != nil {
+			return nil, fmt.Errorf("follow must be true or false")
+		}
+		logsRequest.Follow = followValue
+	}
+	return logsRequest, nil
+}
+
+// openLogs opens the logs websocket of each container, keyed by the container's name
+func openLogs(ctx context.Context, bridge rancher.BridgeClient, containers []client.Container, logsRequest *client.ContainerLogs) map[string]*websocket.Conn {
+	conns := make(map[string]*websocket.Conn)
+	for i := range containers {
+		container := &containers[i]
+
+		access, err := bridge.ContainerLogsWithContext(ctx, container, logsRequest)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Unable to request logs", "instance", container.Name, "error", err)
+			continue
+		}
+
+		conn, err := rancher.DialHostAccess(access)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Unable to open logs", "instance", container.Name, "error", err)
+			continue
+		}
+		conns[container.Name] = conn
+	}
+	return conns
+}
+
+// readLogs forwards the lines read from the websocket until it is closed or done is
+func readLogs(conn *websocket.Conn, name string, instance string, since time.Time, messages chan<- types.LogMessage, done <-chan struct{}) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			message := parseLogLine(line)
+			if message.Timestamp.Before(since) {
+				continue
+			}
+			message.Name = name
+			message.Instance = instance
+
+			select {
+			case messages <- message:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// parseLogLine splits a line of Rancher's logs websocket, formatted as
+// "01 2017-10-01T10:00:00.000000000Z text" where 01 is stdout and 02 is stderr
+func parseLogLine(line string) types.LogMessage {
+	message := types.LogMessage{
+		Timestamp: time.Now().UTC(),
+		Text:      line,
+	}
+
+	if len(line) >= 3 && line[2] == ' ' {
+		switch line[:2] {
+		case "01":
+			message.Stream = "stdout"
+			line = line[3:]
+		case "02":
+			message.Stream = "stderr"
+			line = line[3:]
+		}
+		message.Text = line
+	}
+
+	if space := strings.Index(line, " "); space > 0 {
+		if timestamp, err := time.Parse(time.RFC3339Nano, line[:space]); err == nil {
+			message.Timestamp = timestamp
+			message.Text = line[space+1:]
+		}
+	}
+
+	return message
+}
+
+// parseSince accepts an RFC3339 time or a duration counted back from now, empty means no limit
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("(%s) is neither a RFC3339 time nor a duration", value)
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// makeLogsServer serves a websocket which sends the given messages for the token and closes
+func makeLogsServer(t *testing.T, messages map[string][]string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for _, message := range messages[r.URL.Query().Get("token")] {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+}
+
+// makeSlowServer serves a websocket which sends a message every interval and closes
+func makeSlowServer(t *testing.T, messages []string, interval time.Duration) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for _, message := range messages {
+			time.Sleep(interval)
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+}
+
+// serveWithWriteTimeout serves the handler from a server whose WriteTimeout is timeout
+func serveWithWriteTimeout(handler http.Handler, timeout time.Duration) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.WriteTimeout = timeout
+	server.Start()
+	return server
+}
+
+func readLogMessages(body string) []types.LogMessage {
+	messages := []types.LogMessage{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		message := types.LogMessage{}
+		json.Unmarshal(scanner.Bytes(), &message)
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func Test_MakeLogsHandler_Merges_Instances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeLogsServer(t, map[string][]string{
+		"token-1": {"01 2017-10-01T10:00:00.000000000Z first\n", "02 2017-10-01T10:00:01.000000000Z oops\n"},
+		"token-2": {"01 2017-10-01T10:00:02.000000000Z second\n"},
+	})
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/logs/"
+
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeLogsHandler(mockClient, NewStreams())
+
+	service := makeFunctionService("some-service", "active", 2, 2, nil)
+	containers := []client.Container{{Name: "some-service-1"}, {Name: "some-service-2"}}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return(containers, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, &containers[0], &client.ContainerLogs{Lines: 10}).Return(&client.HostAccess{Url: wsURL, Token: "token-1"}, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, &containers[1], &client.ContainerLogs{Lines: 10}).Return(&client.HostAccess{Url: wsURL, Token: "token-2"}, nil)
+
+	req, _ := http.NewRequest("GET", "/system/logs?name=some-service&tail=10", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("application/x-ndjson", rr.Header().Get("Content-Type"))
+	messages := readLogMessages(rr.Body.String())
+	assert.Equal(3, len(messages))
+
+	byText := map[string]types.LogMessage{}
+	for _, message := range messages {
+		byText[message.Text] = message
+	}
+	assert.Equal("some-service-1", byText["oops"].Instance)
+	assert.Equal("stderr", byText["oops"].Stream)
+	assert.Equal("some-service", byText["second"].Name)
+	assert.Equal("some-service-2", byText["second"].Instance)
+	assert.Equal(time.Date(2017, 10, 1, 10, 0, 2, 0, time.UTC), byText["second"].Timestamp)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeLogsHandler_Since(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeLogsServer(t, map[string][]string{
+		"token-1": {"01 2017-10-01T10:00:00.000000000Z old\n01 2017-10-01T11:00:00.000000000Z new\n"},
+	})
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeLogsHandler(mockClient, NewStreams())
+
+	service := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return([]client.Container{{Name: "some-service-1"}}, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, mock.Anything, mock.Anything).Return(&client.HostAccess{Url: wsURL, Token: "token-1"}, nil)
+
+	req, _ := http.NewRequest("GET", "/system/logs?name=some-service&since=2017-10-01T10:30:00Z", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	messages := readLogMessages(rr.Body.String())
+	assert.Equal(1, len(messages))
+	assert.Equal("new", messages[0].Text)
+}
+
+func Test_MakeLogsHandler_Follow_Outlasts_WriteTimeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	lines := []string{}
+	for i := 0; i < 5; i++ {
+		lines = append(lines, fmt.Sprintf("01 2017-10-01T10:00:0%d.000000000Z line %d\n", i, i))
+	}
+	logsServer := makeSlowServer(t, lines, 100*time.Millisecond)
+	defer logsServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(logsServer.URL, "http")
+
+	mockClient := new(mocks.BridgeClient)
+	service := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return([]client.Container{{Name: "some-service-1"}}, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, mock.Anything, mock.Anything).Return(&client.HostAccess{Url: wsURL}, nil)
+	server := serveWithWriteTimeout(MakeLogsHandler(mockClient, NewStreams()), 200*time.Millisecond)
+	defer server.Close()
+
+	// Act
+	res, err := http.Get(server.URL + "/system/logs?name=some-service&follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, readErr := ioutil.ReadAll(res.Body)
+
+	// Assert
+	assert.Nil(readErr)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal("application/x-ndjson", res.Header.Get("Content-Type"))
+	messages := readLogMessages(string(body))
+	if assert.Equal(5, len(messages)) {
+		assert.Equal("line 4", messages[4].Text)
+	}
+}
+
+func Test_MakeLogsHandler_Follow_Ends_When_Streams_Close(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	lines := []string{}
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf("01 2017-10-01T10:00:00.000000000Z line %d\n", i))
+	}
+	logsServer := makeSlowServer(t, lines, 100*time.Millisecond)
+	defer logsServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(logsServer.URL, "http")
+
+	mockClient := new(mocks.BridgeClient)
+	service := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return([]client.Container{{Name: "some-service-1"}}, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, mock.Anything, mock.Anything).Return(&client.HostAccess{Url: wsURL}, nil)
+	streams := NewStreams()
+	server := httptest.NewServer(MakeLogsHandler(mockClient, streams))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/system/logs?name=some-service&follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	reader := bufio.NewReader(res.Body)
+	first, _ := reader.ReadString('\n')
+
+	// Act
+	started := time.Now()
+	streams.Close()
+	rest, readErr := ioutil.ReadAll(reader)
+
+	// Assert
+	assert.Nil(readErr, "the stream wasn't ended cleanly")
+	assert.True(time.Since(started) < 2*time.Second, "the stream didn't end on close")
+	messages := readLogMessages(first + string(rest))
+	if assert.NotEmpty(messages) {
+		assert.Equal("line 0", messages[0].Text)
+	}
+}
+
+// unhijackableWriter is a response which can't be hijacked, as those of HTTP/2
+type unhijackableWriter struct {
+	http.ResponseWriter
+}
+
+func (w unhijackableWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w unhijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func Test_MakeLogsHandler_Follow_Without_Hijack_Ends_Before_WriteTimeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	lines := []string{}
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf("01 2017-10-01T10:00:00.000000000Z line %d\n", i))
+	}
+	logsServer := makeSlowServer(t, lines, 50*time.Millisecond)
+	defer logsServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(logsServer.URL, "http")
+
+	mockClient := new(mocks.BridgeClient)
+	service := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return([]client.Container{{Name: "some-service-1"}}, nil)
+	mockClient.On("ContainerLogsWithContext", mock.Anything, mock.Anything, mock.Anything).Return(&client.HostAccess{Url: wsURL}, nil)
+	handler := MakeLogsHandler(mockClient, NewStreams())
+	writeTimeout := 600 * time.Millisecond
+	LimitWait(writeTimeout)
+	defer LimitWait(0)
+	server := serveWithWriteTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(unhijackableWriter{w}, r)
+	}), writeTimeout)
+	defer server.Close()
+
+	// Act
+	res, err := http.Get(server.URL + "/system/logs?name=some-service&follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, readErr := ioutil.ReadAll(res.Body)
+
+	// Assert
+	assert.Nil(readErr, "the stream was cut by the WriteTimeout")
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.NotEmpty(readLogMessages(string(body)))
+}
+
+func Test_MakeLogsHandler_Invalid_Query(t *testing.T) {
+	for _, query := range []string{"", "?name=fn&tail=-1", "?name=fn&follow=maybe", "?name=fn&since=yesterday"} {
+		t.Run(query, func(t *testing.T) {
+			assert := assert.New(t)
+			// Arrange
+			mockClient := new(mocks.BridgeClient)
+			handler := MakeLogsHandler(mockClient, NewStreams())
+			req, _ := http.NewRequest("GET", "/system/logs"+query, nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler(rr, req, nil)
+
+			// Assert
+			assert.Equal(http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func Test_MakeLogsHandler_Unknown_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeLogsHandler(mockClient, NewStreams())
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	req, _ := http.NewRequest("GET", "/system/logs?name=some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
+
+func Test_ParseLogLine_Without_Prefix(t *testing.T) {
+	assert := assert.New(t)
+
+	message := parseLogLine("plain text")
+
+	assert.Equal("plain text", message.Text)
+	assert.Equal("", message.Stream)
+}
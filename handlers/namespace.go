@@ -0,0 +1,67 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// MakeNamespaceMiddleware reads the optional ?namespace= of a request into its context, so that
+// the bridge acts on the stack of that namespace. Requests without one use the default namespace.
+func MakeNamespaceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.URL.Query().Get("namespace")
+		if len(namespace) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := rancher.ValidateNamespace(namespace); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(rancher.WithNamespace(r.Context(), namespace)))
+	})
+}
+
+// MakeNamespacesHandler creates a handler which lists the namespaces
+func MakeNamespacesHandler(client rancher.BridgeClient) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		namespaces, err := client.ListNamespacesWithContext(r.Context())
+		if err != nil {
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		namespacesBytes, _ := json.Marshal(namespaces)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(namespacesBytes)
+	}
+}
+
+// qualifiedName tells functions of the same name apart across namespaces and environments, functions
+// of the default namespace of the default environment keep their name so that the background loops
+// find them
+func qualifiedName(r *http.Request, name string) string {
+	if namespace := rancher.NamespaceFromContext(r.Context()); namespace != rancher.DefaultNamespace {
+		name += "." + namespace
+	}
+	if environment, ok := rancher.EnvironmentFromContext(r.Context()); ok {
+		name += "@" + environment.Name
+	}
+	return name
+}
+
+// scopeOf names the namespace of the environment of ctx, state which is kept per namespace, such as
+// what the janitor observed, is keyed by it
+func scopeOf(ctx context.Context) string {
+	environment, _ := rancher.EnvironmentFromContext(ctx)
+	return environment.Name + "/" + rancher.NamespaceFromContext(ctx)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_MakeNamespaceMiddleware_Sets_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	namespaces := []string{}
+	handler := MakeNamespaceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespaces = append(namespaces, rancher.NamespaceFromContext(r.Context()))
+	}))
+
+	// Act
+	for _, url := range []string{"/system/functions", "/system/functions?namespace=team-a"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert
+	assert.Equal([]string{"default", "team-a"}, namespaces)
+}
+
+func Test_MakeNamespaceMiddleware_Rejects_Invalid_Namespace(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	called := false
+	handler := MakeNamespaceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	req, _ := http.NewRequest("GET", "/system/functions?namespace=Team_A", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.False(called)
+}
+
+func Test_MakeNamespacesHandler_Lists_Namespaces(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("ListNamespacesWithContext", mock.Anything).Return([]string{"default", "team-a"}, nil)
+	handler := MakeNamespacesHandler(mockClient)
+	req, _ := http.NewRequest("GET", "/system/namespaces", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	namespaces := []string{}
+	json.Unmarshal(rr.Body.Bytes(), &namespaces)
+	assert.Equal([]string{"default", "team-a"}, namespaces)
+}
+
+func Test_MakeProxy_Routes_To_Namespace_Stack(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.HttpDoer)
+	mockClient.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+		return r.URL.String() == "http://some-function.faas-functions-team-a:8080/"
+	})).Return(&http.Response{
+		Header: make(http.Header),
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte("ok"))),
+	}, nil)
+	handler := MakeProxy(mockClient, "faas-functions")
+	req, _ := http.NewRequest("POST", "/function/some-function", bytes.NewReader([]byte("data")))
+	req = req.WithContext(rancher.WithNamespace(req.Context(), "team-a"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-function"})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	mockClient.AssertExpectations(t)
+}
@@ -6,15 +6,58 @@ package handlers
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"net/http"
-	"strconv"
 	"time"
 
 	"io/ioutil"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/tracing"
 )
 
+// MakeTracingProxy wraps the function proxy so that each invocation, including the wake-up of the
+// function, is traced as a span continuing the trace of the caller's traceparent or B3 headers.
+// The span is carried by the context of the request, the calls to Rancher are its children and
+// the proxy propagates it to the function.
+func MakeTracingProxy(next VarsHandler) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		service := vars["name"]
+
+		ctx := r.Context()
+		if parent, ok := tracing.Extract(r.Header); ok {
+			ctx = tracing.WithRemoteParent(ctx, parent)
+		}
+		ctx, span := tracing.Start(ctx, "invoke "+service, tracing.KindServer)
+		span.SetAttribute("faas.function", service)
+		span.SetAttribute("faas.namespace", rancher.NamespaceFromContext(ctx))
+		defer span.End()
+
+		traced := &tracedResponse{ResponseWriter: w, status: http.StatusOK}
+		next(traced, r.WithContext(ctx), vars)
+
+		span.SetAttribute("http.status_code", traced.status)
+		if traced.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%s", http.StatusText(traced.status)))
+		}
+	}
+}
+
+// tracedResponse remembers the status of the response of a traced invocation
+type tracedResponse struct {
+	http.ResponseWriter
+	status int
+}
+
+func (t *tracedResponse) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
 // MakeProxy creates a proxy for HTTP web requests which can be routed to a function.
+// Functions of other namespaces are reached through the stack of their namespace, and those of
+// other environments through the functions stack of their environment. The span of the
+// invocation, see MakeTracingProxy, is propagated to the function.
 func MakeProxy(httpDoer HttpDoer, stackName string) VarsHandler {
 
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
@@ -27,12 +70,10 @@ func MakeProxy(httpDoer HttpDoer, stackName string) VarsHandler {
 		}
 
 		service := vars["name"]
-
-		stamp := strconv.FormatInt(time.Now().Unix(), 10)
+		logger := logging.FromContext(r.Context()).With("function", service)
 
 		defer func(when time.Time) {
-			seconds := time.Since(when).Seconds()
-			log.Printf("[%s] took %f seconds\n", stamp, seconds)
+			logger.Info("Invoked function", "duration", time.Since(when))
 		}(time.Now())
 
 		watchdogPort := 8080
@@ -40,17 +81,28 @@ func MakeProxy(httpDoer HttpDoer, stackName string) VarsHandler {
 		requestBody, _ := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 
-		url := fmt.Sprintf("http://%s.%s:%d/", service, stackName, watchdogPort)
+		functionsStack := stackName
+		if environment, ok := rancher.EnvironmentFromContext(r.Context()); ok {
+			functionsStack = environment.FunctionsStackName
+		}
+		namespaceStack := rancher.NamespaceStackName(functionsStack, rancher.NamespaceFromContext(r.Context()))
+		url := fmt.Sprintf("http://%s.%s:%d/", service, namespaceStack, watchdogPort)
 
 		request, _ := http.NewRequest("POST", url, bytes.NewReader(requestBody))
 
 		copyHeaders(&request.Header, &r.Header)
+		if requestID := logging.RequestIDFromContext(r.Context()); len(requestID) > 0 {
+			request.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		if sc, ok := tracing.SpanContextFromContext(r.Context()); ok {
+			tracing.Inject(request.Header, sc)
+		}
 
 		defer request.Body.Close()
 
 		response, err := httpDoer.Do(request)
 		if err != nil {
-			log.Println(err.Error())
+			logger.Error("Unable to reach function", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			buf := bytes.NewBufferString("Can't reach service: " + service)
 			w.Write(buf.Bytes())
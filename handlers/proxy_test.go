@@ -2,15 +2,21 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
 	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/tracing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,3 +64,104 @@ func Test_MakeProxyHandler_Create_Service_Success(t *testing.T) {
 	assert.True(bytes.Equal(responseBody, proxiedBody))
 	assert.Equal("Some-Content-Type", rr.Header().Get("Content-Type"), "Headers weren't copied")
 }
+
+func Test_MakeProxyHandler_Propagates_Request_ID(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.HttpDoer)
+	handler := logging.MakeMiddleware(logging.Default(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		MakeProxy(mockClient, "some_stackname")(w, r, map[string]string{"name": "some-service"})
+	}))
+	req, _ := http.NewRequest("POST", "/function/some-service", bytes.NewReader([]byte("{}")))
+	req.Header.Set(logging.RequestIDHeader, "gateway-1234")
+
+	response := &http.Response{Header: make(http.Header), Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	mockClient.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+		return r.Header.Get(logging.RequestIDHeader) == "gateway-1234"
+	})).Return(response, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("gateway-1234", rr.Header().Get(logging.RequestIDHeader))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeProxyHandler_Traces_Invocation(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	exported := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&request)
+		exported <- request
+	}))
+	defer collector.Close()
+	exporter := tracing.NewOTLPExporter(collector.URL, "faas-rancher", metrics.NewRegistry())
+	tracing.SetDefault(tracing.NewTracer(exporter))
+	defer tracing.SetDefault(tracing.NewTracer(nil))
+
+	mockClient := new(mocks.HttpDoer)
+	handler := MakeTracingProxy(MakeProxy(mockClient, "some_stackname"))
+	req, _ := http.NewRequest("POST", "/function/some-service", bytes.NewReader([]byte("{}")))
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	req.Header.Set("X-B3-Sampled", "1")
+
+	var propagated http.Header
+	response := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	mockClient.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+		propagated = r.Header
+		return true
+	})).Return(response, nil)
+
+	// Act
+	handler(httptest.NewRecorder(), req, map[string]string{"name": "some-service"})
+	exporter.Flush()
+
+	// Assert
+	request := <-exported
+	span := request["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal("invoke some-service", span["name"])
+	assert.Equal("80f198ee56343ba864fe8b2a57d3eff7", span["traceId"])
+	assert.Equal("e457b5a2e4d86bd1", span["parentSpanId"])
+	assert.Equal("00-80f198ee56343ba864fe8b2a57d3eff7-"+span["spanId"].(string)+"-01", propagated.Get("traceparent"))
+	assert.Equal(span["spanId"], propagated.Get("X-B3-SpanId"))
+}
+
+// discardingExporter drops the spans of the tests which only look at the contexts they are started in
+type discardingExporter struct{}
+
+func (discardingExporter) Export(tracing.SpanData) {}
+
+func Test_MakeTracingProxy_Traces_Wake_Up_In_Invocation(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracing.SetDefault(tracing.NewTracer(discardingExporter{}))
+	defer tracing.SetDefault(tracing.NewTracer(nil))
+
+	mockClient := new(mocks.BridgeClient)
+	tracker := NewInvocationTracker()
+	var wakeUpTrace, proxiedTrace tracing.SpanContext
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil).Run(func(args mock.Arguments) {
+		wakeUpTrace, _ = tracing.SpanContextFromContext(args.Get(0).(context.Context))
+	})
+	proxy := func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		proxiedTrace, _ = tracing.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+	handler := MakeTracingProxy(MakeWakeUpProxy(mockClient, tracker, time.Second, proxy))
+	req, _ := http.NewRequest("POST", "/function/some-service", nil)
+	req.Header.Set("traceparent", "00-80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-01")
+
+	// Act
+	handler(httptest.NewRecorder(), req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal("80f198ee56343ba864fe8b2a57d3eff7", wakeUpTrace.TraceID.String())
+	assert.NotEqual("e457b5a2e4d86bd1", wakeUpTrace.SpanID.String(), "the wake-up wasn't made in the span of the invocation")
+	assert.Equal(wakeUpTrace, proxiedTrace)
+}
@@ -4,20 +4,22 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/alexellis/faas/gateway/requests"
 	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/rancher/go-rancher/v2"
 )
 
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
 func MakeFunctionReader(client rancher.BridgeClient) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 
-		functions, err := getServiceList(client)
+		functions, err := getServiceList(r.Context(), client)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeBridgeError(w, r, err, http.StatusInternalServerError, err.Error())
 			return
 		}
 
@@ -32,10 +34,10 @@ func MakeFunctionReader(client rancher.BridgeClient) VarsHandler {
 	}
 }
 
-func getServiceList(client rancher.BridgeClient) ([]requests.Function, error) {
+func getServiceList(ctx context.Context, client rancher.BridgeClient) ([]requests.Function, error) {
 	functions := []requests.Function{}
 
-	services, err := client.ListServices()
+	services, err := client.ListServicesWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -48,17 +50,18 @@ func getServiceList(client rancher.BridgeClient) ([]requests.Function, error) {
 
 		if _, ok := service.LaunchConfig.Labels[FaasFunctionLabel]; ok {
 			// filter to faas function services
-			replicas := uint64(service.Scale)
-			function := requests.Function{
-				Name:            service.Name,
-				Replicas:        replicas,
-				Image:           service.LaunchConfig.ImageUuid,
-				InvocationCount: 0,
-			}
-			functions = append(functions, function)
-
+			functions = append(functions, makeFunction(&service))
 		}
 	}
 
 	return functions, nil
 }
+
+func makeFunction(service *client.Service) requests.Function {
+	return requests.Function{
+		Name:            service.Name,
+		Replicas:        uint64(service.Scale),
+		Image:           service.LaunchConfig.ImageUuid,
+		InvocationCount: 0,
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/kenfdev/faas/gateway/requests"
 	"github.com/rancher/go-rancher/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func Test_MakeFunctionReader_Get_Service_List_Error(t *testing.T) {
@@ -28,7 +29,7 @@ func Test_MakeFunctionReader_Get_Service_List_Error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	mockClient.On("ListServices").Return(nil, fmt.Errorf("Error"))
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(nil, fmt.Errorf("Error"))
 
 	// Act
 	handler(rr, req, nil)
@@ -58,7 +59,7 @@ func Test_MakeFunctionReader_Get_Service_List_No_Active_Services(t *testing.T) {
 	services := []client.Service{
 		nonActiveService,
 	}
-	mockClient.On("ListServices").Return(services, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -114,7 +115,7 @@ func Test_MakeFunctionReader_Get_Service_List_Has_Active_Services(t *testing.T)
 		nonActiveService,
 		activeService,
 	}
-	mockClient.On("ListServices").Return(services, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -163,7 +164,7 @@ func Test_MakeFunctionReader_Get_Service_List_Has_Active_Services_But_Not_Labele
 		nonActiveService,
 		activeButNotLabeledService,
 	}
-	mockClient.On("ListServices").Return(services, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
 
 	// Act
 	handler(rr, req, nil)
@@ -5,23 +5,31 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 
-	"github.com/alexellis/faas/gateway/requests"
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
 	"github.com/kenfdev/faas-rancher/rancher"
 	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
 )
 
-// MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater(client rancher.BridgeClient) VarsHandler {
+// MakeReplicaUpdater updates desired count of replicas, within the function's bounds and the
+// global maxReplicas cap (ignored when zero)
+func MakeReplicaUpdater(client rancher.BridgeClient, maxReplicas int64) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
-
-		log.Println("Update replicas")
-
 		functionName := vars["name"]
+		logging.FromContext(r.Context()).Debug("Update replicas", "function", functionName)
+
+		wait, waitErr := parseWait(r)
+		if waitErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(waitErr.Error()))
+			return
+		}
 
 		req := types.ScaleServiceRequest{}
 		if r.Body != nil {
@@ -32,60 +40,107 @@ func MakeReplicaUpdater(client rancher.BridgeClient) VarsHandler {
 				w.WriteHeader(http.StatusBadRequest)
 				msg := "Cannot parse request. Please pass valid JSON."
 				w.Write([]byte(msg))
-				log.Println(msg, marshalErr)
+				logging.FromContext(r.Context()).Warn(msg, "error", marshalErr)
 				return
 			}
 		}
 
-		service, findErr := client.FindServiceByName(functionName)
+		if len(req.ServiceName) > 0 && req.ServiceName != functionName {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("serviceName " + req.ServiceName + " does not match function " + functionName))
+			return
+		}
+
+		if req.Replicas < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("replicas must not be negative"))
+			return
+		}
+
+		service, findErr := client.FindServiceByNameWithContext(r.Context(), functionName)
 		if findErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Unable to lookup function deployment " + functionName))
-			log.Println(findErr)
+			writeBridgeError(w, r, findErr, http.StatusInternalServerError, "Unable to lookup function deployment "+functionName)
+			return
+		}
+
+		if service == nil || !IsFunction(service) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("No function named " + functionName + " found"))
+			return
+		}
+
+		if boundsErr := validateReplicas(service, req.Replicas, maxReplicas); boundsErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(boundsErr.Error()))
 			return
 		}
 
 		updates := make(map[string]string)
 		updates["scale"] = strconv.FormatInt(req.Replicas, 10)
-		_, upgradeErr := client.UpdateService(service, updates)
+		_, upgradeErr := client.UpdateServiceWithContext(r.Context(), service, updates)
+		recordAudit(r.Context(), audit.OperationScale, functionName, service, scaleAudit{From: service.Scale, To: req.Replicas}, upgradeErr)
 		if upgradeErr != nil {
-			w.WriteHeader(500)
-			w.Write([]byte("Unable to update function deployment " + functionName))
-			log.Println(upgradeErr)
+			writeBridgeError(w, r, upgradeErr, http.StatusInternalServerError, "Unable to update function deployment "+functionName)
 			return
 		}
 
+		res := types.ScaleServiceResponse{
+			ServiceName: functionName,
+			OldReplicas: service.Scale,
+			NewReplicas: req.Replicas,
+		}
+
+		statusCode := http.StatusOK
+		if wait > 0 {
+			ready, readyErr := waitForService(r.Context(), client, functionName, req.Replicas, wait)
+			if readyErr != nil {
+				statusCode = http.StatusGatewayTimeout
+			}
+			res.Status = makeServiceStatus(functionName, ready)
+		}
+
+		resBytes, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(resBytes)
 	}
 }
 
+// validateReplicas checks the requested scale against the function's bounds, zero is accepted
+// unless the function opted out of being scaled to zero
+func validateReplicas(service *client.Service, replicas int64, maxReplicas int64) error {
+	if replicas == 0 {
+		if !ScaleToZeroAllowed(service) {
+			return fmt.Errorf("%s can not be scaled to zero", service.Name)
+		}
+		return nil
+	}
+
+	min, max := ReplicaBounds(service, maxReplicas)
+	if replicas < min || replicas > max {
+		return fmt.Errorf("replicas of %s must be between %d and %d", service.Name, min, max)
+	}
+	return nil
+}
+
 // MakeReplicaReader reads the amount of replicas for a deployment
 func MakeReplicaReader(client rancher.BridgeClient) VarsHandler {
 	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
-
-		log.Println("Read replicas")
-
 		functionName := vars["name"]
+		logging.FromContext(r.Context()).Debug("Read replicas", "function", functionName)
 
-		functions, err := getServiceList(client)
+		service, err := client.FindServiceByNameWithContext(r.Context(), functionName)
 		if err != nil {
-			w.WriteHeader(500)
+			writeBridgeError(w, r, err, http.StatusInternalServerError, "")
 			return
 		}
 
-		var found *requests.Function
-		for _, function := range functions {
-			if function.Name == functionName {
-				found = &function
-				break
-			}
-		}
-
-		if found == nil {
+		if service == nil || service.State != "active" || !IsFunction(service) {
 			w.WriteHeader(404)
 			return
 		}
 
-		functionBytes, _ := json.Marshal(found)
+		functionBytes, _ := json.Marshal(makeFunction(service))
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(200)
 		w.Write(functionBytes)
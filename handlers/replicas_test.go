@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeScaleRequest(url string, body string) *http.Request {
+	req, reqErr := http.NewRequest("POST", url, bytes.NewReader([]byte(body)))
+	if reqErr != nil {
+		log.Fatal(reqErr)
+	}
+	return req
+}
+
+func makeFunctionService(name string, state string, scale int64, currentScale int64, labels map[string]interface{}) *client.Service {
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	labels[FaasFunctionLabel] = name
+	return &client.Service{
+		Name:         name,
+		State:        state,
+		Scale:        scale,
+		CurrentScale: currentScale,
+		HealthState:  "healthy",
+		LaunchConfig: &client.LaunchConfig{Labels: labels},
+	}
+}
+
+func Test_MakeReplicaUpdater_Success(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaUpdater(mockClient, 100)
+	vars := map[string]string{"name": "some-service"}
+	req := makeScaleRequest("/system/scale-function/some-service", `{"serviceName":"some-service","replicas":3}`)
+
+	existing := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, existing, map[string]string{"scale": "3"}).Return(existing, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, vars)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	res := types.ScaleServiceResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &res)
+	assert.Equal(types.ScaleServiceResponse{ServiceName: "some-service", OldReplicas: 1, NewReplicas: 3}, res)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeReplicaUpdater_Wait_Until_Scaled(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaUpdater(mockClient, 100)
+	vars := map[string]string{"name": "some-service"}
+	req := makeScaleRequest("/system/scale-function/some-service?wait=1s", `{"serviceName":"some-service","replicas":3}`)
+
+	existing := makeFunctionService("some-service", "active", 1, 1, nil)
+	scaling := makeFunctionService("some-service", "active", 3, 2, nil)
+	scaled := makeFunctionService("some-service", "active", 3, 3, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil).Once()
+	mockClient.On("UpdateServiceWithContext", mock.Anything, existing, map[string]string{"scale": "3"}).Return(scaling, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(scaling, nil).Once()
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(scaled, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, vars)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	res := types.ScaleServiceResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &res)
+	assert.Equal(int64(1), res.OldReplicas)
+	assert.Equal(int64(3), res.Status.CurrentScale)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeReplicaUpdater_Invalid_Requests(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		labels map[string]interface{}
+	}{
+		{"negative", `{"replicas":-1}`, nil},
+		{"above global cap", `{"replicas":101}`, nil},
+		{"above max label", `{"replicas":6}`, map[string]interface{}{ScaleMaxLabel: "5"}},
+		{"below min label", `{"replicas":1}`, map[string]interface{}{ScaleMinLabel: "2"}},
+		{"zero when opted out", `{"replicas":0}`, map[string]interface{}{ScaleZeroLabel: "false"}},
+		{"name mismatch", `{"serviceName":"other-service","replicas":1}`, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert := assert.New(t)
+			// Arrange
+			mockClient := new(mocks.BridgeClient)
+			handler := MakeReplicaUpdater(mockClient, 100)
+			vars := map[string]string{"name": "some-service"}
+			req := makeScaleRequest("/system/scale-function/some-service", c.body)
+
+			existing := makeFunctionService("some-service", "active", 1, 1, c.labels)
+			mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler(rr, req, vars)
+
+			// Assert
+			assert.Equal(http.StatusBadRequest, rr.Code)
+			mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func Test_MakeReplicaUpdater_Unknown_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaUpdater(mockClient, 100)
+	vars := map[string]string{"name": "some-service"}
+	req := makeScaleRequest("/system/scale-function/some-service", `{"replicas":1}`)
+
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, vars)
+
+	// Assert
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
+
+func Test_MakeReplicaUpdater_Update_Error(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaUpdater(mockClient, 100)
+	vars := map[string]string{"name": "some-service"}
+	req := makeScaleRequest("/system/scale-function/some-service", `{"replicas":2}`)
+
+	existing := makeFunctionService("some-service", "active", 1, 1, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(existing, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, existing, mock.Anything).Return(nil, fmt.Errorf("Error"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, vars)
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func Test_MakeReplicaReader_Finds_Function_By_Name(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaReader(mockClient)
+	service := makeFunctionService("some-service", "active", 3, 3, nil)
+	service.LaunchConfig.ImageUuid = "docker:some/image"
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+
+	req, _ := http.NewRequest("GET", "/system/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.JSONEq(`{"name":"some-service","image":"docker:some/image","invocationCount":0,"replicas":3,"envProcess":""}`, rr.Body.String())
+	mockClient.AssertNotCalled(t, "ListServicesWithContext", mock.Anything)
+}
+
+func Test_MakeReplicaReader_Unknown_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaReader(mockClient)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil)
+
+	req, _ := http.NewRequest("GET", "/system/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
+
+func Test_MakeReplicaReader_Passes_Request_Context(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaReader(mockClient)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+		// a slow Cattle which only gives up along with the client
+		<-args.Get(0).(context.Context).Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "/system/function/some-service", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	time.AfterFunc(time.Millisecond*10, cancel)
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+}
+
+func Test_MakeReplicaReader_Circuit_Open(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeReplicaReader(mockClient)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, &rancher.CircuitOpenError{RetryAfter: time.Millisecond * 1500})
+
+	req, _ := http.NewRequest("GET", "/system/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.Equal("2", rr.Header().Get("Retry-After"))
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// HostAffinityLabel schedules the containers of a service on the hosts with all of the listed
+// labels, it carries the constraints of a function
+const HostAffinityLabel = "io.rancher.scheduler.affinity:host_label"
+
+// cpuPeriod is the CFS period the CPU limit of a function is a quota of
+const cpuPeriod = 100000
+
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+}
+
+// parseMemory reads a memory size in bytes with an optional k, m or g suffix
+func parseMemory(value string) (int64, error) {
+	number := strings.ToLower(value)
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("(%s) is not a valid memory limit", value)
+	}
+	return size * multiplier, nil
+}
+
+// formatMemory writes a memory size with the largest suffix it is a multiple of
+func formatMemory(bytes int64) string {
+	for _, unit := range memoryUnits {
+		if bytes%unit.bytes == 0 {
+			return strconv.FormatInt(bytes/unit.bytes, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// parseCPU reads a number of cores as a quota of cpuPeriod
+func parseCPU(value string) (int64, error) {
+	cores, err := strconv.ParseFloat(value, 64)
+	quota := int64(cores * cpuPeriod)
+	if err != nil || quota < 1000 {
+		return 0, fmt.Errorf("(%s) is not a valid CPU limit", value)
+	}
+	return quota, nil
+}
+
+// formatCPU writes a CPU quota as a number of cores
+func formatCPU(quota int64, period int64) string {
+	return strconv.FormatFloat(float64(quota)/float64(period), 'f', -1, 64)
+}
+
+// validateFunctionRequest checks a deploy request before a spec is made of it
+func validateFunctionRequest(request *types.CreateFunctionRequest) error {
+	if err := ValidateDeployRequest(&request.CreateFunctionRequest); err != nil {
+		return err
+	}
+	if request.Scale < 0 {
+		return fmt.Errorf("(%d) is not a valid scale", request.Scale)
+	}
+	if request.Limits != nil {
+		if len(request.Limits.Memory) > 0 {
+			if _, err := parseMemory(request.Limits.Memory); err != nil {
+				return err
+			}
+		}
+		if len(request.Limits.CPU) > 0 {
+			if _, err := parseCPU(request.Limits.CPU); err != nil {
+				return err
+			}
+		}
+	}
+	for _, constraint := range request.Constraints {
+		if !strings.Contains(constraint, "=") || strings.Contains(constraint, ",") {
+			return fmt.Errorf("(%s) is not a valid constraint, use label=value", constraint)
+		}
+	}
+	return nil
+}
+
+// applyResources sets the limits, constraints and secrets of a request on a launch config,
+// the request must have been validated
+func applyResources(request types.CreateFunctionRequest, launchConfig *client.LaunchConfig) {
+	if request.Limits != nil {
+		if len(request.Limits.Memory) > 0 {
+			launchConfig.Memory, _ = parseMemory(request.Limits.Memory)
+		}
+		if len(request.Limits.CPU) > 0 {
+			launchConfig.CpuQuota, _ = parseCPU(request.Limits.CPU)
+			launchConfig.CpuPeriod = cpuPeriod
+		}
+	}
+
+	if len(request.Constraints) > 0 {
+		launchConfig.Labels[HostAffinityLabel] = strings.Join(request.Constraints, ",")
+	}
+
+	for _, secret := range request.Secrets {
+		launchConfig.Secrets = append(launchConfig.Secrets, client.SecretReference{Name: secret})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := map[string]int64{
+		"1024": 1024,
+		"64k":  64 << 10,
+		"128m": 128 << 20,
+		"1G":   1 << 30,
+	}
+	for value, expected := range cases {
+		bytes, err := parseMemory(value)
+		assert.Nil(err, value)
+		assert.Equal(expected, bytes, value)
+		reparsed, _ := parseMemory(formatMemory(bytes))
+		assert.Equal(bytes, reparsed, value)
+	}
+
+	for _, value := range []string{"", "m", "-1m", "1.5g", "1t"} {
+		_, err := parseMemory(value)
+		assert.NotNil(err, value)
+	}
+}
+
+func Test_MakeServiceSpec_Maps_Resources(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	request := types.CreateFunctionRequest{
+		Limits:  &types.FunctionResources{Memory: "256m", CPU: "1.5"},
+		Secrets: []string{"api-key"},
+	}
+	request.Service = "some-fn"
+	request.Constraints = []string{"zone=a"}
+
+	// Act
+	spec := makeServiceSpec(request)
+
+	// Assert
+	assert.Equal(int64(256<<20), spec.LaunchConfig.Memory)
+	assert.Equal(int64(150000), spec.LaunchConfig.CpuQuota)
+	assert.Equal(int64(100000), spec.LaunchConfig.CpuPeriod)
+	assert.Equal("zone=a", spec.LaunchConfig.Labels["io.rancher.scheduler.affinity:host_label"])
+	assert.Equal("api-key", spec.LaunchConfig.Secrets[0].Name)
+}
+
+func Test_ValidateFunctionRequest_Rejects_Invalid_Limits(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	request := types.CreateFunctionRequest{Limits: &types.FunctionResources{CPU: "a lot"}}
+	request.Service = "some-fn"
+
+	// Act
+	err := validateFunctionRequest(&request)
+
+	// Assert
+	assert.NotNil(err)
+}
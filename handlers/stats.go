@@ -0,0 +1,515 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// statsInterval is how often the stats of a function are written in streaming mode
+var statsInterval = time.Second
+
+// statsTimeout bounds how long a one-shot request waits for two samples of each instance,
+// CPU usage and throughput are computed from the difference of two samples
+var statsTimeout = time.Second * 5
+
+// statsConcurrency bounds how many functions the collector reads the stats of at once
+var statsConcurrency = 4
+
+// MakeStatsHandler reports the CPU, memory and network usage of a function summed over its instances.
+// With ?stream=true the usage is written as newline delimited JSON until the client goes away or
+// streams are closed.
+func MakeStatsHandler(client rancher.BridgeClient, gauges *StatsGauges, streams *Streams) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		functionName := vars["name"]
+
+		stream := false
+		if value := r.URL.Query().Get("stream"); len(value) > 0 {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("stream must be true or false"))
+				return
+			}
+			stream = parsed
+		}
+
+		service, findErr := client.FindServiceByNameWithContext(r.Context(), functionName)
+		if findErr != nil {
+			writeBridgeError(w, r, findErr, http.StatusInternalServerError, "")
+			return
+		}
+		if service == nil || !IsFunction(service) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		done := r.Context().Done()
+		aggregator, samples, stop, openErr := openFunctionStats(r.Context(), client, service)
+		if openErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to open stats", "function", functionName, "error", openErr)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to open the stats of " + functionName))
+			return
+		}
+		defer close(stop)
+
+		if !stream {
+			if !aggregator.collect(samples, time.After(statsTimeout), done) {
+				return
+			}
+			stats := aggregator.stats()
+			gauges.set(r.Context(), stats)
+
+			statsBytes, _ := json.Marshal(stats)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(statsBytes)
+			return
+		}
+
+		out, streamErr := openStream(w, r, "application/x-ndjson", streams)
+		if streamErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to stream stats", "function", functionName, "error", streamErr)
+			return
+		}
+		defer out.Close()
+		done = out.done
+
+		encoder := json.NewEncoder(out)
+		writeStats := func() error {
+			if !aggregator.updated {
+				return nil
+			}
+			aggregator.updated = false
+
+			stats := aggregator.stats()
+			gauges.set(r.Context(), stats)
+			return encoder.Encode(stats)
+		}
+
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case sample, ok := <-samples:
+				if !ok {
+					// every instance went away, report what was last seen
+					writeStats()
+					return
+				}
+				aggregator.add(sample)
+			case <-ticker.C:
+				if err := writeStats(); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// instanceSample is a stats sample of one of a function's instances
+type instanceSample struct {
+	instance string
+	sample   rancher.StatsSample
+}
+
+// openFunctionStats opens the stats websocket of every running instance of the service,
+// the samples are read until stop is closed or ctx is done
+func openFunctionStats(ctx context.Context, bridge rancher.BridgeClient, service *client.Service) (*statsAggregator, <-chan instanceSample, chan struct{}, error) {
+	containers, listErr := bridge.ListInstancesWithContext(ctx, service)
+	if listErr != nil {
+		return nil, nil, nil, listErr
+	}
+
+	conns := make(map[string]*websocket.Conn)
+	running := 0
+	for i := range containers {
+		container := &containers[i]
+		if container.State != "running" {
+			continue
+		}
+		running++
+
+		access, err := bridge.ContainerStatsWithContext(ctx, container)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Unable to request stats", "instance", container.Name, "error", err)
+			continue
+		}
+
+		conn, err := rancher.DialStatsAccess(access)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Unable to open stats", "instance", container.Name, "error", err)
+			continue
+		}
+		conns[container.Name] = conn
+	}
+	if running > 0 && len(conns) == 0 {
+		return nil, nil, nil, errors.New("no stats could be opened for " + service.Name)
+	}
+
+	aggregator := newStatsAggregator(service.Name)
+	samples := make(chan instanceSample)
+	stop := make(chan struct{})
+
+	remaining := len(conns)
+	finished := make(chan struct{}, len(conns))
+	for instance, conn := range conns {
+		aggregator.instances[instance] = &instanceUsage{}
+		go func(instance string, conn *websocket.Conn) {
+			readStats(ctx, conn, instance, samples, stop)
+			finished <- struct{}{}
+		}(instance, conn)
+	}
+	go func() {
+		for ; remaining > 0; remaining-- {
+			<-finished
+		}
+		close(samples)
+	}()
+
+	return aggregator, samples, stop, nil
+}
+
+// readStats forwards the samples read from the websocket until it is closed, stop is or ctx is done
+func readStats(ctx context.Context, conn *websocket.Conn, instance string, samples chan<- instanceSample, stop <-chan struct{}) {
+	done := ctx.Done()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		parsed, parseErr := rancher.ParseStatsSamples(data)
+		if parseErr != nil {
+			logging.FromContext(ctx).Warn("Unable to parse stats", "instance", instance, "error", parseErr)
+			continue
+		}
+
+		for _, sample := range parsed {
+			select {
+			case samples <- instanceSample{instance: instance, sample: sample}:
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// instanceUsage keeps the last two samples of an instance
+type instanceUsage struct {
+	previous *rancher.StatsSample
+	last     *rancher.StatsSample
+}
+
+// statsAggregator sums the usage of a function's instances
+type statsAggregator struct {
+	name      string
+	instances map[string]*instanceUsage
+	// updated is set when a sample arrived since it was last reset
+	updated bool
+}
+
+func newStatsAggregator(name string) *statsAggregator {
+	return &statsAggregator{
+		name:      name,
+		instances: make(map[string]*instanceUsage),
+	}
+}
+
+func (a *statsAggregator) add(sample instanceSample) {
+	usage, ok := a.instances[sample.instance]
+	if !ok {
+		usage = &instanceUsage{}
+		a.instances[sample.instance] = usage
+	}
+
+	current := sample.sample
+	usage.previous = usage.last
+	usage.last = &current
+	a.updated = true
+}
+
+// complete tells whether every instance sent at least two samples
+func (a *statsAggregator) complete() bool {
+	for _, usage := range a.instances {
+		if usage.previous == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// collect adds samples until the aggregation is complete, the samples run out or timeout fires,
+// false is returned when done is closed first
+func (a *statsAggregator) collect(samples <-chan instanceSample, timeout <-chan time.Time, done <-chan struct{}) bool {
+	for !a.complete() {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				return true
+			}
+			a.add(sample)
+		case <-timeout:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+func (a *statsAggregator) stats() types.FunctionStats {
+	stats := types.FunctionStats{
+		Name: a.name,
+	}
+
+	for _, usage := range a.instances {
+		last := usage.last
+		if last == nil {
+			continue
+		}
+		stats.Replicas++
+		if last.Timestamp.After(stats.Timestamp) {
+			stats.Timestamp = last.Timestamp
+		}
+
+		rx, tx := networkBytes(last)
+		stats.MemoryBytes += last.Memory.Usage
+		stats.MemoryLimitBytes += last.MemLimit
+		stats.NetworkRxBytes += rx
+		stats.NetworkTxBytes += tx
+
+		previous := usage.previous
+		if previous == nil {
+			continue
+		}
+		elapsed := last.Timestamp.Sub(previous.Timestamp).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		previousRx, previousTx := networkBytes(previous)
+		stats.CPUPercent += counterRate(previous.CPU.Usage.Total, last.CPU.Usage.Total, elapsed) / float64(time.Second) * 100
+		stats.NetworkRxBytesPerSecond += counterRate(previousRx, rx, elapsed)
+		stats.NetworkTxBytesPerSecond += counterRate(previousTx, tx, elapsed)
+	}
+
+	return stats
+}
+
+// networkBytes sums the traffic of every interface of the sample
+func networkBytes(sample *rancher.StatsSample) (uint64, uint64) {
+	var rx, tx uint64
+	for _, networkInterface := range sample.Network.Interfaces {
+		rx += networkInterface.RxBytes
+		tx += networkInterface.TxBytes
+	}
+	return rx, tx
+}
+
+// counterRate is the per second increase of a counter, a reset counts as no increase
+func counterRate(previous uint64, current uint64, elapsedSeconds float64) float64 {
+	if current < previous {
+		return 0
+	}
+	return float64(current-previous) / elapsedSeconds
+}
+
+// StatsGauges exports the stats of functions as Prometheus gauges, labelled with their namespace and
+// environment. A nil *StatsGauges exports nothing.
+type StatsGauges struct {
+	cpu         *metrics.Gauge
+	memory      *metrics.Gauge
+	networkRx   *metrics.Gauge
+	networkTx   *metrics.Gauge
+	environment string
+	lock        sync.Mutex
+	// exportedFor holds the functions exported of each scope, see scopeOf
+	exportedFor map[string]map[string]bool
+}
+
+// NewStatsGauges registers the stats gauges, the functions of the default environment are labelled
+// with defaultEnvironment
+func NewStatsGauges(registry *metrics.Registry, defaultEnvironment string) *StatsGauges {
+	labelNames := []string{"function_name", "namespace", "environment"}
+	return &StatsGauges{
+		cpu:         registry.NewGauge("faas_rancher_function_cpu_percent", "CPU usage of a function in percent of a single core.", labelNames...),
+		memory:      registry.NewGauge("faas_rancher_function_memory_bytes", "Memory usage of a function in bytes.", labelNames...),
+		networkRx:   registry.NewGauge("faas_rancher_function_network_receive_bytes_per_second", "Bytes received by a function per second.", labelNames...),
+		networkTx:   registry.NewGauge("faas_rancher_function_network_transmit_bytes_per_second", "Bytes sent by a function per second.", labelNames...),
+		environment: defaultEnvironment,
+		exportedFor: make(map[string]map[string]bool),
+	}
+}
+
+// labelValues are the labels of the named function in the namespace and environment of ctx
+func (g *StatsGauges) labelValues(ctx context.Context, name string) []string {
+	environment := g.environment
+	if fromContext, ok := rancher.EnvironmentFromContext(ctx); ok {
+		environment = fromContext.Name
+	}
+	return []string{name, rancher.NamespaceFromContext(ctx), environment}
+}
+
+func (g *StatsGauges) set(ctx context.Context, stats types.FunctionStats) {
+	if g == nil {
+		return
+	}
+
+	g.lock.Lock()
+	scope := scopeOf(ctx)
+	if g.exportedFor[scope] == nil {
+		g.exportedFor[scope] = make(map[string]bool)
+	}
+	g.exportedFor[scope][stats.Name] = true
+	g.lock.Unlock()
+
+	labelValues := g.labelValues(ctx, stats.Name)
+	g.cpu.Set(stats.CPUPercent, labelValues...)
+	g.memory.Set(float64(stats.MemoryBytes), labelValues...)
+	g.networkRx.Set(stats.NetworkRxBytesPerSecond, labelValues...)
+	g.networkTx.Set(stats.NetworkTxBytesPerSecond, labelValues...)
+}
+
+// retain drops the gauges of the functions of the scope of ctx which are not in names
+func (g *StatsGauges) retain(ctx context.Context, names map[string]bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	exported := g.exportedFor[scopeOf(ctx)]
+	for name := range exported {
+		if names[name] {
+			continue
+		}
+		delete(exported, name)
+		labelValues := g.labelValues(ctx, name)
+		g.cpu.Delete(labelValues...)
+		g.memory.Delete(labelValues...)
+		g.networkRx.Delete(labelValues...)
+		g.networkTx.Delete(labelValues...)
+	}
+}
+
+// StatsCollector periodically collects the stats of every function into the gauges,
+// so they are exported without anyone requesting the stats endpoint
+type StatsCollector struct {
+	client rancher.BridgeClient
+	gauges *StatsGauges
+}
+
+// NewStatsCollector creates a collector updating the gauges
+func NewStatsCollector(client rancher.BridgeClient, gauges *StatsGauges) *StatsCollector {
+	return &StatsCollector{
+		client: client,
+		gauges: gauges,
+	}
+}
+
+// Run collects the stats every interval until stop is closed
+func (c *StatsCollector) Run(interval time.Duration, stop <-chan struct{}) {
+	// abandon a collection in progress once stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Collect(ctx)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Collect updates the gauges of every function once, reading statsConcurrency functions at a time
+// so that a slow one doesn't hold the others. It gives up when ctx is done.
+func (c *StatsCollector) Collect(ctx context.Context) {
+	services, err := c.client.ListServicesWithContext(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Unable to list functions for stats", "error", err)
+		return
+	}
+
+	names := make(map[string]bool)
+	slots := make(chan struct{}, statsConcurrency)
+	wg := sync.WaitGroup{}
+	for i := range services {
+		service := &services[i]
+		if !IsFunction(service) {
+			continue
+		}
+		names[service.Name] = true
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			c.collect(ctx, service)
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	c.gauges.retain(ctx, names)
+}
+
+// collect updates the gauges of the function
+func (c *StatsCollector) collect(ctx context.Context, service *client.Service) {
+	aggregator, samples, stop, openErr := openFunctionStats(ctx, c.client, service)
+	if openErr != nil {
+		logging.FromContext(ctx).Warn("Unable to open stats", "function", service.Name, "error", openErr)
+		return
+	}
+	completed := aggregator.collect(samples, time.After(statsTimeout), ctx.Done())
+	close(stop)
+	if completed {
+		c.gauges.set(ctx, aggregator.stats())
+	}
+}
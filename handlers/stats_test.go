@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// makeStatsClient mocks a function with two running instances and a stopped one, whose samples
+// are served by the given websocket server
+func makeStatsClient(wsURL string) *mocks.BridgeClient {
+	mockClient := new(mocks.BridgeClient)
+	service := makeFunctionService("some-service", "active", 2, 2, nil)
+	containers := []client.Container{
+		{Name: "some-service-1", State: "running"},
+		{Name: "some-service-2", State: "running"},
+		{Name: "some-service-3", State: "stopped"},
+	}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return(containers, nil)
+	mockClient.On("ContainerStatsWithContext", mock.Anything, &containers[0]).Return(&client.StatsAccess{Url: wsURL, Token: "token-1"}, nil)
+	mockClient.On("ContainerStatsWithContext", mock.Anything, &containers[1]).Return(&client.StatsAccess{Url: wsURL, Token: "token-2"}, nil)
+	return mockClient
+}
+
+func makeStatsServerForTwoInstances(t *testing.T) *httptest.Server {
+	return makeLogsServer(t, map[string][]string{
+		"token-1": {
+			`[{"id":"1","timestamp":"2017-10-01T10:00:00Z","memLimit":1000,"cpu":{"usage":{"total":1000000000}},"memory":{"usage":100},"network":{"interfaces":[{"name":"eth0","rx_bytes":1000,"tx_bytes":500}]}}]`,
+			`[{"id":"1","timestamp":"2017-10-01T10:00:01Z","memLimit":1000,"cpu":{"usage":{"total":1500000000}},"memory":{"usage":200},"network":{"interfaces":[{"name":"eth0","rx_bytes":2000,"tx_bytes":700}]}}]`,
+		},
+		"token-2": {
+			`{"id":"2","timestamp":"2017-10-01T10:00:00Z","memLimit":1000,"cpu":{"usage":{"total":0}},"memory":{"usage":50},"network":{"interfaces":[{"name":"eth0","rx_bytes":0,"tx_bytes":0}]}}`,
+			`{"id":"2","timestamp":"2017-10-01T10:00:02Z","memLimit":1000,"cpu":{"usage":{"total":1000000000}},"memory":{"usage":60},"network":{"interfaces":[{"name":"eth0","rx_bytes":400,"tx_bytes":0}]}}`,
+		},
+	})
+}
+
+func assertTwoInstanceStats(assert *assert.Assertions, stats types.FunctionStats) {
+	assert.Equal("some-service", stats.Name)
+	assert.Equal(int64(2), stats.Replicas)
+	assert.Equal("2017-10-01T10:00:02Z", stats.Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+	assert.InDelta(100, stats.CPUPercent, 0.001)
+	assert.Equal(uint64(260), stats.MemoryBytes)
+	assert.Equal(uint64(2000), stats.MemoryLimitBytes)
+	assert.Equal(uint64(2400), stats.NetworkRxBytes)
+	assert.Equal(uint64(700), stats.NetworkTxBytes)
+	assert.InDelta(1200, stats.NetworkRxBytesPerSecond, 0.001)
+	assert.InDelta(200, stats.NetworkTxBytesPerSecond, 0.001)
+}
+
+func Test_MakeStatsHandler_Sums_Instances(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeStatsServerForTwoInstances(t)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stats/"
+
+	mockClient := makeStatsClient(wsURL)
+	registry := metrics.NewRegistry()
+	handler := MakeStatsHandler(mockClient, NewStatsGauges(registry, "default"), NewStreams())
+
+	req, _ := http.NewRequest("GET", "/system/functions/some-service/stats", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	stats := types.FunctionStats{}
+	assert.Nil(json.Unmarshal(rr.Body.Bytes(), &stats))
+	assertTwoInstanceStats(assert, stats)
+
+	metricsRecorder := httptest.NewRecorder()
+	registry.ServeHTTP(metricsRecorder, req)
+	assert.Contains(metricsRecorder.Body.String(), `faas_rancher_function_memory_bytes{function_name="some-service",namespace="default",environment="default"} 260`)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeStatsHandler_Labels_Gauges_With_Namespace_And_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeStatsServerForTwoInstances(t)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stats/"
+
+	mockClient := makeStatsClient(wsURL)
+	registry := metrics.NewRegistry()
+	handler := MakeStatsHandler(mockClient, NewStatsGauges(registry, "default"), NewStreams())
+
+	req, _ := http.NewRequest("GET", "/system/functions/some-service/stats", nil)
+	ctx := rancher.WithEnvironment(rancher.WithNamespace(req.Context(), "team-a"), rancher.Environment{Name: "staging"})
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req.WithContext(ctx), map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	metricsRecorder := httptest.NewRecorder()
+	registry.ServeHTTP(metricsRecorder, req)
+	assert.Contains(metricsRecorder.Body.String(), `faas_rancher_function_memory_bytes{function_name="some-service",namespace="team-a",environment="staging"} 260`)
+	assert.NotContains(metricsRecorder.Body.String(), `namespace="default"`)
+}
+
+func Test_StatsCollector_Collect_Does_Not_Wait_For_Slow_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeStatsServerForTwoInstances(t)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stats/"
+
+	mockClient := makeStatsClient(wsURL)
+	release := make(chan time.Time)
+	slowService := makeFunctionService("slow-service", "active", 1, 1, nil)
+	someService := makeFunctionService("some-service", "active", 2, 2, nil)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{*slowService, *someService}, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, slowService).Return([]client.Container{}, nil).WaitUntil(release)
+	registry := metrics.NewRegistry()
+	collector := NewStatsCollector(mockClient, NewStatsGauges(registry, "default"))
+	collected := make(chan struct{})
+	go func() {
+		collector.Collect(context.Background())
+		close(collected)
+	}()
+
+	// Act
+	exported := false
+	for deadline := time.Now().Add(3 * time.Second); !exported && time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		metricsRecorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		registry.ServeHTTP(metricsRecorder, req)
+		exported = strings.Contains(metricsRecorder.Body.String(), `function_name="some-service"`)
+	}
+	close(release)
+	<-collected
+
+	// Assert
+	assert.True(exported, "the stats of a function waited for a slow one")
+}
+
+func Test_MakeStatsHandler_Streams_Stats(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeStatsServerForTwoInstances(t)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stats/"
+
+	mockClient := makeStatsClient(wsURL)
+	handler := MakeStatsHandler(mockClient, nil, NewStreams())
+
+	req, _ := http.NewRequest("GET", "/system/functions/some-service/stats?stream=true", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("application/x-ndjson", rr.Header().Get("Content-Type"))
+
+	var last types.FunctionStats
+	lines := 0
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		last = types.FunctionStats{}
+		assert.Nil(json.Unmarshal(scanner.Bytes(), &last))
+		lines++
+	}
+	assert.True(lines > 0)
+	assertTwoInstanceStats(assert, last)
+}
+
+func Test_MakeStatsHandler_Invalid_Stream(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeStatsHandler(mockClient, nil, NewStreams())
+
+	req, _ := http.NewRequest("GET", "/system/functions/some-service/stats?stream=sometimes", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertNotCalled(t, "FindServiceByNameWithContext", mock.Anything, "some-service")
+}
+
+func Test_MakeStatsHandler_Unknown_Function(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeStatsHandler(mockClient, nil, NewStreams())
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "unknown").Return(nil, nil)
+
+	req, _ := http.NewRequest("GET", "/system/functions/unknown/stats", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "unknown"})
+
+	// Assert
+	assert.Equal(http.StatusNotFound, rr.Code)
+}
+
+func Test_MakeStatsHandler_Stream_Outlasts_WriteTimeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	statsInterval = 20 * time.Millisecond
+	defer func() { statsInterval = time.Second }()
+	samples := []string{}
+	for i := 0; i < 5; i++ {
+		samples = append(samples, fmt.Sprintf(`{"id":"1","timestamp":"2017-10-01T10:00:0%dZ","memLimit":1000,"cpu":{"usage":{"total":%d}},"memory":{"usage":100}}`, i, i*100000000))
+	}
+	statsServer := makeSlowServer(t, samples, 100*time.Millisecond)
+	defer statsServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(statsServer.URL, "http")
+
+	mockClient := new(mocks.BridgeClient)
+	service := makeFunctionService("some-service", "active", 1, 1, nil)
+	container := client.Container{Name: "some-service-1", State: "running"}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(service, nil)
+	mockClient.On("ListInstancesWithContext", mock.Anything, service).Return([]client.Container{container}, nil)
+	mockClient.On("ContainerStatsWithContext", mock.Anything, mock.Anything).Return(&client.StatsAccess{Url: wsURL}, nil)
+	handler := MakeStatsHandler(mockClient, nil, NewStreams())
+	server := serveWithWriteTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, map[string]string{"name": "some-service"})
+	}), 200*time.Millisecond)
+	defer server.Close()
+
+	// Act
+	res, err := http.Get(server.URL + "/system/functions/some-service/stats?stream=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, readErr := ioutil.ReadAll(res.Body)
+
+	// Assert
+	assert.Nil(readErr)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	last := types.FunctionStats{}
+	assert.Nil(json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal("2017-10-01T10:00:04Z", last.Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+	assert.InDelta(10, last.CPUPercent, 0.001)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// streamsCloseTimeout is how long Streams.Close lets the streams end before their connections are closed
+var streamsCloseTimeout = time.Second
+
+// Streams tracks the long-lived responses, followed logs, streamed stats and exec sessions, whose
+// connections are hijacked so that the http.Server neither waits for them nor ends them when it
+// shuts down. Closing the Streams ends them.
+type Streams struct {
+	lock    sync.Mutex
+	closed  bool
+	done    chan struct{}
+	conns   map[io.Closer]bool
+	running sync.WaitGroup
+}
+
+// NewStreams creates Streams tracking no stream yet
+func NewStreams() *Streams {
+	return &Streams{done: make(chan struct{}), conns: make(map[io.Closer]bool)}
+}
+
+// Done is closed once the streams are to end
+func (s *Streams) Done() <-chan struct{} {
+	return s.done
+}
+
+// track adds the connection of a stream, it returns false and the stream must not start once the
+// Streams are closed
+func (s *Streams) track(conn io.Closer) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	s.running.Add(1)
+	return true
+}
+
+// untrack removes the connection of a stream which ended
+func (s *Streams) untrack(conn io.Closer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conns[conn] {
+		delete(s.conns, conn)
+		s.running.Done()
+	}
+}
+
+// Close tells the streams to end, which lets them end their responses, and waits up to
+// streamsCloseTimeout for them to do so before closing the connections of the remaining ones. It
+// is meant for http.Server.RegisterOnShutdown.
+func (s *Streams) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	s.lock.Unlock()
+
+	ended := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+		return
+	case <-time.After(streamsCloseTimeout):
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+		s.running.Done()
+	}
+}
+
+// stream is a response written as it goes, such as followed logs, which lasts longer than the
+// WriteTimeout of the server. Every write is flushed to the client.
+type stream struct {
+	writer io.Writer
+	flush  func() error
+	finish func()
+	// done is closed once the client went away or the streams are closed
+	done <-chan struct{}
+}
+
+// openStream answers 200 with the content type and returns the stream the body is written to,
+// which has to be closed. The connection is hijacked so that the deadlines set by the timeouts of
+// the server can be cleared, the body is then chunked here, and it is tracked by streams.
+// Responses which can't be hijacked, such as recorders or HTTP/2 ones, are written to directly,
+// their deadline can't be cleared so the stream ends before the WriteTimeout cuts it, as ?wait= does.
+func openStream(w http.ResponseWriter, r *http.Request, contentType string, streams *Streams) (*stream, error) {
+	w.Header().Set("Content-Type", contentType)
+
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	end := func() { doneOnce.Do(func() { close(done) }) }
+	go func() {
+		select {
+		case <-streams.Done():
+			end()
+		case <-done:
+		}
+	}()
+
+	var conn net.Conn
+	var buffered *bufio.ReadWriter
+	var err error
+	hijacker, ok := w.(http.Hijacker)
+	if ok {
+		conn, buffered, err = hijacker.Hijack()
+	}
+	if !ok || err == http.ErrNotSupported {
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		go func() {
+			var timeout <-chan time.Time
+			if maxWait > 0 {
+				timer := time.NewTimer(maxWait)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+			select {
+			case <-r.Context().Done():
+				end()
+			case <-timeout:
+				end()
+			case <-done:
+			}
+		}()
+		return &stream{
+			writer: w,
+			flush: func() error {
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return nil
+			},
+			finish: end,
+			done:   done,
+		}, nil
+	}
+
+	if err != nil {
+		end()
+		return nil, err
+	}
+	if !streams.track(conn) {
+		end()
+		conn.Close()
+		return nil, fmt.Errorf("the server is shutting down")
+	}
+	conn.SetDeadline(time.Time{})
+
+	header := w.Header()
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	header.Set("Transfer-Encoding", "chunked")
+	header.Set("Connection", "close")
+	fmt.Fprintf(buffered, "HTTP/1.1 %d %s\r\n", http.StatusOK, http.StatusText(http.StatusOK))
+	header.Write(buffered)
+	buffered.WriteString("\r\n")
+	if err := buffered.Flush(); err != nil {
+		end()
+		streams.untrack(conn)
+		conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		// the client sends nothing more, so reading only returns once it closed the connection
+		io.Copy(ioutil.Discard, buffered.Reader)
+		end()
+	}()
+
+	chunked := httputil.NewChunkedWriter(buffered)
+	return &stream{
+		writer: chunked,
+		flush:  buffered.Flush,
+		finish: func() {
+			chunked.Close()
+			buffered.WriteString("\r\n")
+			buffered.Flush()
+			end()
+			streams.untrack(conn)
+			conn.Close()
+		},
+		done: done,
+	}, nil
+}
+
+// Write writes and flushes p
+func (s *stream) Write(p []byte) (int, error) {
+	n, err := s.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, s.flush()
+}
+
+// Close ends the response
+func (s *stream) Close() {
+	s.finish()
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time, it is replaced by a fake one in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock backed by time.Now
+type SystemClock struct {
+}
+
+// Now returns time.Now()
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// InvocationTracker records the invocations the proxy sees for each function
+type InvocationTracker struct {
+	lock      sync.Mutex
+	functions map[string]*invocationStats
+	clock     Clock
+}
+
+type invocationStats struct {
+	lastInvocation time.Time
+	invocations    uint64
+	inFlight       int64
+	// inFlightSeconds integrates inFlight over time, up to inFlightSince
+	inFlightSeconds float64
+	inFlightSince   time.Time
+	// awake is set once the function is known to have instances, it is cleared when it is scaled to zero
+	awake bool
+	// wake serialises wake-ups so that concurrent requests only scale the function once
+	wake sync.Mutex
+}
+
+// InvocationSnapshot is the state of a function's counters at a point in time.
+// The difference of two snapshots gives the request rate and the average concurrency.
+type InvocationSnapshot struct {
+	Taken           time.Time
+	Invocations     uint64
+	InFlight        int64
+	InFlightSeconds float64
+}
+
+// NewInvocationTracker creates an empty tracker
+func NewInvocationTracker() *InvocationTracker {
+	return NewInvocationTrackerWithClock(SystemClock{})
+}
+
+// NewInvocationTrackerWithClock creates an empty tracker which reads the time from clock
+func NewInvocationTrackerWithClock(clock Clock) *InvocationTracker {
+	return &InvocationTracker{
+		functions: make(map[string]*invocationStats),
+		clock:     clock,
+	}
+}
+
+func (t *InvocationTracker) stats(name string) *invocationStats {
+	stats, ok := t.functions[name]
+	if !ok {
+		stats = &invocationStats{}
+		t.functions[name] = stats
+	}
+	return stats
+}
+
+// accumulate adds the in-flight seconds elapsed since the last change of inFlight
+func (stats *invocationStats) accumulate(now time.Time) {
+	if !stats.inFlightSince.IsZero() {
+		stats.inFlightSeconds += float64(stats.inFlight) * now.Sub(stats.inFlightSince).Seconds()
+	}
+	stats.inFlightSince = now
+}
+
+// Begin records the start of an invocation
+func (t *InvocationTracker) Begin(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.clock.Now()
+	stats := t.stats(name)
+	stats.accumulate(now)
+	stats.lastInvocation = now
+	stats.invocations++
+	stats.inFlight++
+}
+
+// End records the completion of an invocation started with Begin
+func (t *InvocationTracker) End(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.clock.Now()
+	stats := t.stats(name)
+	stats.accumulate(now)
+	stats.lastInvocation = now
+	stats.inFlight--
+}
+
+// Snapshot returns the current counters of the function
+func (t *InvocationTracker) Snapshot(name string) InvocationSnapshot {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.clock.Now()
+	stats := t.stats(name)
+	stats.accumulate(now)
+	return InvocationSnapshot{
+		Taken:           now,
+		Invocations:     stats.invocations,
+		InFlight:        stats.inFlight,
+		InFlightSeconds: stats.inFlightSeconds,
+	}
+}
+
+// LastInvocation returns when the function was last invoked, false if it never was
+func (t *InvocationTracker) LastInvocation(name string) (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats, ok := t.functions[name]
+	if !ok || stats.lastInvocation.IsZero() {
+		return time.Time{}, false
+	}
+	return stats.lastInvocation, true
+}
+
+// InFlight returns the number of invocations currently being proxied to the function
+func (t *InvocationTracker) InFlight(name string) int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats, ok := t.functions[name]
+	if !ok {
+		return 0
+	}
+	return stats.inFlight
+}
+
+// MarkAwake records that the function has running instances
+func (t *InvocationTracker) MarkAwake(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stats(name).awake = true
+}
+
+// MarkIdle records that the function was scaled to zero
+func (t *InvocationTracker) MarkIdle(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stats(name).awake = false
+}
+
+// MarkIdleIfIdle records that the function is being scaled to zero if it has no invocation in
+// flight and wasn't invoked after idleSince, and tells whether it did. Invocations starting
+// afterwards find it asleep and wake it up.
+func (t *InvocationTracker) MarkIdleIfIdle(name string, idleSince time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats := t.stats(name)
+	if stats.inFlight > 0 || stats.lastInvocation.After(idleSince) {
+		return false
+	}
+	stats.awake = false
+	return true
+}
+
+// Awake tells whether the function is known to have running instances
+func (t *InvocationTracker) Awake(name string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats, ok := t.functions[name]
+	return ok && stats.awake
+}
+
+// WakeLock is held while the function is scaled from or to zero, so that a wake-up never
+// overlaps the scale down it follows
+func (t *InvocationTracker) WakeLock(name string) *sync.Mutex {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return &t.stats(name).wake
+}
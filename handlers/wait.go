@@ -0,0 +1,127 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// waitPollInterval is how often a service is looked up while waiting for it to become ready
+var waitPollInterval = time.Second
+
+// maxWait bounds ?wait=, so that the 504 is written before the WriteTimeout of the server cuts the
+// connection. Zero leaves it unbounded.
+var maxWait time.Duration
+
+// waitMargin is left between the end of the longest wait and the WriteTimeout to write the response
+const waitMargin = time.Second
+
+// LimitWait bounds the ?wait= of the handlers by the WriteTimeout of the server, zero being none
+func LimitWait(writeTimeout time.Duration) {
+	maxWait = 0
+	if writeTimeout > 0 {
+		maxWait = writeTimeout - waitMargin
+		if maxWait <= 0 {
+			maxWait = writeTimeout / 2
+		}
+	}
+}
+
+// parseWait reads the optional ?wait=<duration> query parameter, zero means don't wait. Waits
+// longer than the WriteTimeout allows are shortened to fit.
+func parseWait(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("(%s) is not a valid wait duration", value)
+	}
+	if maxWait > 0 && wait > maxWait {
+		wait = maxWait
+	}
+	return wait, nil
+}
+
+// serviceReady tells whether the service is active and running the desired number of healthy instances
+func serviceReady(service *client.Service, replicas int64) bool {
+	if service.State != "active" || service.Transitioning == "yes" {
+		return false
+	}
+	if service.Scale != replicas || service.CurrentScale != replicas {
+		return false
+	}
+	// a service without instances has nothing to report its health from
+	return replicas == 0 || service.HealthState == "healthy"
+}
+
+// waitForService polls the named service until it is ready, the timeout elapses or ctx is done.
+// The last observed service is returned in every case so it can be reported back.
+func waitForService(ctx context.Context, bridge rancher.BridgeClient, name string, replicas int64, timeout time.Duration) (*client.Service, error) {
+	deadline := time.Now().Add(timeout)
+
+	var last *client.Service
+	for {
+		service, err := bridge.FindServiceByNameWithContext(ctx, name)
+		if err == nil && service != nil {
+			last = service
+			if serviceReady(service, replicas) {
+				return service, nil
+			}
+		}
+
+		if time.Now().Add(waitPollInterval).After(deadline) {
+			return last, fmt.Errorf("timed out after %s waiting for %s to become ready", timeout, name)
+		}
+		select {
+		case <-time.After(waitPollInterval):
+		case <-ctx.Done():
+			return last, ctx.Err()
+		}
+	}
+}
+
+// makeServiceStatus summarises the observed state of a service, which may be nil
+func makeServiceStatus(name string, service *client.Service) *types.ServiceStatus {
+	status := &types.ServiceStatus{
+		Name: name,
+	}
+	if service != nil {
+		status.State = service.State
+		status.HealthState = service.HealthState
+		status.Transitioning = service.Transitioning
+		status.TransitioningMessage = service.TransitioningMessage
+		status.Scale = service.Scale
+		status.CurrentScale = service.CurrentScale
+	}
+	return status
+}
+
+// writeServiceStatus writes the observed state of a service as JSON
+func writeServiceStatus(w http.ResponseWriter, name string, service *client.Service, statusCode int) {
+	statusBytes, _ := json.Marshal(makeServiceStatus(name, service))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(statusBytes)
+}
+
+// respondWhenReady blocks until the service is ready, answering 200 or 504 with its last observed state
+func respondWhenReady(ctx context.Context, w http.ResponseWriter, bridge rancher.BridgeClient, name string, replicas int64, wait time.Duration) {
+	service, err := waitForService(ctx, bridge, name, replicas, wait)
+	if err != nil {
+		writeServiceStatus(w, name, service, http.StatusGatewayTimeout)
+		return
+	}
+	writeServiceStatus(w, name, service, http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexellis/faas/gateway/requests"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/kenfdev/faas-rancher/types"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func init() {
+	waitPollInterval = time.Millisecond
+}
+
+func makeDeployRequest(url string, service string) *http.Request {
+	b, err := json.Marshal(requests.CreateFunctionRequest{Service: service})
+	if err != nil {
+		log.Fatal(err)
+	}
+	req, reqErr := http.NewRequest("POST", url, bytes.NewReader(b))
+	if reqErr != nil {
+		log.Fatal(reqErr)
+	}
+	return req
+}
+
+func Test_MakeDeployHandler_Wait_Until_Ready(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+	req := makeDeployRequest("/system/functions?wait=1s", "some-service")
+
+	activating := &client.Service{Name: "some-service", State: "activating", Scale: 1}
+	ready := &client.Service{Name: "some-service", State: "active", Scale: 1, CurrentScale: 1, HealthState: "healthy"}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil).Once()
+	mockClient.On("CreateServiceWithContext", mock.Anything, mock.Anything).Return(nil, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(activating, nil).Once()
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(ready, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	status := types.ServiceStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	assert.Equal("active", status.State)
+	assert.Equal(int64(1), status.CurrentScale)
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeDeployHandler_Wait_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+	req := makeDeployRequest("/system/functions?wait=20ms", "some-service")
+
+	unhealthy := &client.Service{Name: "some-service", State: "active", Scale: 1, CurrentScale: 1, HealthState: "unhealthy"}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(nil, nil).Once()
+	mockClient.On("CreateServiceWithContext", mock.Anything, mock.Anything).Return(nil, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(unhealthy, nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusGatewayTimeout, rr.Code)
+	status := types.ServiceStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	assert.Equal("unhealthy", status.HealthState)
+}
+
+func Test_MakeDeployHandler_Invalid_Wait(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	handler := MakeDeployHandler(mockClient)
+	req := makeDeployRequest("/system/functions?wait=forever", "some-service")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, nil)
+
+	// Assert
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	mockClient.AssertNotCalled(t, "CreateServiceWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_parseWait_Is_Bounded_By_WriteTimeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	LimitWait(8 * time.Second)
+	defer LimitWait(0)
+	longReq, _ := http.NewRequest("POST", "/system/functions?wait=1m", nil)
+	shortReq, _ := http.NewRequest("POST", "/system/functions?wait=2s", nil)
+
+	// Act
+	long, longErr := parseWait(longReq)
+	short, shortErr := parseWait(shortReq)
+
+	// Assert
+	assert.Nil(longErr)
+	assert.Equal(7*time.Second, long)
+	assert.Nil(shortErr)
+	assert.Equal(2*time.Second, short)
+}
+
+func Test_ServiceReady(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(serviceReady(&client.Service{State: "active", Scale: 2, CurrentScale: 2, HealthState: "healthy"}, 2))
+	assert.True(serviceReady(&client.Service{State: "active"}, 0))
+	assert.False(serviceReady(&client.Service{State: "active", Scale: 2, CurrentScale: 2, HealthState: "initializing"}, 2))
+	assert.False(serviceReady(&client.Service{State: "active", Scale: 2, CurrentScale: 1, HealthState: "healthy"}, 2))
+	assert.False(serviceReady(&client.Service{State: "updating-active", Scale: 1, CurrentScale: 1, HealthState: "healthy"}, 1))
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// MakeTrackingProxy wraps the function proxy so that its invocations are recorded by the tracker
+func MakeTrackingProxy(tracker *InvocationTracker, next VarsHandler) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		functionName := qualifiedName(r, vars["name"])
+
+		tracker.Begin(functionName)
+		defer tracker.End(functionName)
+
+		next(w, r, vars)
+	}
+}
+
+// MakeWakeUpProxy wraps the function proxy so that functions which were scaled to zero
+// are scaled back to their minimum before the request is forwarded.
+func MakeWakeUpProxy(client rancher.BridgeClient, tracker *InvocationTracker, wakeTimeout time.Duration, next VarsHandler) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		functionName := vars["name"]
+		trackedName := qualifiedName(r, functionName)
+
+		if !tracker.Awake(trackedName) {
+			if err := wakeUp(r.Context(), client, tracker, functionName, trackedName, wakeTimeout); err != nil {
+				writeBridgeError(w, r, err, http.StatusServiceUnavailable, "Unable to wake up function "+functionName)
+				return
+			}
+		}
+
+		next(w, r, vars)
+	}
+}
+
+// wakeUp scales the function to its minimum replicas if it has none and waits for it to be ready,
+// the tracker knows it as trackedName
+func wakeUp(ctx context.Context, client rancher.BridgeClient, tracker *InvocationTracker, functionName string, trackedName string, wakeTimeout time.Duration) error {
+	lock := tracker.WakeLock(trackedName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// another request may have woken the function while this one was waiting for the lock
+	if tracker.Awake(trackedName) {
+		return nil
+	}
+
+	service, findErr := client.FindServiceByNameWithContext(ctx, functionName)
+	if findErr != nil || service == nil {
+		// let the proxy report the unreachable function
+		return nil
+	}
+
+	if service.Scale > 0 {
+		tracker.MarkAwake(trackedName)
+		return nil
+	}
+
+	replicas := MinReplicas(service)
+	logging.FromContext(ctx).Info("Waking up function", "function", functionName, "replicas", replicas)
+
+	updates := make(map[string]string)
+	updates["scale"] = strconv.FormatInt(replicas, 10)
+	_, err := client.UpdateServiceWithContext(ctx, service, updates)
+	recordAudit(ctx, audit.OperationScale, functionName, service, scaleAudit{From: service.Scale, To: replicas, Reason: "wake-up"}, err)
+	if err != nil {
+		return err
+	}
+
+	if _, err := waitForService(ctx, client, functionName, replicas, wakeTimeout); err != nil {
+		return err
+	}
+
+	tracker.MarkAwake(trackedName)
+	return nil
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeRecordingProxy(called *bool) VarsHandler {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+		*called = true
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func Test_MakeWakeUpProxy_Scales_Up_Function_At_Zero(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := NewInvocationTracker()
+	proxied := false
+	handler := MakeTrackingProxy(tracker, MakeWakeUpProxy(mockClient, tracker, time.Second, makeRecordingProxy(&proxied)))
+	vars := map[string]string{"name": "some-service"}
+
+	idle := &client.Service{
+		Name:  "some-service",
+		State: "active",
+		Scale: 0,
+		LaunchConfig: &client.LaunchConfig{
+			Labels: map[string]interface{}{ScaleMinLabel: "2"},
+		},
+	}
+	ready := &client.Service{Name: "some-service", State: "active", Scale: 2, CurrentScale: 2, HealthState: "healthy"}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(idle, nil).Once()
+	mockClient.On("UpdateServiceWithContext", mock.Anything, idle, map[string]string{"scale": "2"}).Return(ready, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(ready, nil)
+
+	req, _ := http.NewRequest("POST", "/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, vars)
+
+	// Assert
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.True(proxied)
+	assert.True(tracker.Awake("some-service"))
+	_, invoked := tracker.LastInvocation("some-service")
+	assert.True(invoked)
+	assert.Equal(int64(0), tracker.InFlight("some-service"))
+	mockClient.AssertExpectations(t)
+}
+
+func Test_MakeWakeUpProxy_Awake_Function_Is_Not_Looked_Up(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := NewInvocationTracker()
+	tracker.MarkAwake("some-service")
+	proxied := false
+	handler := MakeWakeUpProxy(mockClient, tracker, time.Second, makeRecordingProxy(&proxied))
+
+	req, _ := http.NewRequest("POST", "/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.True(proxied)
+	mockClient.AssertNotCalled(t, "FindServiceByNameWithContext", mock.Anything, mock.Anything)
+}
+
+func Test_MakeWakeUpProxy_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := NewInvocationTracker()
+	proxied := false
+	handler := MakeWakeUpProxy(mockClient, tracker, 10*time.Millisecond, makeRecordingProxy(&proxied))
+
+	idle := &client.Service{Name: "some-service", State: "active", Scale: 0}
+	starting := &client.Service{Name: "some-service", State: "active", Scale: 1, CurrentScale: 1, HealthState: "initializing"}
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(idle, nil).Once()
+	mockClient.On("UpdateServiceWithContext", mock.Anything, idle, map[string]string{"scale": "1"}).Return(starting, nil)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-service").Return(starting, nil)
+
+	req, _ := http.NewRequest("POST", "/function/some-service", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler(rr, req, map[string]string{"name": "some-service"})
+
+	// Assert
+	assert.Equal(http.StatusServiceUnavailable, rr.Code)
+	assert.False(proxied)
+	assert.False(tracker.Awake("some-service"))
+}
@@ -0,0 +1,310 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package logging writes structured log lines as JSON or logfmt, with the values of sensitive
+// fields redacted, and tags the lines logged for a request with its id
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// The levels, lines below the level of a logger are dropped
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(value string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(value, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("(%s) is not a log level, use debug, info, warn or error", value)
+}
+
+// The formats of the log lines
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Redacted replaces the values of sensitive fields
+const Redacted = "[redacted]"
+
+var sensitiveKey = regexp.MustCompile(`(?i)(pass(word|wd)?|secret|token|key|auth|credential|private|cookie|signature)`)
+
+// SensitiveKey tells whether a field or variable name looks like it holds a secret
+func SensitiveKey(name string) bool {
+	return sensitiveKey.MatchString(name)
+}
+
+// output is where the loggers derived from one another write
+type output struct {
+	lock   sync.Mutex
+	writer io.Writer
+	format string
+	level  Level
+	now    func() time.Time
+}
+
+// Logger writes log lines made of a message and key value pairs, the fields set by With come
+// first. It is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a logger writing the lines of level and above in the format
+func New(writer io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatJSON && format != FormatLogfmt {
+		return nil, fmt.Errorf("(%s) is not a log format, use json or logfmt", format)
+	}
+	return &Logger{out: &output{writer: writer, format: format, level: level, now: time.Now}}, nil
+}
+
+// With returns a logger adding the key value pairs to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled tells whether lines of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Debug logs a message with key value pairs at the debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info logs a message with key value pairs at the info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn logs a message with key value pairs at the warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error logs a message with key value pairs at the error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	pairs := []interface{}{"time", l.out.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg}
+	pairs = append(pairs, l.fields...)
+	pairs = append(pairs, keyvals...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, "")
+	}
+
+	line := &bytes.Buffer{}
+	if l.out.format == FormatJSON {
+		writeJSON(line, pairs)
+	} else {
+		writeLogfmt(line, pairs)
+	}
+	line.WriteByte('\n')
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.writer.Write(line.Bytes())
+}
+
+func writeJSON(line *bytes.Buffer, pairs []interface{}) {
+	line.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key := fmt.Sprint(pairs[i])
+		keyBytes, _ := json.Marshal(key)
+		line.Write(keyBytes)
+		line.WriteByte(':')
+		valueBytes, err := json.Marshal(redact(key, pairs[i+1]))
+		if err != nil {
+			valueBytes, _ = json.Marshal(fmt.Sprint(pairs[i+1]))
+		}
+		line.Write(valueBytes)
+	}
+	line.WriteByte('}')
+}
+
+func writeLogfmt(line *bytes.Buffer, pairs []interface{}) {
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		key := fmt.Sprint(pairs[i])
+		line.WriteString(strings.Map(logfmtKeyRune, key))
+		line.WriteByte('=')
+
+		var value string
+		switch v := redact(key, pairs[i+1]).(type) {
+		case string:
+			value = v
+		case map[string]interface{}, []interface{}:
+			valueBytes, _ := json.Marshal(v)
+			value = string(valueBytes)
+		default:
+			value = fmt.Sprint(v)
+		}
+		if len(value) == 0 || strings.IndexFunc(value, needsQuote) >= 0 {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(value)
+	}
+}
+
+func logfmtKeyRune(r rune) rune {
+	if r <= ' ' || r == '=' || r == '"' {
+		return '_'
+	}
+	return r
+}
+
+func needsQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || !strconv.IsPrint(r)
+}
+
+// redact turns the value of a field into a plain value with the sensitive parts redacted.
+// Values made of fields, such as maps and structs, have their sensitive fields redacted in turn.
+func redact(key string, value interface{}) interface{} {
+	if SensitiveKey(key) {
+		return Redacted
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(valueBytes, &decoded); err != nil {
+		return fmt.Sprint(value)
+	}
+	return redactDecoded(decoded)
+}
+
+func redactDecoded(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if SensitiveKey(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = redactDecoded(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactDecoded(v[i])
+		}
+	}
+	return value
+}
+
+// Writer returns a writer logging each line written to it as a message of the level, to route
+// the standard logger through the logger
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			l.log(level, line, nil)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+var (
+	defaultLock   sync.Mutex
+	defaultLogger = &Logger{out: &output{writer: os.Stderr, format: FormatLogfmt, level: LevelInfo, now: time.Now}}
+)
+
+// Default is the logger of code running outside of requests, logfmt at the info level to stderr
+// unless replaced by SetDefault
+func Default() *Logger {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger
+func SetDefault(logger *Logger) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultLogger = logger
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying the logger
+func WithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext is the logger set by WithLogger, the default logger if none is
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(format string, level Level) (*Logger, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+	logger, _ := New(output, format, level)
+	logger.out.now = func() time.Time { return time.Date(2018, 3, 2, 10, 4, 5, 0, time.UTC) }
+	return logger, output
+}
+
+func Test_Logger_JSON(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatJSON, LevelInfo)
+
+	// Act
+	logger.With("requestId", "abc").Info("Scaling function", "function", "figlet", "to", 3, "error", errors.New("rancher is down"))
+
+	// Assert
+	line := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(output.Bytes(), &line))
+	assert.Equal(map[string]interface{}{
+		"time":      "2018-03-02T10:04:05Z",
+		"level":     "info",
+		"msg":       "Scaling function",
+		"requestId": "abc",
+		"function":  "figlet",
+		"to":        float64(3),
+		"error":     "rancher is down",
+	}, line)
+}
+
+func Test_Logger_Logfmt(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatLogfmt, LevelInfo)
+
+	// Act
+	logger.Warn("Denied request", "path", "/system/functions", "reason", `ci lacks "scale"`, "duration", 1500*time.Millisecond, "empty", "")
+
+	// Assert
+	assert.Equal(`time=2018-03-02T10:04:05Z level=warn msg="Denied request" path=/system/functions reason="ci lacks \"scale\"" duration=1.5s empty=""`+"\n", output.String())
+}
+
+func Test_Logger_Drops_Lines_Below_Level(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatLogfmt, LevelWarn)
+
+	// Act
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Error("error")
+
+	// Assert
+	assert.Contains(output.String(), "msg=error")
+	assert.NotContains(output.String(), "msg=info")
+	assert.NotContains(output.String(), "msg=debug")
+}
+
+func Test_Logger_Redacts_Sensitive_Fields(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatJSON, LevelInfo)
+	request := struct {
+		Service      string            `json:"service"`
+		EnvVars      map[string]string `json:"envVars"`
+		RegistryAuth string            `json:"registryAuth"`
+	}{"figlet", map[string]string{"DB_PASSWORD": "hunter2", "MODE": "fast"}, "dXNlcjpwYXNz"}
+
+	// Act
+	logger.Info("Deploying", "request", request, "token", "abc", "headers", map[string][]string{"Authorization": {"Bearer abc"}})
+
+	// Assert
+	line := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(output.Bytes(), &line))
+	assert.Equal(Redacted, line["token"])
+	assert.Equal(map[string]interface{}{
+		"service":      "figlet",
+		"envVars":      map[string]interface{}{"DB_PASSWORD": Redacted, "MODE": "fast"},
+		"registryAuth": Redacted,
+	}, line["request"])
+	assert.Equal(map[string]interface{}{"Authorization": Redacted}, line["headers"])
+	assert.NotContains(output.String(), "hunter2")
+}
+
+func Test_New_Invalid_Format(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(&bytes.Buffer{}, "xml", LevelInfo)
+
+	assert.NotNil(err)
+}
+
+func Test_ParseLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	debug, debugErr := ParseLevel("DEBUG")
+	warn, warnErr := ParseLevel("warning")
+	_, invalidErr := ParseLevel("verbose")
+
+	assert.Nil(debugErr)
+	assert.Equal(LevelDebug, debug)
+	assert.Nil(warnErr)
+	assert.Equal(LevelWarn, warn)
+	assert.NotNil(invalidErr)
+}
+
+func Test_Logger_Writer(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatLogfmt, LevelInfo)
+
+	// Act
+	logger.Writer(LevelError).Write([]byte("http: TLS handshake error\n"))
+
+	// Assert
+	assert.Equal(`time=2018-03-02T10:04:05Z level=error msg="http: TLS handshake error"`+"\n", output.String())
+}
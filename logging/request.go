@@ -0,0 +1,85 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package logging
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// RequestIDHeader carries the id of a request from the gateway to the provider and on to the function
+const RequestIDHeader = "X-Request-Id"
+
+// validRequestID keeps ids taken from requests from forging log lines
+var validRequestID = regexp.MustCompile(`^[-_.:a-zA-Z0-9]{1,128}$`)
+
+type requestIDKey struct{}
+
+// NewRequestID generates a random request id
+func NewRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// RequestIDFromContext is the id of the request of ctx, empty outside of requests
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// MakeMiddleware gives every request an id, the one of its X-Request-Id header or a generated
+// one, which is set on the request and on the response. The handlers find a logger tagging its
+// lines with the id through FromContext.
+func MakeMiddleware(logger *Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = NewRequestID()
+		}
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		requestLogger := logger.With("requestId", id)
+		ctx := WithLogger(context.WithValue(r.Context(), requestIDKey{}, id), requestLogger)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		requestLogger.Debug("Served request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.status, "duration", time.Since(started))
+	})
+}
+
+// statusRecorder remembers the status of a response, streaming and websockets still work through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveWithRequestID serves the request through the middleware, returning the request id the
+// handler saw along with the response
+func serveWithRequestID(logger *Logger, req *http.Request) (string, *httptest.ResponseRecorder) {
+	var seen string
+	handler := MakeMiddleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		FromContext(r.Context()).Info("Handled")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return seen, rr
+}
+
+func Test_MakeMiddleware_Keeps_Request_ID(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, output := newTestLogger(FormatLogfmt, LevelDebug)
+	req, _ := http.NewRequest("POST", "/function/figlet", nil)
+	req.Header.Set(RequestIDHeader, "gateway-1234")
+
+	// Act
+	seen, rr := serveWithRequestID(logger, req)
+
+	// Assert
+	assert.Equal("gateway-1234", seen)
+	assert.Equal("gateway-1234", rr.Header().Get(RequestIDHeader))
+	assert.Contains(output.String(), `msg=Handled requestId=gateway-1234`)
+	assert.Contains(output.String(), `msg="Served request" requestId=gateway-1234 method=POST path=/function/figlet status=202`)
+}
+
+func Test_MakeMiddleware_Generates_Request_ID(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	logger, _ := newTestLogger(FormatLogfmt, LevelInfo)
+	missing, _ := http.NewRequest("GET", "/system/functions", nil)
+	forged, _ := http.NewRequest("GET", "/system/functions", nil)
+	forged.Header.Set(RequestIDHeader, "1 level=error msg=forged")
+
+	// Act
+	missingID, missingRR := serveWithRequestID(logger, missing)
+	forgedID, _ := serveWithRequestID(logger, forged)
+
+	// Assert
+	assert.Len(missingID, 32)
+	assert.Equal(missingID, missingRR.Header().Get(RequestIDHeader))
+	assert.Equal(missingID, missing.Header.Get(RequestIDHeader), "the id is not set on the request")
+	assert.Len(forgedID, 32)
+	assert.NotEqual(missingID, forgedID)
+}
@@ -0,0 +1,209 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds the metrics of the provider and serves them in the Prometheus text format
+type Registry struct {
+	lock     sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	// value is evaluated on every scrape by gauges without labels
+	value func() float64
+
+	lock   sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	// fn computes the value on every scrape when set, see Gauge.SetFunc
+	fn func() float64
+}
+
+// Gauge is a metric which can go up and down, partitioned by label values
+type Gauge struct {
+	family *family
+}
+
+// Counter is a metric which only goes up, partitioned by label values
+type Counter struct {
+	family *family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name string, help string, kind string, labelNames []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			return f
+		}
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]*sample),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// NewGauge registers a gauge, registering the same name twice returns the same gauge
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{family: r.register(name, help, "gauge", labelNames)}
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is computed when the metrics are served
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	r.register(name, help, "gauge", nil).value = value
+}
+
+// NewCounter registers a counter, registering the same name twice returns the same counter
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{family: r.register(name, help, "counter", labelNames)}
+}
+
+func (f *family) sample(labelValues []string) *sample {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string{}, labelValues...)}
+		f.values[key] = s
+	}
+	return s
+}
+
+// Set sets the gauge for the label values, given in the order of the label names
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.lock.Lock()
+	defer g.family.lock.Unlock()
+
+	g.family.sample(labelValues).value = value
+}
+
+// SetFunc makes the gauge for the label values computed by value when the metrics are served
+func (g *Gauge) SetFunc(value func() float64, labelValues ...string) {
+	g.family.lock.Lock()
+	defer g.family.lock.Unlock()
+
+	g.family.sample(labelValues).fn = value
+}
+
+// Delete removes the gauge for the label values
+func (g *Gauge) Delete(labelValues ...string) {
+	g.family.lock.Lock()
+	defer g.family.lock.Unlock()
+
+	delete(g.family.values, strings.Join(labelValues, "\xff"))
+}
+
+// Add increases the counter for the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.family.lock.Lock()
+	defer c.family.lock.Unlock()
+
+	c.family.sample(labelValues).value += value
+}
+
+// Inc increases the counter for the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	families := append([]*family{}, r.families...)
+	r.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, f := range families {
+		f.write(buf)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.value != nil {
+		fmt.Fprintf(buf, "%s %s\n", f.name, strconv.FormatFloat(f.value(), 'g', -1, 64))
+		return
+	}
+
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.values[key]
+		buf.WriteString(f.name)
+		if len(f.labelNames) > 0 {
+			buf.WriteString("{")
+			for i, name := range f.labelNames {
+				if i > 0 {
+					buf.WriteString(",")
+				}
+				value := ""
+				if i < len(s.labelValues) {
+					value = s.labelValues[i]
+				}
+				fmt.Fprintf(buf, "%s=\"%s\"", name, escapeLabelValue(value))
+			}
+			buf.WriteString("}")
+		}
+		value := s.value
+		if s.fn != nil {
+			value = s.fn()
+		}
+		buf.WriteString(" ")
+		buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		buf.WriteString("\n")
+	}
+}
+
+func escapeHelp(help string) string {
+	help = strings.Replace(help, "\\", "\\\\", -1)
+	return strings.Replace(help, "\n", "\\n", -1)
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
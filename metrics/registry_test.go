@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry_Serves_Text_Format(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	registry := NewRegistry()
+	gauge := registry.NewGauge("some_gauge", "Some gauge", "function_name")
+	counter := registry.NewCounter("some_total", "Some counter")
+	registry.NewGaugeFunc("some_computed", "Some computed gauge", func() float64 { return 42 })
+	gauge.Set(1.5, "b")
+	gauge.SetFunc(func() float64 { return 7 }, "c")
+	gauge.Set(2, "a\"quoted\"")
+	gauge.Set(3, "deleted")
+	gauge.Delete("deleted")
+	counter.Inc()
+	counter.Add(2)
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	registry.ServeHTTP(rr, req)
+
+	// Assert
+	body, _ := ioutil.ReadAll(rr.Body)
+	expected := `# HELP some_gauge Some gauge
+# TYPE some_gauge gauge
+some_gauge{function_name="a\"quoted\""} 2
+some_gauge{function_name="b"} 1.5
+some_gauge{function_name="c"} 7
+# HELP some_total Some counter
+# TYPE some_total counter
+some_total 3
+# HELP some_computed Some computed gauge
+# TYPE some_computed gauge
+some_computed 42
+`
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(expected, string(body))
+}
+
+func Test_Registry_Same_Name_Returns_Same_Metric(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	registry := NewRegistry()
+
+	// Act
+	registry.NewCounter("some_total", "Some counter").Inc()
+	registry.NewCounter("some_total", "Some counter").Inc()
+
+	// Assert
+	assert.Equal(1, len(registry.families))
+	assert.Equal(float64(2), registry.families[0].values[""].value)
+}
@@ -2,6 +2,7 @@
 package mocks
 
 import client "github.com/rancher/go-rancher/v2"
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 
 // BridgeClient is an autogenerated mock type for the BridgeClient type
@@ -114,3 +115,399 @@ func (_m *BridgeClient) UpdateService(spec *client.Service, updates map[string]s
 
 	return r0, r1
 }
+
+// ListInstances provides a mock function with given fields: spec
+func (_m *BridgeClient) ListInstances(spec *client.Service) ([]client.Container, error) {
+	ret := _m.Called(spec)
+
+	var r0 []client.Container
+	if rf, ok := ret.Get(0).(func(*client.Service) []client.Container); ok {
+		r0 = rf(spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service) error); ok {
+		r1 = rf(spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerLogs provides a mock function with given fields: container, logs
+func (_m *BridgeClient) ContainerLogs(container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	ret := _m.Called(container, logs)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.ContainerLogs) *client.HostAccess); ok {
+		r0 = rf(container, logs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.ContainerLogs) error); ok {
+		r1 = rf(container, logs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerExec provides a mock function with given fields: container, exec
+func (_m *BridgeClient) ContainerExec(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	ret := _m.Called(container, exec)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(*client.Container, *client.ContainerExec) *client.HostAccess); ok {
+		r0 = rf(container, exec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container, *client.ContainerExec) error); ok {
+		r1 = rf(container, exec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerStats provides a mock function with given fields: container
+func (_m *BridgeClient) ContainerStats(container *client.Container) (*client.StatsAccess, error) {
+	ret := _m.Called(container)
+
+	var r0 *client.StatsAccess
+	if rf, ok := ret.Get(0).(func(*client.Container) *client.StatsAccess); ok {
+		r0 = rf(container)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.StatsAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Container) error); ok {
+		r1 = rf(container)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FunctionsStackID provides a mock function with given fields:
+func (_m *BridgeClient) FunctionsStackID() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// ListNamespaces provides a mock function with given fields:
+func (_m *BridgeClient) ListNamespaces() ([]string, error) {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListNamespacesWithContext provides a mock function with given fields: ctx
+func (_m *BridgeClient) ListNamespacesWithContext(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListServicesWithContext provides a mock function with given fields: ctx
+func (_m *BridgeClient) ListServicesWithContext(ctx context.Context) ([]client.Service, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []client.Service
+	if rf, ok := ret.Get(0).(func(context.Context) []client.Service); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindServiceByNameWithContext provides a mock function with given fields: ctx, name
+func (_m *BridgeClient) FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(context.Context, string) *client.Service); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateServiceWithContext provides a mock function with given fields: ctx, spec
+func (_m *BridgeClient) CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error) {
+	ret := _m.Called(ctx, spec)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Service) *client.Service); ok {
+		r0 = rf(ctx, spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Service) error); ok {
+		r1 = rf(ctx, spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteServiceWithContext provides a mock function with given fields: ctx, spec
+func (_m *BridgeClient) DeleteServiceWithContext(ctx context.Context, spec *client.Service) error {
+	ret := _m.Called(ctx, spec)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Service) error); ok {
+		r0 = rf(ctx, spec)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateServiceWithContext provides a mock function with given fields: ctx, spec, updates
+func (_m *BridgeClient) UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error) {
+	ret := _m.Called(ctx, spec, updates)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Service, map[string]string) *client.Service); ok {
+		r0 = rf(ctx, spec, updates)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Service, map[string]string) error); ok {
+		r1 = rf(ctx, spec, updates)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListInstancesWithContext provides a mock function with given fields: ctx, spec
+func (_m *BridgeClient) ListInstancesWithContext(ctx context.Context, spec *client.Service) ([]client.Container, error) {
+	ret := _m.Called(ctx, spec)
+
+	var r0 []client.Container
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Service) []client.Container); ok {
+		r0 = rf(ctx, spec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]client.Container)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Service) error); ok {
+		r1 = rf(ctx, spec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerLogsWithContext provides a mock function with given fields: ctx, container, logs
+func (_m *BridgeClient) ContainerLogsWithContext(ctx context.Context, container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	ret := _m.Called(ctx, container, logs)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Container, *client.ContainerLogs) *client.HostAccess); ok {
+		r0 = rf(ctx, container, logs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Container, *client.ContainerLogs) error); ok {
+		r1 = rf(ctx, container, logs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerExecWithContext provides a mock function with given fields: ctx, container, exec
+func (_m *BridgeClient) ContainerExecWithContext(ctx context.Context, container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	ret := _m.Called(ctx, container, exec)
+
+	var r0 *client.HostAccess
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Container, *client.ContainerExec) *client.HostAccess); ok {
+		r0 = rf(ctx, container, exec)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.HostAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Container, *client.ContainerExec) error); ok {
+		r1 = rf(ctx, container, exec)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ContainerStatsWithContext provides a mock function with given fields: ctx, container
+func (_m *BridgeClient) ContainerStatsWithContext(ctx context.Context, container *client.Container) (*client.StatsAccess, error) {
+	ret := _m.Called(ctx, container)
+
+	var r0 *client.StatsAccess
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Container) *client.StatsAccess); ok {
+		r0 = rf(ctx, container)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.StatsAccess)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Container) error); ok {
+		r1 = rf(ctx, container)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpgradeService provides a mock function with given fields: spec, launchConfig
+func (_m *BridgeClient) UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	ret := _m.Called(spec, launchConfig)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(*client.Service, *client.LaunchConfig) *client.Service); ok {
+		r0 = rf(spec, launchConfig)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*client.Service, *client.LaunchConfig) error); ok {
+		r1 = rf(spec, launchConfig)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpgradeServiceWithContext provides a mock function with given fields: ctx, spec, launchConfig
+func (_m *BridgeClient) UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	ret := _m.Called(ctx, spec, launchConfig)
+
+	var r0 *client.Service
+	if rf, ok := ret.Get(0).(func(context.Context, *client.Service, *client.LaunchConfig) *client.Service); ok {
+		r0 = rf(ctx, spec, launchConfig)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.Service)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *client.Service, *client.LaunchConfig) error); ok {
+		r1 = rf(ctx, spec, launchConfig)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,21 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import "context"
+
+// Background runs the work which outlives the request it was started by, such as finishing an
+// upgrade, so that it can be cancelled and waited for at shutdown
+type Background interface {
+	// Go runs work in a goroutine with a context which is cancelled at shutdown
+	Go(work func(ctx context.Context))
+}
+
+// Untracked runs work in goroutines which nothing cancels or waits for
+type Untracked struct{}
+
+// Go runs work with a context which is never cancelled
+func (Untracked) Go(work func(ctx context.Context)) {
+	go work(context.Background())
+}
@@ -0,0 +1,490 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/rancher/go-rancher/v2"
+)
+
+const (
+	subscribeMinBackoff = time.Second
+	subscribeMaxBackoff = time.Second * 30
+)
+
+// CachedClient serves the services of the functions stack from memory. The cache is kept up to
+// date by Rancher's resource.change events and a periodic full resync, reads go to the wrapped
+// client while the event subscription is down. Every other call is passed through.
+type CachedClient struct {
+	BridgeClient
+
+	subscribeURL string
+	header       http.Header
+	now          func() time.Time
+
+	lock sync.RWMutex
+	// services by name, and service names by id
+	services   map[string]client.Service
+	names      map[string]string
+	synced     bool
+	subscribed bool
+	lastResync time.Time
+	lastEvent  time.Time
+	// resyncing counts the resyncs listing services, the changes made meanwhile are kept in
+	// changes, numbered by sequence, so that they can be applied again over the older listing
+	resyncing int
+	sequence  uint64
+	changes   map[string]cacheChange
+
+	events      *metrics.Counter
+	resyncs     *metrics.Counter
+	corrections *metrics.Counter
+}
+
+// cacheChange is a service stored while a resync listed the services
+type cacheChange struct {
+	sequence uint64
+	service  client.Service
+}
+
+// subscriptionEvent is a message of Rancher's /subscribe websocket
+type subscriptionEvent struct {
+	Name         string `json:"name"`
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	Data         struct {
+		Resource json.RawMessage `json:"resource"`
+	} `json:"data"`
+}
+
+// NewCachedClient wraps the client with a cache of the functions stack, see Run
+func NewCachedClient(bridge BridgeClient, config *Config, registry *metrics.Registry) (*CachedClient, error) {
+	subscribeURL, err := makeSubscribeURL(config.CattleURL)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if len(config.CattleAccessKey) > 0 {
+		credentials := config.CattleAccessKey + ":" + config.CattleSecretKey
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	c := &CachedClient{
+		BridgeClient: bridge,
+		subscribeURL: subscribeURL,
+		header:       header,
+		now:          time.Now,
+		services:     make(map[string]client.Service),
+		names:        make(map[string]string),
+		changes:      make(map[string]cacheChange),
+		events:       registry.NewCounter("faas_rancher_cache_events_total", "Service change events applied to the cache."),
+		resyncs:      registry.NewCounter("faas_rancher_cache_resyncs_total", "Full resyncs of the cache by result.", "result"),
+		corrections:  registry.NewCounter("faas_rancher_cache_resync_corrections_total", "Services a resync found out of date, i.e. missed events."),
+	}
+
+	registry.NewGaugeFunc("faas_rancher_cache_subscribed", "1 while the cache receives Rancher's events.", func() float64 {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		if c.subscribed {
+			return 1
+		}
+		return 0
+	})
+	registry.NewGaugeFunc("faas_rancher_cache_seconds_since_resync", "Seconds since the last successful full resync.", func() float64 {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		return c.secondsSince(c.lastResync)
+	})
+	registry.NewGaugeFunc("faas_rancher_cache_seconds_since_event", "Seconds since the last service change event.", func() float64 {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		return c.secondsSince(c.lastEvent)
+	})
+	registry.NewGaugeFunc("faas_rancher_cache_services", "Services held by the cache.", func() float64 {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		return float64(len(c.services))
+	})
+
+	return c, nil
+}
+
+// makeSubscribeURL turns the Cattle API URL into the URL of its event websocket
+func makeSubscribeURL(cattleURL string) (string, error) {
+	u, err := url.Parse(cattleURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/subscribe"
+	u.RawQuery = url.Values{"eventNames": {"resource.change"}}.Encode()
+	return u.String(), nil
+}
+
+// secondsSince reports the age of t, -1 when it never happened
+func (c *CachedClient) secondsSince(t time.Time) float64 {
+	if t.IsZero() {
+		return -1
+	}
+	return c.now().Sub(t).Seconds()
+}
+
+// Run subscribes to Rancher's events and resyncs the cache every interval until stop is closed,
+// it returns once the subscription is closed
+func (c *CachedClient) Run(resyncInterval time.Duration, stop <-chan struct{}) {
+	unsubscribed := make(chan struct{})
+	go func() {
+		defer close(unsubscribed)
+		c.subscribe(stop)
+	}()
+	defer func() { <-unsubscribed }()
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Resync(); err != nil {
+				logging.Default().Error("Unable to resync the services cache", "error", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// subscribe keeps the event websocket open, reconnecting with a backoff
+func (c *CachedClient) subscribe(stop <-chan struct{}) {
+	backoff := subscribeMinBackoff
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(c.subscribeURL, c.header)
+		if err != nil {
+			logging.Default().Warn("Unable to subscribe to Rancher events", "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			backoff *= 2
+			if backoff > subscribeMaxBackoff {
+				backoff = subscribeMaxBackoff
+			}
+			continue
+		}
+		backoff = subscribeMinBackoff
+
+		// events were missed while disconnected
+		if err := c.Resync(); err != nil {
+			logging.Default().Error("Unable to resync the services cache", "error", err)
+		}
+		c.setSubscribed(true)
+		c.readEvents(conn, stop)
+		c.setSubscribed(false)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+func (c *CachedClient) setSubscribed(subscribed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.subscribed = subscribed
+}
+
+// readEvents applies the events read from the websocket until it is closed or stop is
+func (c *CachedClient) readEvents(conn *websocket.Conn, stop <-chan struct{}) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.handleEvent(data)
+	}
+}
+
+// handleEvent applies a service change event, other events such as pings are ignored
+func (c *CachedClient) handleEvent(data []byte) {
+	event := subscriptionEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+	if event.Name != "resource.change" || event.ResourceType != "service" {
+		return
+	}
+
+	service := client.Service{}
+	if err := json.Unmarshal(event.Data.Resource, &service); err != nil {
+		logging.Default().Warn("Unable to parse the service of an event", "resourceId", event.ResourceID, "error", err)
+		return
+	}
+
+	c.lock.Lock()
+	c.lastEvent = c.now()
+	c.change(service)
+	c.lock.Unlock()
+
+	c.events.Inc()
+}
+
+// store puts the service in the cache or drops it when it was removed or isn't a function stack service,
+// the lock must be held
+func (c *CachedClient) store(service client.Service) {
+	if name, ok := c.names[service.Id]; ok {
+		delete(c.services, name)
+		delete(c.names, service.Id)
+	}
+	if service.StackId != c.FunctionsStackID() || isRemoved(&service) {
+		return
+	}
+
+	c.services[service.Name] = service
+	c.names[service.Id] = service.Name
+}
+
+// change stores the service and, while a resync lists the services, keeps it to be applied again
+// over the listing, the lock must be held
+func (c *CachedClient) change(service client.Service) {
+	c.store(service)
+	if c.resyncing > 0 {
+		c.sequence++
+		c.changes[service.Id] = cacheChange{sequence: c.sequence, service: service}
+	}
+}
+
+func isRemoved(service *client.Service) bool {
+	switch service.State {
+	case "removed", "purging", "purged":
+		return true
+	}
+	return len(service.Removed) > 0
+}
+
+// Resync replaces the cache with a full listing of the functions stack. The events and writes
+// applied while the services were listed are newer than the listing, they are applied again.
+func (c *CachedClient) Resync() error {
+	c.lock.Lock()
+	c.resyncing++
+	started := c.sequence
+	c.lock.Unlock()
+
+	services, err := c.BridgeClient.ListServices()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.resyncing--
+	changes := c.changes
+	if c.resyncing == 0 {
+		c.changes = make(map[string]cacheChange)
+	}
+	if err != nil {
+		c.resyncs.Inc("failure")
+		return err
+	}
+
+	previous := c.services
+	wasSynced := c.synced
+
+	c.services = make(map[string]client.Service, len(services))
+	c.names = make(map[string]string, len(services))
+	for _, service := range services {
+		c.store(service)
+	}
+	for _, change := range changes {
+		if change.sequence > started {
+			c.store(change.service)
+		}
+	}
+	c.synced = true
+	c.lastResync = c.now()
+
+	corrections := 0
+	if wasSynced {
+		for name, service := range c.services {
+			if cached, ok := previous[name]; !ok || !reflect.DeepEqual(cached, service) {
+				corrections++
+			}
+		}
+		for name := range previous {
+			if _, ok := c.services[name]; !ok {
+				corrections++
+			}
+		}
+	}
+
+	c.resyncs.Inc("success")
+	c.corrections.Add(float64(corrections))
+	return nil
+}
+
+// fresh tells whether reads can be served from memory, the lock must be held
+func (c *CachedClient) fresh() bool {
+	return c.synced && c.subscribed
+}
+
+// ListServices lists the services of the functions stack, sorted by name
+func (c *CachedClient) ListServices() ([]client.Service, error) {
+	return c.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext lists the services of the functions stack, sorted by name. Only the
+// default namespace is cached, the others are listed from Cattle.
+func (c *CachedClient) ListServicesWithContext(ctx context.Context) ([]client.Service, error) {
+	if NamespaceFromContext(ctx) != DefaultNamespace {
+		return c.BridgeClient.ListServicesWithContext(ctx)
+	}
+
+	c.lock.RLock()
+	if !c.fresh() {
+		c.lock.RUnlock()
+		return c.BridgeClient.ListServicesWithContext(ctx)
+	}
+
+	services := make([]client.Service, 0, len(c.services))
+	for _, service := range c.services {
+		services = append(services, service)
+	}
+	c.lock.RUnlock()
+
+	sort.Sort(servicesByName(services))
+	return services, nil
+}
+
+// FindServiceByName looks the service up by name, nil is returned when there is none
+func (c *CachedClient) FindServiceByName(name string) (*client.Service, error) {
+	return c.FindServiceByNameWithContext(context.Background(), name)
+}
+
+// FindServiceByNameWithContext looks the service up by name, nil is returned when there is none
+func (c *CachedClient) FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error) {
+	if NamespaceFromContext(ctx) != DefaultNamespace {
+		return c.BridgeClient.FindServiceByNameWithContext(ctx, name)
+	}
+
+	c.lock.RLock()
+	if !c.fresh() {
+		c.lock.RUnlock()
+		return c.BridgeClient.FindServiceByNameWithContext(ctx, name)
+	}
+
+	service, ok := c.services[name]
+	c.lock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return &service, nil
+}
+
+// CreateService creates the service and caches it, so it can be read before its event arrives
+func (c *CachedClient) CreateService(spec *client.Service) (*client.Service, error) {
+	return c.CreateServiceWithContext(context.Background(), spec)
+}
+
+// CreateServiceWithContext creates the service and caches it, so it can be read before its event arrives
+func (c *CachedClient) CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error) {
+	service, err := c.BridgeClient.CreateServiceWithContext(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.change(*service)
+	c.lock.Unlock()
+	return service, nil
+}
+
+// UpdateService updates the service and caches the result
+func (c *CachedClient) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
+	return c.UpdateServiceWithContext(context.Background(), spec, updates)
+}
+
+// UpdateServiceWithContext updates the service and caches the result
+func (c *CachedClient) UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error) {
+	service, err := c.BridgeClient.UpdateServiceWithContext(ctx, spec, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.change(*service)
+	c.lock.Unlock()
+	return service, nil
+}
+
+// UpgradeService upgrades the service and caches the result
+func (c *CachedClient) UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	return c.UpgradeServiceWithContext(context.Background(), spec, launchConfig)
+}
+
+// UpgradeServiceWithContext upgrades the service and caches the result
+func (c *CachedClient) UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	service, err := c.BridgeClient.UpgradeServiceWithContext(ctx, spec, launchConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.change(*service)
+	c.lock.Unlock()
+	return service, nil
+}
+
+// DeleteService deletes the service and drops it from the cache
+func (c *CachedClient) DeleteService(spec *client.Service) error {
+	return c.DeleteServiceWithContext(context.Background(), spec)
+}
+
+// DeleteServiceWithContext deletes the service and drops it from the cache
+func (c *CachedClient) DeleteServiceWithContext(ctx context.Context, spec *client.Service) error {
+	if err := c.BridgeClient.DeleteServiceWithContext(ctx, spec); err != nil {
+		return err
+	}
+
+	removed := *spec
+	removed.State = "removed"
+	c.lock.Lock()
+	c.change(removed)
+	c.lock.Unlock()
+	return nil
+}
+
+type servicesByName []client.Service
+
+func (s servicesByName) Len() int           { return len(s) }
+func (s servicesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s servicesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
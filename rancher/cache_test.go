@@ -0,0 +1,289 @@
+package rancher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeCachedClient(t *testing.T, bridge BridgeClient, cattleURL string) *CachedClient {
+	cache, err := NewCachedClient(bridge, &Config{CattleURL: cattleURL, CattleAccessKey: "key", CattleSecretKey: "secret"}, metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func makeService(id string, name string, stackID string, scale int64) client.Service {
+	return client.Service{
+		Resource: client.Resource{Id: id},
+		Name:     name,
+		StackId:  stackID,
+		State:    "active",
+		Scale:    scale,
+	}
+}
+
+func Test_makeSubscribeURL(t *testing.T) {
+	assert := assert.New(t)
+
+	subscribeURL, err := makeSubscribeURL("https://rancher.local/v2-beta/projects/1a5/")
+
+	assert.Nil(err)
+	assert.Equal("wss://rancher.local/v2-beta/projects/1a5/subscribe?eventNames=resource.change", subscribeURL)
+}
+
+func Test_CachedClient_Reads_From_Memory_When_Fresh(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{
+		makeService("1s2", "b-function", "1st1", 1),
+		makeService("1s1", "a-function", "1st1", 1),
+		makeService("1s3", "other-stack", "1st2", 1),
+	}, nil).Once()
+	cache := makeCachedClient(t, mockClient, "http://rancher.local/v2-beta")
+	cache.Resync()
+	cache.setSubscribed(true)
+
+	// Act
+	services, listErr := cache.ListServices()
+	found, findErr := cache.FindServiceByName("b-function")
+	missing, missingErr := cache.FindServiceByName("other-stack")
+
+	// Assert
+	assert.Nil(listErr)
+	assert.Equal(2, len(services))
+	assert.Equal("a-function", services[0].Name)
+	assert.Equal("b-function", services[1].Name)
+	assert.Nil(findErr)
+	assert.Equal("1s2", found.Id)
+	assert.Nil(missingErr)
+	assert.Nil(missing)
+	mockClient.AssertNumberOfCalls(t, "ListServices", 1)
+}
+
+func Test_CachedClient_Reads_Through_While_Unsubscribed(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{makeService("1s1", "a-function", "1st1", 1)}, nil)
+	service := makeService("1s1", "a-function", "1st1", 2)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "a-function").Return(&service, nil)
+	cache := makeCachedClient(t, mockClient, "http://rancher.local/v2-beta")
+	cache.Resync()
+
+	// Act
+	found, err := cache.FindServiceByName("a-function")
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal(int64(2), found.Scale)
+	mockClient.AssertCalled(t, "FindServiceByNameWithContext", mock.Anything, "a-function")
+}
+
+func Test_CachedClient_Applies_Events(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{
+		makeService("1s1", "a-function", "1st1", 1),
+		makeService("1s2", "b-function", "1st1", 1),
+	}, nil)
+	cache := makeCachedClient(t, mockClient, "http://rancher.local/v2-beta")
+	cache.Resync()
+	cache.setSubscribed(true)
+
+	// Act
+	cache.handleEvent([]byte(`{"name":"ping"}`))
+	cache.handleEvent([]byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s1","data":{"resource":{"id":"1s1","name":"a-function","stackId":"1st1","state":"active","scale":3}}}`))
+	cache.handleEvent([]byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s2","data":{"resource":{"id":"1s2","name":"b-function","stackId":"1st1","state":"removed"}}}`))
+	cache.handleEvent([]byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s9","data":{"resource":{"id":"1s9","name":"c-function","stackId":"1st2","state":"active"}}}`))
+
+	// Assert
+	services, _ := cache.ListServices()
+	assert.Equal(1, len(services))
+	assert.Equal(int64(3), services[0].Scale)
+}
+
+func Test_CachedClient_Resync_Counts_Corrections(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{
+		makeService("1s1", "a-function", "1st1", 1),
+		makeService("1s2", "b-function", "1st1", 1),
+	}, nil).Once()
+	mockClient.On("ListServices").Return([]client.Service{
+		makeService("1s1", "a-function", "1st1", 1),
+		makeService("1s2", "b-function", "1st1", 4),
+		makeService("1s3", "c-function", "1st1", 1),
+	}, nil).Once()
+	registry := metrics.NewRegistry()
+	cache, _ := NewCachedClient(mockClient, &Config{CattleURL: "http://rancher.local/v2-beta"}, registry)
+
+	// Act
+	firstErr := cache.Resync()
+	secondErr := cache.Resync()
+
+	// Assert
+	assert.Nil(firstErr)
+	assert.Nil(secondErr)
+	rr := httptest.NewRecorder()
+	registry.ServeHTTP(rr, nil)
+	assert.Contains(rr.Body.String(), "faas_rancher_cache_resync_corrections_total 2\n")
+	assert.Contains(rr.Body.String(), "faas_rancher_cache_services 3\n")
+	assert.Contains(rr.Body.String(), "faas_rancher_cache_resyncs_total{result=\"success\"} 2\n")
+}
+
+func Test_CachedClient_Resync_Keeps_Events_Applied_While_Listing(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	cache := makeCachedClient(t, mockClient, "http://rancher.local/v2-beta")
+	mockClient.On("ListServices").Return([]client.Service{
+		makeService("1s1", "a-function", "1st1", 1),
+		makeService("1s2", "b-function", "1st1", 1),
+	}, nil).Run(func(args mock.Arguments) {
+		// the listing was taken before these events
+		cache.handleEvent([]byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s1","data":{"resource":{"id":"1s1","name":"a-function","stackId":"1st1","state":"active","scale":7}}}`))
+		cache.handleEvent([]byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s2","data":{"resource":{"id":"1s2","name":"b-function","stackId":"1st1","state":"removed"}}}`))
+	})
+
+	// Act
+	err := cache.Resync()
+
+	// Assert
+	assert.Nil(err)
+	cache.setSubscribed(true)
+	services, _ := cache.ListServices()
+	if assert.Equal(1, len(services)) {
+		assert.Equal(int64(7), services[0].Scale)
+	}
+	assert.Empty(cache.changes)
+}
+
+func Test_CachedClient_Run_Returns_Once_Unsubscribed(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{}, nil)
+	cache := makeCachedClient(t, mockClient, server.URL+"/v2-beta")
+	stop := make(chan struct{})
+	ran := make(chan struct{})
+	go func() {
+		cache.Run(time.Hour, stop)
+		close(ran)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		cache.lock.RLock()
+		subscribed := cache.subscribed
+		cache.lock.RUnlock()
+		if subscribed {
+			break
+		}
+	}
+
+	// Act
+	close(stop)
+	<-ran
+
+	// Assert
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	assert.False(cache.subscribed, "Run returned while still subscribed")
+}
+
+func Test_CachedClient_Writes_Through(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{makeService("1s1", "a-function", "1st1", 1)}, nil)
+	created := makeService("1s2", "b-function", "1st1", 1)
+	mockClient.On("CreateServiceWithContext", mock.Anything, &client.Service{Name: "b-function"}).Return(&created, nil)
+	existing := makeService("1s1", "a-function", "1st1", 1)
+	mockClient.On("DeleteServiceWithContext", mock.Anything, &existing).Return(nil)
+	cache := makeCachedClient(t, mockClient, "http://rancher.local/v2-beta")
+	cache.Resync()
+	cache.setSubscribed(true)
+
+	// Act
+	_, createErr := cache.CreateService(&client.Service{Name: "b-function"})
+	deleteErr := cache.DeleteService(&existing)
+
+	// Assert
+	assert.Nil(createErr)
+	assert.Nil(deleteErr)
+	services, _ := cache.ListServices()
+	assert.Equal(1, len(services))
+	assert.Equal("b-function", services[0].Name)
+}
+
+func Test_CachedClient_Subscribes_To_Events(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	authorizations := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"resource.change","resourceType":"service","resourceId":"1s1","data":{"resource":{"id":"1s1","name":"a-function","stackId":"1st1","state":"active","scale":5}}}`))
+		// keep the subscription open until the client goes away
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FunctionsStackID").Return("1st1")
+	mockClient.On("ListServices").Return([]client.Service{makeService("1s1", "a-function", "1st1", 1)}, nil)
+	// read through until subscribed
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "a-function").Return(nil, nil)
+	cache := makeCachedClient(t, mockClient, server.URL+"/v2-beta")
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Act
+	go cache.Run(time.Hour, stop)
+
+	// Assert
+	assert.Equal("Basic a2V5OnNlY3JldA==", <-authorizations)
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		service, _ := cache.FindServiceByName("a-function")
+		if service != nil && service.Scale == 5 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("the event was not applied")
+}
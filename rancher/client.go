@@ -4,25 +4,61 @@
 package rancher
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/kenfdev/faas-rancher/logging"
 	"github.com/rancher/go-rancher/v2"
 )
 
-// BridgeClient is the interface for Rancher API
+// BridgeClient is the interface for Rancher API. The WithContext variants cancel their requests
+// to Cattle along with the context, the others are only bounded by the client timeout. They act
+// on the stack of the context's namespace, see WithNamespace, the others on the functions stack.
 type BridgeClient interface {
+	FunctionsStackID() string
+	ListNamespaces() ([]string, error)
+	ListNamespacesWithContext(ctx context.Context) ([]string, error)
 	ListServices() ([]client.Service, error)
+	ListServicesWithContext(ctx context.Context) ([]client.Service, error)
 	FindServiceByName(name string) (*client.Service, error)
+	FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error)
 	CreateService(spec *client.Service) (*client.Service, error)
+	CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error)
 	DeleteService(spec *client.Service) error
+	DeleteServiceWithContext(ctx context.Context, spec *client.Service) error
 	UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error)
+	UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error)
+	UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error)
+	UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error)
+	ListInstances(spec *client.Service) ([]client.Container, error)
+	ListInstancesWithContext(ctx context.Context, spec *client.Service) ([]client.Container, error)
+	ContainerLogs(container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error)
+	ContainerLogsWithContext(ctx context.Context, container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error)
+	ContainerExec(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error)
+	ContainerExecWithContext(ctx context.Context, container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error)
+	ContainerStats(container *client.Container) (*client.StatsAccess, error)
+	ContainerStatsWithContext(ctx context.Context, container *client.Container) (*client.StatsAccess, error)
 }
 
 // Client is the REST client type
 type Client struct {
 	rancherClient    *client.RancherClient
+	httpClient       *http.Client
 	config           *Config
 	functionsStackID string
+
+	// stackIDs are the ids of the stacks backing the namespaces
+	stackIDs        map[string]stackIDEntry
+	stacksLock      sync.Mutex
+	createStackLock sync.Mutex
+
+	// upgrades are the ids of the services whose upgrade is being finished
+	upgrades     map[string]bool
+	upgradesLock sync.Mutex
 }
 
 // NewClientForConfig creates a new rancher REST client
@@ -49,7 +85,7 @@ func NewClientForConfig(config *Config) (BridgeClient, error) {
 
 	var stack *client.Stack
 	if len(coll.Data) == 0 {
-		fmt.Println("stack named " + config.FunctionsStackName + " not found. creating...")
+		logging.Default().Info("Functions stack not found, creating it", "stack", config.FunctionsStackName)
 		// create stack if not present
 		reqStack := &client.Stack{
 			Name: config.FunctionsStackName,
@@ -58,7 +94,7 @@ func NewClientForConfig(config *Config) (BridgeClient, error) {
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println("stack creation complete")
+		logging.Default().Info("Created the functions stack", "stack", config.FunctionsStackName)
 		stack = newStack
 	} else {
 		stack = &coll.Data[0]
@@ -66,69 +102,384 @@ func NewClientForConfig(config *Config) (BridgeClient, error) {
 
 	client := Client{
 		rancherClient:    c,
+		httpClient:       &http.Client{Timeout: c.GetOpts().Timeout},
 		config:           config,
 		functionsStackID: stack.Id,
+		stackIDs:         make(map[string]stackIDEntry),
+		upgrades:         make(map[string]bool),
 	}
 
 	return &client, nil
 
 }
 
+// FunctionsStackID is the id of the stack the functions are deployed into
+func (c *Client) FunctionsStackID() string {
+	return c.functionsStackID
+}
+
 // ListServices lists rancher services inside the specified stack (set in config)
 func (c *Client) ListServices() ([]client.Service, error) {
-	services, err := c.rancherClient.Service.List(&client.ListOpts{
-		Filters: map[string]interface{}{
-			"stackId": c.functionsStackID,
-		},
+	return c.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext lists rancher services inside the stack of the context's namespace
+func (c *Client) ListServicesWithContext(ctx context.Context) ([]client.Service, error) {
+	stackID, err := c.stackID(ctx, false)
+	if err != nil || len(stackID) == 0 {
+		return []client.Service{}, err
+	}
+
+	return c.listServices(ctx, url.Values{
+		"stackId": {stackID},
+	})
+}
+
+// FindServiceByName finds a service of the functions stack based on its name,
+// nil is returned when there is none
+func (c *Client) FindServiceByName(name string) (*client.Service, error) {
+	return c.FindServiceByNameWithContext(context.Background(), name)
+}
+
+// FindServiceByNameWithContext finds a service of the stack of the context's namespace based on
+// its name, nil is returned when there is none
+func (c *Client) FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error) {
+	stackID, err := c.stackID(ctx, false)
+	if err != nil || len(stackID) == 0 {
+		return nil, err
+	}
+
+	services, err := c.listServices(ctx, url.Values{
+		"name":    {name},
+		"stackId": {stackID},
 	})
 	if err != nil {
 		return nil, err
 	}
-	return services.Data, nil
+	if len(services) == 0 {
+		return nil, nil
+	}
+	return &services[0], nil
 }
 
-// FindServiceByName finds a service based on its name
-func (c *Client) FindServiceByName(name string) (*client.Service, error) {
-	services, err := c.rancherClient.Service.List(&client.ListOpts{
-		Filters: map[string]interface{}{
-			"name": name,
-		},
-	})
+func (c *Client) listServices(ctx context.Context, filters url.Values) ([]client.Service, error) {
+	collectionURL, err := c.collectionURL("service", filters)
 	if err != nil {
 		return nil, err
 	}
-	if len(services.Data) == 0 {
-		return nil, fmt.Errorf("No service named " + name + " found.")
+
+	services := &client.ServiceCollection{}
+	if err := c.do(ctx, "GET", collectionURL, nil, services); err != nil {
+		return nil, err
 	}
-	return &services.Data[0], nil
+	return services.Data, nil
 }
 
 // CreateService creates a service inside rancher
 func (c *Client) CreateService(spec *client.Service) (*client.Service, error) {
+	return c.CreateServiceWithContext(context.Background(), spec)
+}
 
-	spec.StackId = c.functionsStackID
-	service, err := c.rancherClient.Service.Create(spec)
+// CreateServiceWithContext creates a service inside rancher
+func (c *Client) CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error) {
+	collectionURL, err := c.collectionURL("service", nil)
 	if err != nil {
 		return nil, err
 	}
+
+	if err := c.resolveSecrets(ctx, spec.LaunchConfig); err != nil {
+		return nil, err
+	}
+
+	stackID, err := c.stackID(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.StackId = stackID
+	service := &client.Service{}
+	if err := c.do(ctx, "POST", collectionURL, spec, service); err != nil {
+		if apiErr, ok := err.(*client.ApiError); ok && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnprocessableEntity) {
+			// the stack may have been removed since its id was looked up
+			c.forgetStackID(ctx)
+		}
+		return nil, err
+	}
 	return service, nil
 }
 
+// resolveSecrets looks up the ids of the secrets the launch config references by name only
+func (c *Client) resolveSecrets(ctx context.Context, launchConfig *client.LaunchConfig) error {
+	if launchConfig == nil {
+		return nil
+	}
+
+	for i := range launchConfig.Secrets {
+		secret := &launchConfig.Secrets[i]
+		if len(secret.SecretId) > 0 {
+			continue
+		}
+
+		collectionURL, err := c.collectionURL("secret", url.Values{"name": []string{secret.Name}})
+		if err != nil {
+			return err
+		}
+		secrets := &client.SecretCollection{}
+		if err := c.do(ctx, "GET", collectionURL, nil, secrets); err != nil {
+			return err
+		}
+		if len(secrets.Data) == 0 {
+			return fmt.Errorf("secret %s not found", secret.Name)
+		}
+		secret.SecretId = secrets.Data[0].Id
+	}
+	return nil
+}
+
 // DeleteService deletes the specified service in rancher
 func (c *Client) DeleteService(spec *client.Service) error {
-	err := c.rancherClient.Service.Delete(spec)
+	return c.DeleteServiceWithContext(context.Background(), spec)
+}
+
+// DeleteServiceWithContext deletes the specified service in rancher
+func (c *Client) DeleteServiceWithContext(ctx context.Context, spec *client.Service) error {
+	selfURL, err := resourceLink(&spec.Resource, "self")
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return c.do(ctx, "DELETE", selfURL, nil, nil)
 }
 
 // UpdateService upgrades the specified service in rancher
 func (c *Client) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
-	service, err := c.rancherClient.Service.Update(spec, updates)
+	return c.UpdateServiceWithContext(context.Background(), spec, updates)
+}
+
+// UpdateServiceWithContext upgrades the specified service in rancher
+func (c *Client) UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error) {
+	selfURL, err := resourceLink(&spec.Resource, "self")
 	if err != nil {
 		return nil, err
 	}
+
+	service := &client.Service{}
+	if err := c.do(ctx, "PUT", selfURL, updates, service); err != nil {
+		return nil, err
+	}
 	return service, nil
 }
+
+// upgradePollInterval is how often the state of an upgrading service is checked
+var upgradePollInterval = time.Second
+
+// upgradeTimeout bounds how long an upgrade is waited for before it is left unfinished, the next
+// upgrade of the service finishes it
+var upgradeTimeout = time.Minute * 30
+
+// UpgradeInProgressError is returned when a service can't be upgraded as it is being upgraded
+type UpgradeInProgressError struct {
+	Name string
+}
+
+func (e *UpgradeInProgressError) Error() string {
+	return fmt.Sprintf("an upgrade of %s is in progress, retry once it finished", e.Name)
+}
+
+// UpgradeInProgress tells whether err was returned because the service is being upgraded
+func UpgradeInProgress(err error) bool {
+	_, ok := err.(*UpgradeInProgressError)
+	return ok
+}
+
+// UpgradeService replaces the launch config of the specified service, see UpgradeServiceWithContext
+func (c *Client) UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	return c.UpgradeServiceWithContext(context.Background(), spec, launchConfig)
+}
+
+// UpgradeServiceWithContext starts replacing the launch config of the specified service and returns
+// the upgrading service. Its containers are replaced one by one, starting the new one first, and the
+// upgrade is finished in the background once they all are. An upgrade left unfinished before is
+// finished first, a service still upgrading is an UpgradeInProgressError.
+func (c *Client) UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	service := spec
+	switch service.State {
+	case "upgraded":
+		finished, err := c.finishUpgrade(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		service = finished
+	case "upgrading":
+		// its upgrade may have been started by a provider which went away before finishing it
+		c.finishInBackground(ctx, service)
+		return nil, &UpgradeInProgressError{Name: service.Name}
+	}
+
+	upgradeURL, err := resourceAction(&service.Resource, "upgrade")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.resolveSecrets(ctx, launchConfig); err != nil {
+		return nil, err
+	}
+
+	upgrade := &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			LaunchConfig:   launchConfig,
+			BatchSize:      1,
+			IntervalMillis: 2000,
+			StartFirst:     true,
+		},
+	}
+	upgrading := &client.Service{}
+	if err := c.do(ctx, "POST", upgradeURL, upgrade, upgrading); err != nil {
+		return nil, err
+	}
+
+	c.finishInBackground(ctx, upgrading)
+	return upgrading, nil
+}
+
+// finishInBackground finishes the upgrade of the service once its containers are replaced, unless
+// it is already being waited for. It outlives ctx, whose logger it keeps, and is cancelled at shutdown.
+func (c *Client) finishInBackground(ctx context.Context, service *client.Service) {
+	c.upgradesLock.Lock()
+	defer c.upgradesLock.Unlock()
+	if c.upgrades[service.Id] {
+		return
+	}
+	c.upgrades[service.Id] = true
+
+	logger := logging.FromContext(ctx)
+	c.background().Go(func(background context.Context) {
+		defer func() {
+			c.upgradesLock.Lock()
+			delete(c.upgrades, service.Id)
+			c.upgradesLock.Unlock()
+		}()
+
+		finishCtx, cancel := context.WithTimeout(logging.WithLogger(background, logger), upgradeTimeout)
+		defer cancel()
+		if _, err := c.finishUpgrade(finishCtx, service); err != nil {
+			logger.Error("Unable to finish the upgrade", "service", service.Name, "error", err)
+			return
+		}
+		logger.Info("Finished the upgrade", "service", service.Name)
+	})
+}
+
+func (c *Client) background() Background {
+	if c.config.Background == nil {
+		return Untracked{}
+	}
+	return c.config.Background
+}
+
+// finishUpgrade waits for the service to be upgraded and finishes the upgrade
+func (c *Client) finishUpgrade(ctx context.Context, service *client.Service) (*client.Service, error) {
+	selfURL, err := resourceLink(&service.Resource, "self")
+	if err != nil {
+		return nil, err
+	}
+
+	for service.State != "upgraded" {
+		if service.State != "upgrading" {
+			return nil, fmt.Errorf("upgrade of %s stopped in state %s", service.Name, service.State)
+		}
+
+		select {
+		case <-time.After(upgradePollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		reloaded := &client.Service{}
+		if err := c.do(ctx, "GET", selfURL, nil, reloaded); err != nil {
+			return nil, err
+		}
+		service = reloaded
+	}
+
+	finishURL, err := resourceAction(&service.Resource, "finishupgrade")
+	if err != nil {
+		return nil, err
+	}
+
+	finished := &client.Service{}
+	if err := c.do(ctx, "POST", finishURL, nil, finished); err != nil {
+		return nil, err
+	}
+	return finished, nil
+}
+
+// ListInstances lists the containers of the specified service
+func (c *Client) ListInstances(spec *client.Service) ([]client.Container, error) {
+	return c.ListInstancesWithContext(context.Background(), spec)
+}
+
+// ListInstancesWithContext lists the containers of the specified service
+func (c *Client) ListInstancesWithContext(ctx context.Context, spec *client.Service) ([]client.Container, error) {
+	instancesURL, err := resourceLink(&spec.Resource, "instances")
+	if err != nil {
+		return nil, err
+	}
+
+	containers := &client.ContainerCollection{}
+	if err := c.do(ctx, "GET", instancesURL, nil, containers); err != nil {
+		return nil, err
+	}
+	return containers.Data, nil
+}
+
+// ContainerLogs requests access to the logs of the specified container, see DialHostAccess
+func (c *Client) ContainerLogs(container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	return c.ContainerLogsWithContext(context.Background(), container, logs)
+}
+
+// ContainerLogsWithContext requests access to the logs of the specified container, see DialHostAccess
+func (c *Client) ContainerLogsWithContext(ctx context.Context, container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	return c.hostAccess(ctx, container, "logs", logs)
+}
+
+// ContainerExec starts a command inside the specified container, see DialHostAccess
+func (c *Client) ContainerExec(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	return c.ContainerExecWithContext(context.Background(), container, exec)
+}
+
+// ContainerExecWithContext starts a command inside the specified container, see DialHostAccess
+func (c *Client) ContainerExecWithContext(ctx context.Context, container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	return c.hostAccess(ctx, container, "execute", exec)
+}
+
+func (c *Client) hostAccess(ctx context.Context, container *client.Container, action string, input interface{}) (*client.HostAccess, error) {
+	actionURL, err := resourceAction(&container.Resource, action)
+	if err != nil {
+		return nil, err
+	}
+
+	access := &client.HostAccess{}
+	if err := c.do(ctx, "POST", actionURL, input, access); err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+// ContainerStats requests access to the resource usage of the specified container, see DialStatsAccess
+func (c *Client) ContainerStats(container *client.Container) (*client.StatsAccess, error) {
+	return c.ContainerStatsWithContext(context.Background(), container)
+}
+
+// ContainerStatsWithContext requests access to the resource usage of the specified container, see DialStatsAccess
+func (c *Client) ContainerStatsWithContext(ctx context.Context, container *client.Container) (*client.StatsAccess, error) {
+	statsURL, err := resourceLink(&container.Resource, "containerStats")
+	if err != nil {
+		return nil, err
+	}
+
+	access := &client.StatsAccess{}
+	if err := c.do(ctx, "GET", statsURL, nil, access); err != nil {
+		return nil, err
+	}
+	return access, nil
+}
@@ -0,0 +1,381 @@
+package rancher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// makeFakeCattle serves just enough of the Cattle API to create a client, to find the secret
+// api-key and to create stacks, requests below /v2-beta/services go to the services handler
+func makeFakeCattle(services http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/v2-beta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-API-Schemas", server.URL+"/v2-beta/schemas")
+		writeJSON(w, map[string]string{})
+	})
+	mux.HandleFunc("/v2-beta/schemas", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"id": "stack", "links": map[string]string{"collection": server.URL + "/v2-beta/stacks"}, "collectionMethods": []string{"GET", "POST"}},
+				{"id": "service", "links": map[string]string{"collection": server.URL + "/v2-beta/services"}, "collectionMethods": []string{"GET", "POST"}},
+				{"id": "secret", "links": map[string]string{"collection": server.URL + "/v2-beta/secrets"}, "collectionMethods": []string{"GET"}},
+			},
+		})
+	})
+	stacksLock := sync.Mutex{}
+	stacks := []map[string]interface{}{{"id": "1st1", "name": "faas-functions"}}
+	mux.HandleFunc("/v2-beta/stacks", func(w http.ResponseWriter, r *http.Request) {
+		stacksLock.Lock()
+		defer stacksLock.Unlock()
+
+		if r.Method == "POST" {
+			stack := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&stack)
+			stack = map[string]interface{}{"id": fmt.Sprintf("1st%d", len(stacks)+1), "name": stack["name"]}
+			stacks = append(stacks, stack)
+			writeJSON(w, stack)
+			return
+		}
+
+		found := []map[string]interface{}{}
+		name, prefix := r.URL.Query().Get("name"), r.URL.Query().Get("name_prefix")
+		for _, stack := range stacks {
+			stackName, _ := stack["name"].(string)
+			if (len(name) == 0 || stackName == name) && strings.HasPrefix(stackName, prefix) {
+				found = append(found, stack)
+			}
+		}
+		writeJSON(w, map[string]interface{}{"data": found})
+	})
+	mux.HandleFunc("/v2-beta/secrets", func(w http.ResponseWriter, r *http.Request) {
+		secrets := []map[string]string{}
+		if name := r.URL.Query().Get("name"); name == "api-key" {
+			secrets = append(secrets, map[string]string{"id": "1se1", "name": name})
+		}
+		writeJSON(w, map[string]interface{}{"data": secrets})
+	})
+	mux.HandleFunc("/v2-beta/services", services)
+	mux.HandleFunc("/v2-beta/services/", services)
+
+	return server
+}
+
+// makeSlowCattle blocks the requests for services until they are cancelled, which is reported on cancelled
+func makeSlowCattle(t *testing.T, cancelled chan<- string) *httptest.Server {
+	return makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.URL.RawQuery
+		case <-time.After(time.Second * 10):
+			t.Error("the request to Cattle was not cancelled")
+		}
+	})
+}
+
+func makeFakeCattleClient(t *testing.T, server *httptest.Server) BridgeClient {
+	config, _ := NewClientConfig("faas-functions", server.URL+"/v2-beta", "key", "secret")
+	bridge, err := NewClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bridge
+}
+
+func Test_ListServicesWithContext_Cancels_Request(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	cancelled := make(chan string, 1)
+	server := makeSlowCattle(t, cancelled)
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	// Act
+	started := time.Now()
+	services, err := bridge.ListServicesWithContext(ctx)
+
+	// Assert
+	assert.Nil(services)
+	assert.Equal(context.Canceled, err)
+	assert.True(time.Since(started) < time.Second*5)
+	select {
+	case query := <-cancelled:
+		assert.Equal("stackId=1st1", query)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Cattle didn't see the cancellation")
+	}
+}
+
+func Test_FindServiceByNameWithContext_Honours_Deadline(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	cancelled := make(chan string, 1)
+	server := makeSlowCattle(t, cancelled)
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	// Act
+	service, err := bridge.FindServiceByNameWithContext(ctx, "some-function")
+
+	// Assert
+	assert.Nil(service)
+	assert.Equal(context.DeadlineExceeded, err)
+	select {
+	case query := <-cancelled:
+		assert.Equal("name=some-function&stackId=1st1", query)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Cattle didn't see the cancellation")
+	}
+}
+
+func Test_Client_Service_Lifecycle(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	requests := []string{}
+	var updates map[string]interface{}
+	var server *httptest.Server
+	server = makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		switch r.Method {
+		case "POST":
+			writeJSON(w, map[string]interface{}{
+				"id":      "1s1",
+				"name":    body["name"],
+				"stackId": body["stackId"],
+				"links":   map[string]string{"self": server.URL + "/v2-beta/services/1s1"},
+			})
+		case "PUT":
+			updates = body
+			writeJSON(w, map[string]interface{}{"id": "1s1", "scale": 3})
+		case "DELETE":
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]string{"code": "NotFound"})
+		}
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	// Act
+	created, createErr := bridge.CreateService(&client.Service{Name: "some-function"})
+	updated, updateErr := bridge.UpdateService(created, map[string]string{"scale": "3"})
+	deleteErr := bridge.DeleteService(created)
+
+	// Assert
+	assert.Nil(createErr)
+	assert.Equal("some-function", created.Name)
+	assert.Equal("1st1", created.StackId)
+	assert.Nil(updateErr)
+	assert.Equal("3", updates["scale"])
+	assert.Equal(int64(3), updated.Scale)
+	assert.True(client.IsNotFound(deleteErr))
+	assert.Equal([]string{"POST /v2-beta/services", "PUT /v2-beta/services/1s1", "DELETE /v2-beta/services/1s1"}, requests)
+}
+
+// fakeUpgradeCattle serves a service which stays upgrading for one poll before being upgraded,
+// the calls it receives are sent to calls
+func fakeUpgradeCattle(calls chan<- string, upgrade *map[string]interface{}) (*httptest.Server, func(state string) map[string]interface{}) {
+	var server *httptest.Server
+	polls := 0
+	service := func(state string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":    "1s1",
+			"name":  "some-function",
+			"state": state,
+			"links": map[string]string{"self": server.URL + "/v2-beta/services/1s1"},
+			"actions": map[string]string{
+				"upgrade":       server.URL + "/v2-beta/services/1s1?action=upgrade",
+				"finishupgrade": server.URL + "/v2-beta/services/1s1?action=finishupgrade",
+			},
+		}
+	}
+	server = makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		action := r.URL.Query().Get("action")
+		calls <- r.Method + " " + action
+		switch {
+		case action == "upgrade":
+			json.NewDecoder(r.Body).Decode(upgrade)
+			writeJSON(w, service("upgrading"))
+		case action == "finishupgrade":
+			writeJSON(w, service("finishing-upgrade"))
+		case polls == 0:
+			polls++
+			writeJSON(w, service("upgrading"))
+		default:
+			writeJSON(w, service("upgraded"))
+		}
+	})
+	return server, service
+}
+
+// receiveCalls collects the calls until the finishupgrade one, or fails after a second
+func receiveCalls(t *testing.T, calls <-chan string) []string {
+	received := []string{}
+	for {
+		select {
+		case call := <-calls:
+			received = append(received, call)
+			if call == "POST finishupgrade" {
+				return received
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the upgrade wasn't finished, calls: %v", received)
+		}
+	}
+}
+
+func Test_Client_UpgradeService_Finishes_Upgrade_In_Background(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	upgradePollInterval = time.Millisecond
+	calls := make(chan string, 10)
+	var upgrade map[string]interface{}
+	server, service := fakeUpgradeCattle(calls, &upgrade)
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	existing := &client.Service{}
+	json.Unmarshal(mustJSON(t, service("active")), existing)
+	launchConfig := &client.LaunchConfig{ImageUuid: "docker:some/image"}
+
+	// Act
+	upgrading, err := bridge.UpgradeService(existing, launchConfig)
+	received := receiveCalls(t, calls)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("upgrading", upgrading.State)
+	assert.Equal([]string{"POST upgrade", "GET ", "GET ", "POST finishupgrade"}, received)
+	strategy := upgrade["inServiceStrategy"].(map[string]interface{})
+	assert.Equal("docker:some/image", strategy["launchConfig"].(map[string]interface{})["imageUuid"])
+	assert.Equal(true, strategy["startFirst"])
+}
+
+// cancelledBackground runs work with a cancelled context and reports when it returned
+type cancelledBackground struct {
+	returned chan bool
+}
+
+func (b cancelledBackground) Go(work func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go func() {
+		work(ctx)
+		b.returned <- true
+	}()
+}
+
+func Test_Client_UpgradeService_Finishes_Upgrade_With_Background(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	upgradePollInterval = time.Millisecond
+	calls := make(chan string, 10)
+	var upgrade map[string]interface{}
+	server, service := fakeUpgradeCattle(calls, &upgrade)
+	defer server.Close()
+	config, _ := NewClientConfig("faas-functions", server.URL+"/v2-beta", "key", "secret")
+	background := cancelledBackground{returned: make(chan bool, 1)}
+	config.Background = background
+	bridge, err := NewClientForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := &client.Service{}
+	json.Unmarshal(mustJSON(t, service("active")), existing)
+
+	// Act
+	_, err = bridge.UpgradeService(existing, &client.LaunchConfig{ImageUuid: "docker:some/image"})
+	<-background.returned
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("POST upgrade", <-calls)
+	assert.Empty(calls, "the upgrade was finished after the shutdown")
+}
+
+func Test_Client_UpgradeService_Upgrading_Is_In_Progress(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	upgradePollInterval = time.Millisecond
+	calls := make(chan string, 10)
+	var upgrade map[string]interface{}
+	server, service := fakeUpgradeCattle(calls, &upgrade)
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	existing := &client.Service{}
+	json.Unmarshal(mustJSON(t, service("upgrading")), existing)
+
+	// Act
+	_, err := bridge.UpgradeService(existing, &client.LaunchConfig{ImageUuid: "docker:some/image"})
+	received := receiveCalls(t, calls)
+
+	// Assert
+	assert.True(UpgradeInProgress(err))
+	assert.Equal([]string{"GET ", "GET ", "POST finishupgrade"}, received)
+	assert.Nil(upgrade, "a second upgrade was started")
+}
+
+func mustJSON(t *testing.T, value interface{}) []byte {
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func Test_Client_CreateService_Resolves_Secrets(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	var created client.Service
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&created)
+		writeJSON(w, created)
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	spec := &client.Service{
+		Name:         "some-function",
+		LaunchConfig: &client.LaunchConfig{Secrets: []client.SecretReference{{Name: "api-key"}}},
+	}
+	missing := &client.Service{
+		Name:         "other-function",
+		LaunchConfig: &client.LaunchConfig{Secrets: []client.SecretReference{{Name: "unknown"}}},
+	}
+
+	// Act
+	_, err := bridge.CreateService(spec)
+	_, missingErr := bridge.CreateService(missing)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("1se1", created.LaunchConfig.Secrets[0].SecretId)
+	assert.Equal("api-key", created.LaunchConfig.Secrets[0].Name)
+	assert.EqualError(missingErr, "secret unknown not found")
+}
@@ -13,6 +13,8 @@ type Config struct {
 	CattleAccessKey string
 	// cattle secret key
 	CattleSecretKey string
+	// Background finishes the upgrades, they are Untracked when nil
+	Background Background
 }
 
 // NewClientConfig creates a new config for rancher REST client
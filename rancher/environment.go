@@ -0,0 +1,271 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+// Environment is a Rancher environment the provider manages functions in
+type Environment struct {
+	Name               string
+	FunctionsStackName string
+	// Namespaces are routed to this environment when no environment is named
+	Namespaces []string
+}
+
+type environmentKey struct{}
+
+// WithEnvironment returns a context in which the calls of an EnvironmentRouter go to the environment
+func WithEnvironment(ctx context.Context, environment Environment) context.Context {
+	return context.WithValue(ctx, environmentKey{}, environment)
+}
+
+// EnvironmentFromContext is the environment set by WithEnvironment
+func EnvironmentFromContext(ctx context.Context) (Environment, bool) {
+	environment, ok := ctx.Value(environmentKey{}).(Environment)
+	return environment, ok
+}
+
+// EnvironmentRouter is a BridgeClient which sends every call to the client of the environment of
+// its context, see WithEnvironment, or to the default environment. The calls without a context
+// always go to the default environment.
+type EnvironmentRouter struct {
+	defaultName  string
+	environments map[string]Environment
+	clients      map[string]BridgeClient
+	namespaces   map[string]string
+}
+
+// NewEnvironmentRouter creates a router without environments, defaultName has to be added
+func NewEnvironmentRouter(defaultName string) *EnvironmentRouter {
+	return &EnvironmentRouter{
+		defaultName:  defaultName,
+		environments: make(map[string]Environment),
+		clients:      make(map[string]BridgeClient),
+		namespaces:   make(map[string]string),
+	}
+}
+
+// Add registers the client of an environment
+func (r *EnvironmentRouter) Add(environment Environment, client BridgeClient) error {
+	if _, ok := r.environments[environment.Name]; ok {
+		return fmt.Errorf("environment %s is added twice", environment.Name)
+	}
+	for _, namespace := range environment.Namespaces {
+		if err := ValidateNamespace(namespace); err != nil {
+			return err
+		}
+		if other, ok := r.namespaces[namespace]; ok {
+			return fmt.Errorf("namespace %s is mapped to environments %s and %s", namespace, other, environment.Name)
+		}
+	}
+
+	for _, namespace := range environment.Namespaces {
+		r.namespaces[namespace] = environment.Name
+	}
+	r.environments[environment.Name] = environment
+	r.clients[environment.Name] = client
+	return nil
+}
+
+// DefaultName is the name of the default environment
+func (r *EnvironmentRouter) DefaultName() string {
+	return r.defaultName
+}
+
+// Resolve picks the environment of a request: the named one, else the one the namespace is mapped
+// to, else the default one. Naming an environment other than the namespace's is an error.
+func (r *EnvironmentRouter) Resolve(name string, namespace string) (Environment, error) {
+	mapped, isMapped := r.namespaces[namespace]
+	if len(name) == 0 {
+		name = r.defaultName
+		if isMapped {
+			name = mapped
+		}
+	} else if isMapped && mapped != name {
+		return Environment{}, fmt.Errorf("namespace %s belongs to environment %s, not %s", namespace, mapped, name)
+	}
+
+	environment, ok := r.environments[name]
+	if !ok {
+		return Environment{}, fmt.Errorf("unknown environment %s", name)
+	}
+	return environment, nil
+}
+
+// client is the client of the context's environment
+func (r *EnvironmentRouter) client(ctx context.Context) (BridgeClient, error) {
+	name := r.defaultName
+	if environment, ok := EnvironmentFromContext(ctx); ok {
+		name = environment.Name
+	}
+	client, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %s", name)
+	}
+	return client, nil
+}
+
+// FunctionsStackID is the id of the functions stack of the default environment
+func (r *EnvironmentRouter) FunctionsStackID() string {
+	return r.clients[r.defaultName].FunctionsStackID()
+}
+
+// ListNamespaces lists the namespaces of the default environment
+func (r *EnvironmentRouter) ListNamespaces() ([]string, error) {
+	return r.ListNamespacesWithContext(context.Background())
+}
+
+// ListNamespacesWithContext lists the namespaces of the context's environment
+func (r *EnvironmentRouter) ListNamespacesWithContext(ctx context.Context) ([]string, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListNamespacesWithContext(ctx)
+}
+
+// ListServices lists the services of the default environment
+func (r *EnvironmentRouter) ListServices() ([]client.Service, error) {
+	return r.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext lists the services of the context's environment
+func (r *EnvironmentRouter) ListServicesWithContext(ctx context.Context) ([]client.Service, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListServicesWithContext(ctx)
+}
+
+// FindServiceByName finds a service of the default environment
+func (r *EnvironmentRouter) FindServiceByName(name string) (*client.Service, error) {
+	return r.FindServiceByNameWithContext(context.Background(), name)
+}
+
+// FindServiceByNameWithContext finds a service of the context's environment
+func (r *EnvironmentRouter) FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.FindServiceByNameWithContext(ctx, name)
+}
+
+// CreateService creates a service in the default environment
+func (r *EnvironmentRouter) CreateService(spec *client.Service) (*client.Service, error) {
+	return r.CreateServiceWithContext(context.Background(), spec)
+}
+
+// CreateServiceWithContext creates a service in the context's environment
+func (r *EnvironmentRouter) CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateServiceWithContext(ctx, spec)
+}
+
+// DeleteService deletes a service of the default environment
+func (r *EnvironmentRouter) DeleteService(spec *client.Service) error {
+	return r.DeleteServiceWithContext(context.Background(), spec)
+}
+
+// DeleteServiceWithContext deletes a service of the context's environment
+func (r *EnvironmentRouter) DeleteServiceWithContext(ctx context.Context, spec *client.Service) error {
+	c, err := r.client(ctx)
+	if err != nil {
+		return err
+	}
+	return c.DeleteServiceWithContext(ctx, spec)
+}
+
+// UpdateService updates a service of the default environment
+func (r *EnvironmentRouter) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
+	return r.UpdateServiceWithContext(context.Background(), spec, updates)
+}
+
+// UpdateServiceWithContext updates a service of the context's environment
+func (r *EnvironmentRouter) UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateServiceWithContext(ctx, spec, updates)
+}
+
+// UpgradeService upgrades a service of the default environment
+func (r *EnvironmentRouter) UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	return r.UpgradeServiceWithContext(context.Background(), spec, launchConfig)
+}
+
+// UpgradeServiceWithContext upgrades a service of the context's environment
+func (r *EnvironmentRouter) UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpgradeServiceWithContext(ctx, spec, launchConfig)
+}
+
+// ListInstances lists the containers of a service of the default environment
+func (r *EnvironmentRouter) ListInstances(spec *client.Service) ([]client.Container, error) {
+	return r.ListInstancesWithContext(context.Background(), spec)
+}
+
+// ListInstancesWithContext lists the containers of a service of the context's environment
+func (r *EnvironmentRouter) ListInstancesWithContext(ctx context.Context, spec *client.Service) ([]client.Container, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListInstancesWithContext(ctx, spec)
+}
+
+// ContainerLogs requests access to the logs of a container of the default environment
+func (r *EnvironmentRouter) ContainerLogs(container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	return r.ContainerLogsWithContext(context.Background(), container, logs)
+}
+
+// ContainerLogsWithContext requests access to the logs of a container of the context's environment
+func (r *EnvironmentRouter) ContainerLogsWithContext(ctx context.Context, container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ContainerLogsWithContext(ctx, container, logs)
+}
+
+// ContainerExec requests an exec session in a container of the default environment
+func (r *EnvironmentRouter) ContainerExec(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	return r.ContainerExecWithContext(context.Background(), container, exec)
+}
+
+// ContainerExecWithContext requests an exec session in a container of the context's environment
+func (r *EnvironmentRouter) ContainerExecWithContext(ctx context.Context, container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ContainerExecWithContext(ctx, container, exec)
+}
+
+// ContainerStats requests access to the stats of a container of the default environment
+func (r *EnvironmentRouter) ContainerStats(container *client.Container) (*client.StatsAccess, error) {
+	return r.ContainerStatsWithContext(context.Background(), container)
+}
+
+// ContainerStatsWithContext requests access to the stats of a container of the context's environment
+func (r *EnvironmentRouter) ContainerStatsWithContext(ctx context.Context, container *client.Container) (*client.StatsAccess, error) {
+	c, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.ContainerStatsWithContext(ctx, container)
+}
@@ -0,0 +1,78 @@
+package rancher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeEnvironmentRouter(t *testing.T, staging BridgeClient, prod BridgeClient) *EnvironmentRouter {
+	router := NewEnvironmentRouter("staging")
+	if err := router.Add(Environment{Name: "staging", FunctionsStackName: "faas-functions"}, staging); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Add(Environment{Name: "prod", FunctionsStackName: "faas-prod", Namespaces: []string{"team-a"}}, prod); err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func Test_EnvironmentRouter_Resolve(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router := makeEnvironmentRouter(t, new(mocks.BridgeClient), new(mocks.BridgeClient))
+
+	// Act
+	byDefault, defaultErr := router.Resolve("", DefaultNamespace)
+	byName, nameErr := router.Resolve("prod", DefaultNamespace)
+	byNamespace, namespaceErr := router.Resolve("", "team-a")
+	_, unknownErr := router.Resolve("dev", DefaultNamespace)
+	_, conflictErr := router.Resolve("staging", "team-a")
+
+	// Assert
+	assert.Nil(defaultErr)
+	assert.Equal("staging", byDefault.Name)
+	assert.Nil(nameErr)
+	assert.Equal("prod", byName.Name)
+	assert.Nil(namespaceErr)
+	assert.Equal("faas-prod", byNamespace.FunctionsStackName)
+	assert.NotNil(unknownErr)
+	assert.NotNil(conflictErr)
+}
+
+func Test_EnvironmentRouter_Routes_By_Context(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	staging := new(mocks.BridgeClient)
+	staging.On("ListServicesWithContext", mock.Anything).Return([]client.Service{{Name: "staging-function"}}, nil)
+	prod := new(mocks.BridgeClient)
+	prod.On("ListServicesWithContext", mock.Anything).Return([]client.Service{{Name: "prod-function"}}, nil)
+	router := makeEnvironmentRouter(t, staging, prod)
+	environment, _ := router.Resolve("prod", DefaultNamespace)
+
+	// Act
+	defaultServices, _ := router.ListServices()
+	prodServices, _ := router.ListServicesWithContext(WithEnvironment(context.Background(), environment))
+
+	// Assert
+	assert.Equal("staging-function", defaultServices[0].Name)
+	assert.Equal("prod-function", prodServices[0].Name)
+}
+
+func Test_EnvironmentRouter_Rejects_Namespace_Mapped_Twice(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	router := makeEnvironmentRouter(t, new(mocks.BridgeClient), new(mocks.BridgeClient))
+
+	// Act
+	err := router.Add(Environment{Name: "dev", Namespaces: []string{"team-a"}}, new(mocks.BridgeClient))
+
+	// Assert
+	assert.NotNil(err)
+	_, resolveErr := router.Resolve("dev", DefaultNamespace)
+	assert.NotNil(resolveErr, "the rejected environment was added")
+}
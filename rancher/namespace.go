@@ -0,0 +1,171 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// DefaultNamespace is the namespace of the functions stack itself, the other namespaces are
+// backed by stacks named after it, e.g. faas-functions-team-a for team-a
+const DefaultNamespace = "default"
+
+// stackIDTTL is how long the id of the stack of a namespace is used before it is looked up again,
+// so that a namespace whose stack was removed and created again finds the new one
+var stackIDTTL = time.Minute
+
+// stackIDEntry is the id of the stack of a namespace and when it was looked up
+type stackIDEntry struct {
+	id       string
+	resolved time.Time
+}
+
+var validNamespace = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+type namespaceKey struct{}
+
+// ValidateNamespace checks that a namespace can be part of a stack name and of a host name
+func ValidateNamespace(namespace string) error {
+	if !validNamespace.MatchString(namespace) || len(namespace) > 63 {
+		return fmt.Errorf("(%s) is not a valid namespace, use lower case letters, digits and dashes", namespace)
+	}
+	return nil
+}
+
+// WithNamespace returns a context in which the calls of the bridge act on the namespace's stack
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// NamespaceFromContext is the namespace set by WithNamespace, DefaultNamespace if none is
+func NamespaceFromContext(ctx context.Context) string {
+	if namespace, ok := ctx.Value(namespaceKey{}).(string); ok && len(namespace) > 0 {
+		return namespace
+	}
+	return DefaultNamespace
+}
+
+// NamespaceStackName is the name of the stack backing the namespace
+func NamespaceStackName(functionsStackName string, namespace string) string {
+	if namespace == DefaultNamespace {
+		return functionsStackName
+	}
+	return functionsStackName + "-" + namespace
+}
+
+// stackID is the id of the stack backing the namespace of ctx. A missing stack is created when
+// create is set, otherwise "" is returned for it.
+func (c *Client) stackID(ctx context.Context, create bool) (string, error) {
+	namespace := NamespaceFromContext(ctx)
+	if namespace == DefaultNamespace {
+		return c.functionsStackID, nil
+	}
+
+	c.stacksLock.Lock()
+	entry, ok := c.stackIDs[namespace]
+	c.stacksLock.Unlock()
+	if ok && time.Since(entry.resolved) < stackIDTTL {
+		return entry.id, nil
+	}
+
+	if create {
+		// serialized so that concurrent deploys into a new namespace create a single stack
+		c.createStackLock.Lock()
+		defer c.createStackLock.Unlock()
+	}
+
+	stackName := NamespaceStackName(c.config.FunctionsStackName, namespace)
+	stack, err := c.findStack(ctx, stackName)
+	if err != nil {
+		return "", err
+	}
+
+	if stack == nil {
+		if !create {
+			return "", nil
+		}
+
+		collectionURL, err := c.collectionURL("stack", nil)
+		if err != nil {
+			return "", err
+		}
+		stack = &client.Stack{}
+		spec := &client.Stack{Name: stackName, Description: "faas-rancher namespace " + namespace}
+		if err := c.do(ctx, "POST", collectionURL, spec, stack); err != nil {
+			return "", err
+		}
+		logging.FromContext(ctx).Info("Created the stack of a namespace", "stack", stackName, "namespace", namespace)
+	}
+
+	c.stacksLock.Lock()
+	c.stackIDs[namespace] = stackIDEntry{id: stack.Id, resolved: time.Now()}
+	c.stacksLock.Unlock()
+	return stack.Id, nil
+}
+
+// forgetStackID drops the id of the stack of the namespace of ctx, it is looked up again on next use
+func (c *Client) forgetStackID(ctx context.Context) {
+	c.stacksLock.Lock()
+	defer c.stacksLock.Unlock()
+	delete(c.stackIDs, NamespaceFromContext(ctx))
+}
+
+// findStack looks a stack up by name, nil is returned when there is none
+func (c *Client) findStack(ctx context.Context, name string) (*client.Stack, error) {
+	collectionURL, err := c.collectionURL("stack", url.Values{"name": {name}})
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := &client.StackCollection{}
+	if err := c.do(ctx, "GET", collectionURL, nil, stacks); err != nil {
+		return nil, err
+	}
+	for i := range stacks.Data {
+		if stacks.Data[i].Name == name && len(stacks.Data[i].Removed) == 0 {
+			return &stacks.Data[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ListNamespaces lists the namespaces, see ListNamespacesWithContext
+func (c *Client) ListNamespaces() ([]string, error) {
+	return c.ListNamespacesWithContext(context.Background())
+}
+
+// ListNamespacesWithContext lists the default namespace and those backed by a stack, sorted by name
+func (c *Client) ListNamespacesWithContext(ctx context.Context) ([]string, error) {
+	prefix := c.config.FunctionsStackName + "-"
+	collectionURL, err := c.collectionURL("stack", url.Values{"name_prefix": {prefix}})
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := &client.StackCollection{}
+	if err := c.do(ctx, "GET", collectionURL, nil, stacks); err != nil {
+		return nil, err
+	}
+
+	namespaces := []string{DefaultNamespace}
+	for _, stack := range stacks.Data {
+		namespace := strings.TrimPrefix(stack.Name, prefix)
+		if !strings.HasPrefix(stack.Name, prefix) || len(stack.Removed) > 0 ||
+			namespace == DefaultNamespace || ValidateNamespace(namespace) != nil {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
@@ -0,0 +1,122 @@
+package rancher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(ValidateNamespace("team-a"))
+	assert.Nil(ValidateNamespace("a1"))
+	assert.NotNil(ValidateNamespace(""))
+	assert.NotNil(ValidateNamespace("Team-A"))
+	assert.NotNil(ValidateNamespace("-team"))
+	assert.NotNil(ValidateNamespace("team_a"))
+	assert.NotNil(ValidateNamespace("team.a"))
+}
+
+func Test_NamespaceStackName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("faas-functions", NamespaceStackName("faas-functions", DefaultNamespace))
+	assert.Equal("faas-functions-team-a", NamespaceStackName("faas-functions", "team-a"))
+	assert.Equal(DefaultNamespace, NamespaceFromContext(context.Background()))
+	assert.Equal("team-a", NamespaceFromContext(WithNamespace(context.Background(), "team-a")))
+}
+
+func Test_Client_Namespace_Stack_Is_Created_Lazily(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	queries := []string{}
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			service := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&service)
+			service["id"] = "1s1"
+			writeJSON(w, service)
+		default:
+			queries = append(queries, r.URL.RawQuery)
+			writeJSON(w, map[string]interface{}{"data": []interface{}{}})
+		}
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+	ctx := WithNamespace(context.Background(), "team-a")
+
+	// Act
+	listedBefore, listErr := bridge.ListServicesWithContext(ctx)
+	namespacesBefore, _ := bridge.ListNamespacesWithContext(ctx)
+	created, createErr := bridge.CreateServiceWithContext(ctx, &client.Service{Name: "some-function"})
+	_, findErr := bridge.FindServiceByNameWithContext(ctx, "some-function")
+	namespacesAfter, _ := bridge.ListNamespacesWithContext(ctx)
+
+	// Assert
+	assert.Nil(listErr)
+	assert.Empty(listedBefore)
+	assert.Equal([]string{"default"}, namespacesBefore)
+	assert.Nil(createErr)
+	assert.Equal("1st2", created.StackId)
+	assert.Nil(findErr)
+	assert.Equal([]string{"name=some-function&stackId=1st2"}, queries, "the missing stack wasn't listed")
+	assert.Equal([]string{"default", "team-a"}, namespacesAfter)
+}
+
+func Test_Client_Namespace_Stack_Id_Expires(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	queries := []string{}
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		writeJSON(w, map[string]interface{}{"data": []interface{}{}})
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server).(*Client)
+	ctx := WithNamespace(context.Background(), "team-a")
+	bridge.stackIDs["team-a"] = stackIDEntry{id: "1st9", resolved: time.Now()}
+
+	// Act
+	bridge.FindServiceByNameWithContext(ctx, "some-function")
+	bridge.stackIDs["team-a"] = stackIDEntry{id: "1st9", resolved: time.Now().Add(-stackIDTTL)}
+	bridge.FindServiceByNameWithContext(ctx, "some-function")
+
+	// Assert
+	assert.Equal([]string{"name=some-function&stackId=1st9"}, queries, "the stack which no longer exists was still used")
+}
+
+func Test_Client_Forgets_Stack_Id_When_Create_Is_Rejected(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		service := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&service)
+		if service["stackId"] != "1st2" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			writeJSON(w, map[string]string{"code": "InvalidReference", "fieldName": "stackId"})
+			return
+		}
+		service["id"] = "1s1"
+		writeJSON(w, service)
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server).(*Client)
+	ctx := WithNamespace(context.Background(), "team-a")
+	bridge.stackIDs["team-a"] = stackIDEntry{id: "1st9", resolved: time.Now()}
+
+	// Act
+	_, rejectedErr := bridge.CreateServiceWithContext(ctx, &client.Service{Name: "some-function"})
+	created, createErr := bridge.CreateServiceWithContext(ctx, &client.Service{Name: "some-function"})
+
+	// Assert
+	assert.NotNil(rejectedErr)
+	assert.Nil(createErr)
+	assert.Equal("1st2", created.StackId)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kenfdev/faas-rancher/tracing"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// The go-rancher client can't cancel its requests, so the calls of the bridge are sent here
+// following the links and actions of the resources and schemas it fetched.
+
+// collectionURL is the URL of the collection of a schema type, with the filters as query
+func (c *Client) collectionURL(schemaType string, filters url.Values) (string, error) {
+	schema, ok := c.rancherClient.GetTypes()[schemaType]
+	if !ok {
+		return "", fmt.Errorf("unknown schema type [%s]", schemaType)
+	}
+
+	collection, ok := schema.Links["collection"]
+	if !ok {
+		return "", fmt.Errorf("failed to find the collection URL of [%s]", schemaType)
+	}
+
+	if len(filters) == 0 {
+		return collection, nil
+	}
+	u, err := url.Parse(collection)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for key, values := range filters {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// resourceLink is the URL of a link of a resource, such as self or instances
+func resourceLink(resource *client.Resource, link string) (string, error) {
+	linkURL, ok := resource.Links[link]
+	if !ok {
+		return "", fmt.Errorf("failed to find the %s link of [%s]", link, resource.Id)
+	}
+	return linkURL, nil
+}
+
+// resourceAction is the URL of an action of a resource, such as logs or execute
+func resourceAction(resource *client.Resource, action string) (string, error) {
+	actionURL, ok := resource.Actions[action]
+	if !ok {
+		return "", fmt.Errorf("action [%s] not available on [%s]", action, resource.Id)
+	}
+	return actionURL, nil
+}
+
+// do sends a request to Cattle which is cancelled along with ctx, the JSON response is decoded
+// into respObject unless it is nil. Errors of Cattle are returned as *client.ApiError.
+// Each request is traced as a span of the operation of ctx, the requests of operations which
+// aren't traced, such as those of the background loops, aren't either.
+func (c *Client) do(ctx context.Context, method string, requestURL string, body interface{}, respObject interface{}) error {
+	if _, ok := tracing.SpanContextFromContext(ctx); !ok {
+		_, err := c.send(ctx, method, requestURL, body, respObject)
+		return err
+	}
+
+	ctx, span := tracing.Start(ctx, "rancher "+method+" "+cattleResourceType(requestURL), tracing.KindClient)
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", requestURL)
+
+	statusCode, err := c.send(ctx, method, requestURL, body, respObject)
+	if statusCode > 0 {
+		span.SetAttribute("http.status_code", statusCode)
+	}
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// cattleResourceType names what a request is about from its URL, e.g. services for
+// /v2-beta/projects/1a5/services/1s5, with the action when there is one
+func cattleResourceType(requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return ""
+	}
+
+	// past the version and the project, if any
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")[1:]
+	if len(segments) > 2 && segments[0] == "projects" {
+		segments = segments[2:]
+	}
+	resourceType := ""
+	if len(segments) > 0 {
+		resourceType = segments[0]
+	}
+	if action := u.Query().Get("action"); len(action) > 0 {
+		resourceType += " " + action
+	}
+	return resourceType
+}
+
+// send sends the request of do, returning the status code of the response if there was one
+func (c *Client) send(ctx context.Context, method string, requestURL string, body interface{}, respObject interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	opts := c.rancherClient.GetOpts()
+	req.SetBasicAuth(opts.AccessKey, opts.SecretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+		tracing.Inject(req.Header, sc)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// report the cancellation rather than the transport's wrapping of it
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, &client.ApiError{
+			StatusCode: resp.StatusCode,
+			Url:        requestURL,
+			Msg:        fmt.Sprintf("Bad response statusCode [%d]. Status [%s]. Body: [%s] from [%s]", resp.StatusCode, resp.Status, respBytes, requestURL),
+			Status:     resp.Status,
+			Body:       string(respBytes),
+		}
+	}
+
+	if respObject == nil || len(respBytes) == 0 {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.Unmarshal(respBytes, respObject)
+}
@@ -0,0 +1,115 @@
+package rancher
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/kenfdev/faas-rancher/tracing"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingExporter keeps the spans which ended
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(span tracing.SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func attributeOf(span tracing.SpanData, key string) interface{} {
+	for _, attribute := range span.Attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	return nil
+}
+
+func Test_Client_Traces_Cattle_Requests(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	traceparents := []string{}
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusConflict)
+			writeJSON(w, map[string]string{"code": "Conflict"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"data": []map[string]interface{}{}})
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	exporter := &recordingExporter{}
+	tracing.SetDefault(tracing.NewTracer(exporter))
+	defer tracing.SetDefault(tracing.NewTracer(nil))
+	ctx, parent := tracing.Start(context.Background(), "deploy", tracing.KindServer)
+
+	// Act
+	_, findErr := bridge.FindServiceByNameWithContext(ctx, "some-function")
+	_, updateErr := bridge.UpdateServiceWithContext(ctx, &client.Service{Resource: client.Resource{
+		Id:    "1s1",
+		Links: map[string]string{"self": server.URL + "/v2-beta/services/1s1"},
+	}}, map[string]string{"scale": "2"})
+
+	// Assert
+	assert.Nil(findErr)
+	assert.NotNil(updateErr)
+	assert.Len(exporter.spans, 2)
+	for i, span := range exporter.spans {
+		assert.Equal(parent.Context().TraceID, span.Context.TraceID)
+		assert.Equal(parent.Context().SpanID, span.Parent)
+		assert.Equal(tracing.KindClient, span.Kind)
+		assert.Equal("00-"+span.Context.TraceID.String()+"-"+span.Context.SpanID.String()+"-01", traceparents[i])
+	}
+	assert.Equal("rancher GET services", exporter.spans[0].Name)
+	assert.Equal(200, attributeOf(exporter.spans[0], "http.status_code"))
+	assert.Empty(exporter.spans[0].Error)
+	assert.Equal("rancher PUT services", exporter.spans[1].Name)
+	assert.Equal(409, attributeOf(exporter.spans[1], "http.status_code"))
+	assert.Equal(updateErr.Error(), exporter.spans[1].Error)
+}
+
+func Test_Client_Does_Not_Trace_Requests_Without_Parent(t *testing.T) {
+	// Arrange
+	server := makeFakeCattle(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"data": []map[string]interface{}{}})
+	})
+	defer server.Close()
+	bridge := makeFakeCattleClient(t, server)
+
+	exporter := &recordingExporter{}
+	tracing.SetDefault(tracing.NewTracer(exporter))
+	defer tracing.SetDefault(tracing.NewTracer(nil))
+
+	// Act
+	_, err := bridge.FindServiceByNameWithContext(context.Background(), "some-function")
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Empty(t, exporter.spans)
+}
+
+func Test_cattleResourceType(t *testing.T) {
+	assert := assert.New(t)
+	cases := map[string]string{
+		"http://rancher:8080/v2-beta/projects/1a5/services?stackId=1st5":              "services",
+		"http://rancher:8080/v2-beta/projects/1a5/services/1s5":                       "services",
+		"http://rancher:8080/v2-beta/projects/1a5/services/1s5/?action=finishupgrade": "services finishupgrade",
+		"http://rancher:8080/v2-beta/services/1s5/instances":                          "services",
+		"http://rancher:8080/v2-beta/projects/1a5/containers/1i5/?action=execute":     "containers execute",
+		"http://rancher:8080/v2-beta":                                                 "",
+	}
+
+	for requestURL, expected := range cases {
+		assert.Equal(expected, cattleResourceType(requestURL), requestURL)
+	}
+}
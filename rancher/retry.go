@@ -0,0 +1,436 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// RetryPolicy configures how calls to Cattle are retried and when Cattle is considered down
+type RetryPolicy struct {
+	// Attempts is the maximum number of times a call is made, 1 disables retrying
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for every further retry up to MaxBackoff.
+	// The actual delay is randomly picked between half and all of it.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerFailures is the number of consecutive failures after which the circuit opens, 0 disables it
+	BreakerFailures int
+	// BreakerOpen is how long calls fail fast before a trial call is let through
+	BreakerOpen time.Duration
+}
+
+// CircuitOpenError is returned without calling Cattle while it is considered down
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Rancher is unavailable, retry after %s", e.RetryAfter)
+}
+
+// CircuitOpen tells whether err was returned because Cattle is considered down, and for how long
+func CircuitOpen(err error) (time.Duration, bool) {
+	if openErr, ok := err.(*CircuitOpenError); ok {
+		return openErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// RetryingClient retries the failed calls of the wrapped client which are safe to repeat, and stops
+// calling Cattle for a while once it failed too often in a row. CreateService is only retried after
+// checking that the first attempt didn't create the service.
+type RetryingClient struct {
+	BridgeClient
+
+	environment string
+	policy      RetryPolicy
+	breaker     *circuitBreaker
+	sleep       func(ctx context.Context, d time.Duration) error
+
+	calls   *metrics.Counter
+	retries *metrics.Counter
+}
+
+// NewRetryingClient wraps the client of the environment with the retry policy, its metrics are
+// labelled with the name of the environment
+func NewRetryingClient(bridge BridgeClient, policy RetryPolicy, environment string, registry *metrics.Registry) *RetryingClient {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+
+	c := &RetryingClient{
+		BridgeClient: bridge,
+		environment:  environment,
+		policy:       policy,
+		breaker:      newCircuitBreaker(policy.BreakerFailures, policy.BreakerOpen, time.Now),
+		sleep:        sleepContext,
+		calls:        registry.NewCounter("faas_rancher_cattle_calls_total", "Calls to Cattle by environment, operation and result.", "environment", "operation", "result"),
+		retries:      registry.NewCounter("faas_rancher_cattle_retries_total", "Retried calls to Cattle by environment and operation.", "environment", "operation"),
+	}
+	circuitOpen := registry.NewGauge("faas_rancher_cattle_circuit_open", "1 while calls to Cattle fail fast, by environment.", "environment")
+	circuitOpen.SetFunc(func() float64 {
+		if c.breaker.isOpen() {
+			return 1
+		}
+		return 0
+	}, environment)
+	return c
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable tells whether a failure may be transient: the connection failed or Cattle answered 5xx or 429
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *client.ApiError:
+		return e.StatusCode >= 500 || e.StatusCode == 429
+	case *url.Error, net.Error:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF || err == io.EOF
+}
+
+// call runs fn up to the policy's attempts when retry is set, backing off between attempts
+func (c *RetryingClient) call(ctx context.Context, operation string, retry bool, fn func() error) error {
+	attempts := 1
+	if retry {
+		attempts = c.policy.Attempts
+	}
+	backoff := c.policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		if retryAfter, ok := c.breaker.allow(); !ok {
+			c.calls.Inc(c.environment, operation, "circuit_open")
+			return &CircuitOpenError{RetryAfter: retryAfter}
+		}
+
+		err := fn()
+		if ctx.Err() == nil {
+			c.breaker.record(err != nil && retryable(err))
+		} else {
+			c.breaker.release()
+		}
+		if err == nil {
+			c.calls.Inc(c.environment, operation, "success")
+			return nil
+		}
+		if !retryable(err) || attempt >= attempts || ctx.Err() != nil {
+			c.calls.Inc(c.environment, operation, "error")
+			return err
+		}
+
+		c.retries.Inc(c.environment, operation)
+		if sleepErr := c.sleep(ctx, jitter(backoff)); sleepErr != nil {
+			c.calls.Inc(c.environment, operation, "error")
+			return sleepErr
+		}
+		backoff *= 2
+		if backoff > c.policy.MaxBackoff {
+			backoff = c.policy.MaxBackoff
+		}
+	}
+}
+
+// jitter picks a delay between half and all of d, so that clients don't retry in lockstep
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ListNamespaces lists the namespaces, retrying failures
+func (c *RetryingClient) ListNamespaces() ([]string, error) {
+	return c.ListNamespacesWithContext(context.Background())
+}
+
+// ListNamespacesWithContext lists the namespaces, retrying failures
+func (c *RetryingClient) ListNamespacesWithContext(ctx context.Context) ([]string, error) {
+	var namespaces []string
+	err := c.call(ctx, "list_namespaces", true, func() error {
+		var err error
+		namespaces, err = c.BridgeClient.ListNamespacesWithContext(ctx)
+		return err
+	})
+	return namespaces, err
+}
+
+// ListServices lists the services of the functions stack, retrying failures
+func (c *RetryingClient) ListServices() ([]client.Service, error) {
+	return c.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext lists the services of the functions stack, retrying failures
+func (c *RetryingClient) ListServicesWithContext(ctx context.Context) ([]client.Service, error) {
+	var services []client.Service
+	err := c.call(ctx, "list_services", true, func() error {
+		var err error
+		services, err = c.BridgeClient.ListServicesWithContext(ctx)
+		return err
+	})
+	return services, err
+}
+
+// FindServiceByName finds a service of the functions stack, retrying failures
+func (c *RetryingClient) FindServiceByName(name string) (*client.Service, error) {
+	return c.FindServiceByNameWithContext(context.Background(), name)
+}
+
+// FindServiceByNameWithContext finds a service of the functions stack, retrying failures
+func (c *RetryingClient) FindServiceByNameWithContext(ctx context.Context, name string) (*client.Service, error) {
+	var service *client.Service
+	err := c.call(ctx, "find_service", true, func() error {
+		var err error
+		service, err = c.BridgeClient.FindServiceByNameWithContext(ctx, name)
+		return err
+	})
+	return service, err
+}
+
+// CreateService creates a service, see CreateServiceWithContext
+func (c *RetryingClient) CreateService(spec *client.Service) (*client.Service, error) {
+	return c.CreateServiceWithContext(context.Background(), spec)
+}
+
+// CreateServiceWithContext creates a service. A failed attempt may still have created it,
+// so before retrying the service is looked up and returned if it exists.
+func (c *RetryingClient) CreateServiceWithContext(ctx context.Context, spec *client.Service) (*client.Service, error) {
+	var service *client.Service
+	attempted := false
+	err := c.call(ctx, "create_service", true, func() error {
+		if attempted {
+			existing, findErr := c.BridgeClient.FindServiceByNameWithContext(ctx, spec.Name)
+			if findErr != nil {
+				return findErr
+			}
+			if existing != nil {
+				service = existing
+				return nil
+			}
+		}
+		attempted = true
+
+		var err error
+		service, err = c.BridgeClient.CreateServiceWithContext(ctx, spec)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// DeleteService deletes a service, retrying failures
+func (c *RetryingClient) DeleteService(spec *client.Service) error {
+	return c.DeleteServiceWithContext(context.Background(), spec)
+}
+
+// DeleteServiceWithContext deletes a service, retrying failures. A retry which finds the
+// service gone counts as success, the failed attempt deleted it.
+func (c *RetryingClient) DeleteServiceWithContext(ctx context.Context, spec *client.Service) error {
+	attempted := false
+	return c.call(ctx, "delete_service", true, func() error {
+		err := c.BridgeClient.DeleteServiceWithContext(ctx, spec)
+		if attempted && client.IsNotFound(err) {
+			return nil
+		}
+		attempted = true
+		return err
+	})
+}
+
+// UpdateService updates a service, retrying failures
+func (c *RetryingClient) UpdateService(spec *client.Service, updates map[string]string) (*client.Service, error) {
+	return c.UpdateServiceWithContext(context.Background(), spec, updates)
+}
+
+// UpdateServiceWithContext updates a service, retrying failures. The updates set absolute values
+// such as the scale, so repeating them is harmless.
+func (c *RetryingClient) UpdateServiceWithContext(ctx context.Context, spec *client.Service, updates map[string]string) (*client.Service, error) {
+	var service *client.Service
+	err := c.call(ctx, "update_service", true, func() error {
+		var err error
+		service, err = c.BridgeClient.UpdateServiceWithContext(ctx, spec, updates)
+		return err
+	})
+	return service, err
+}
+
+// UpgradeService upgrades a service, which is never retried
+func (c *RetryingClient) UpgradeService(spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	return c.UpgradeServiceWithContext(context.Background(), spec, launchConfig)
+}
+
+// UpgradeServiceWithContext upgrades a service, which is never retried as a repeated upgrade
+// finds the service already upgrading
+func (c *RetryingClient) UpgradeServiceWithContext(ctx context.Context, spec *client.Service, launchConfig *client.LaunchConfig) (*client.Service, error) {
+	var service *client.Service
+	err := c.call(ctx, "upgrade_service", false, func() error {
+		var err error
+		service, err = c.BridgeClient.UpgradeServiceWithContext(ctx, spec, launchConfig)
+		return err
+	})
+	return service, err
+}
+
+// ListInstances lists the containers of a service, retrying failures
+func (c *RetryingClient) ListInstances(spec *client.Service) ([]client.Container, error) {
+	return c.ListInstancesWithContext(context.Background(), spec)
+}
+
+// ListInstancesWithContext lists the containers of a service, retrying failures
+func (c *RetryingClient) ListInstancesWithContext(ctx context.Context, spec *client.Service) ([]client.Container, error) {
+	var containers []client.Container
+	err := c.call(ctx, "list_instances", true, func() error {
+		var err error
+		containers, err = c.BridgeClient.ListInstancesWithContext(ctx, spec)
+		return err
+	})
+	return containers, err
+}
+
+// ContainerLogs requests access to the logs of a container, retrying failures
+func (c *RetryingClient) ContainerLogs(container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	return c.ContainerLogsWithContext(context.Background(), container, logs)
+}
+
+// ContainerLogsWithContext requests access to the logs of a container, retrying failures
+func (c *RetryingClient) ContainerLogsWithContext(ctx context.Context, container *client.Container, logs *client.ContainerLogs) (*client.HostAccess, error) {
+	var access *client.HostAccess
+	err := c.call(ctx, "container_logs", true, func() error {
+		var err error
+		access, err = c.BridgeClient.ContainerLogsWithContext(ctx, container, logs)
+		return err
+	})
+	return access, err
+}
+
+// ContainerExec starts a command inside a container, which is never retried
+func (c *RetryingClient) ContainerExec(container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	return c.ContainerExecWithContext(context.Background(), container, exec)
+}
+
+// ContainerExecWithContext starts a command inside a container, which is never retried
+func (c *RetryingClient) ContainerExecWithContext(ctx context.Context, container *client.Container, exec *client.ContainerExec) (*client.HostAccess, error) {
+	var access *client.HostAccess
+	err := c.call(ctx, "container_exec", false, func() error {
+		var err error
+		access, err = c.BridgeClient.ContainerExecWithContext(ctx, container, exec)
+		return err
+	})
+	return access, err
+}
+
+// ContainerStats requests access to the resource usage of a container, retrying failures
+func (c *RetryingClient) ContainerStats(container *client.Container) (*client.StatsAccess, error) {
+	return c.ContainerStatsWithContext(context.Background(), container)
+}
+
+// ContainerStatsWithContext requests access to the resource usage of a container, retrying failures
+func (c *RetryingClient) ContainerStatsWithContext(ctx context.Context, container *client.Container) (*client.StatsAccess, error) {
+	var access *client.StatsAccess
+	err := c.call(ctx, "container_stats", true, func() error {
+		var err error
+		access, err = c.BridgeClient.ContainerStatsWithContext(ctx, container)
+		return err
+	})
+	return access, err
+}
+
+// circuitBreaker opens after consecutive failures and lets a single trial call through once
+// it was open long enough, closing again when the trial succeeds
+type circuitBreaker struct {
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+	trial    bool
+}
+
+func newCircuitBreaker(threshold int, openFor time.Duration, now func() time.Time) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		openFor:   openFor,
+		now:       now,
+	}
+}
+
+// allow tells whether a call may be made, or how long to wait otherwise
+func (b *circuitBreaker) allow() (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.threshold <= 0 || !b.open {
+		return 0, true
+	}
+
+	remaining := b.openFor - b.now().Sub(b.openedAt)
+	if remaining > 0 {
+		return remaining, false
+	}
+	if b.trial {
+		// a trial call is in flight, its outcome decides
+		return time.Second, false
+	}
+	b.trial = true
+	return 0, true
+}
+
+// record accounts the outcome of an allowed call
+func (b *circuitBreaker) record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if b.threshold > 0 && (b.open || b.failures >= b.threshold) {
+		b.open = true
+		b.openedAt = b.now()
+	}
+}
+
+// release accounts an allowed call which was abandoned without an outcome
+func (b *circuitBreaker) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.trial = false
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.open
+}
@@ -0,0 +1,224 @@
+package rancher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var connectionReset = &url.Error{Op: "Get", URL: "http://rancher.local", Err: errors.New("connection reset by peer")}
+
+// makeRetryingClient wraps the mock with a policy whose backoff is recorded instead of slept
+func makeRetryingClient(bridge BridgeClient, policy RetryPolicy) (*RetryingClient, *[]time.Duration) {
+	c := NewRetryingClient(bridge, policy, "default", metrics.NewRegistry())
+	slept := []time.Duration{}
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return c, &slept
+}
+
+func Test_RetryingClient_Retries_Server_Errors_With_Backoff(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(nil, &client.ApiError{StatusCode: 502}).Twice()
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{{Name: "some-function"}}, nil).Once()
+	c, slept := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3, Backoff: time.Millisecond * 100, MaxBackoff: time.Millisecond * 150})
+
+	// Act
+	services, err := c.ListServices()
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal(1, len(services))
+	assert.Equal(2, len(*slept))
+	assert.True((*slept)[0] >= time.Millisecond*50 && (*slept)[0] <= time.Millisecond*100)
+	assert.True((*slept)[1] >= time.Millisecond*75 && (*slept)[1] <= time.Millisecond*150)
+	mockClient.AssertNumberOfCalls(t, "ListServicesWithContext", 3)
+}
+
+func Test_RetryingClient_Gives_Up_After_Attempts(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-function").Return(nil, connectionReset)
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 2})
+
+	// Act
+	_, err := c.FindServiceByName("some-function")
+
+	// Assert
+	assert.Equal(connectionReset, err)
+	mockClient.AssertNumberOfCalls(t, "FindServiceByNameWithContext", 2)
+}
+
+func Test_RetryingClient_Does_Not_Retry_Client_Errors(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	service := &client.Service{Name: "some-function"}
+	mockClient.On("UpdateServiceWithContext", mock.Anything, service, map[string]string{"scale": "2"}).Return(nil, &client.ApiError{StatusCode: 422})
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3})
+
+	// Act
+	_, err := c.UpdateService(service, map[string]string{"scale": "2"})
+
+	// Assert
+	assert.NotNil(err)
+	mockClient.AssertNumberOfCalls(t, "UpdateServiceWithContext", 1)
+}
+
+func Test_RetryingClient_Create_Returns_Service_Created_By_Failed_Attempt(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	spec := &client.Service{Name: "some-function"}
+	existing := &client.Service{Resource: client.Resource{Id: "1s1"}, Name: "some-function"}
+	mockClient.On("CreateServiceWithContext", mock.Anything, spec).Return(nil, connectionReset).Once()
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-function").Return(existing, nil).Once()
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3})
+
+	// Act
+	service, err := c.CreateService(spec)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal(existing, service)
+	mockClient.AssertNumberOfCalls(t, "CreateServiceWithContext", 1)
+}
+
+func Test_RetryingClient_Create_Retries_When_Nothing_Was_Created(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	spec := &client.Service{Name: "some-function"}
+	created := &client.Service{Resource: client.Resource{Id: "1s1"}, Name: "some-function"}
+	mockClient.On("CreateServiceWithContext", mock.Anything, spec).Return(nil, &client.ApiError{StatusCode: 503}).Once()
+	mockClient.On("FindServiceByNameWithContext", mock.Anything, "some-function").Return(nil, nil).Once()
+	mockClient.On("CreateServiceWithContext", mock.Anything, spec).Return(created, nil).Once()
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3})
+
+	// Act
+	service, err := c.CreateService(spec)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal(created, service)
+	mockClient.AssertNumberOfCalls(t, "CreateServiceWithContext", 2)
+}
+
+func Test_RetryingClient_Delete_Retry_Finding_Service_Gone_Succeeds(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	service := &client.Service{Name: "some-function"}
+	mockClient.On("DeleteServiceWithContext", mock.Anything, service).Return(connectionReset).Once()
+	mockClient.On("DeleteServiceWithContext", mock.Anything, service).Return(&client.ApiError{StatusCode: 404}).Once()
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3})
+
+	// Act
+	err := c.DeleteService(service)
+
+	// Assert
+	assert.Nil(err)
+}
+
+func Test_RetryingClient_Does_Not_Retry_Exec(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	container := &client.Container{Name: "some-function-1"}
+	exec := &client.ContainerExec{Command: []string{"/bin/sh"}}
+	mockClient.On("ContainerExecWithContext", mock.Anything, container, exec).Return(nil, connectionReset)
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 3})
+
+	// Act
+	_, err := c.ContainerExec(container, exec)
+
+	// Assert
+	assert.Equal(connectionReset, err)
+	mockClient.AssertNumberOfCalls(t, "ContainerExecWithContext", 1)
+}
+
+func Test_RetryingClient_Circuit_Opens_And_Recovers(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	now := time.Date(2017, 10, 1, 10, 0, 0, 0, time.UTC)
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(nil, &client.ApiError{StatusCode: 500}).Times(3)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{}, nil)
+	c, _ := makeRetryingClient(mockClient, RetryPolicy{Attempts: 1, BreakerFailures: 3, BreakerOpen: time.Second * 30})
+	c.breaker.now = func() time.Time { return now }
+
+	// Act
+	for i := 0; i < 3; i++ {
+		c.ListServices()
+	}
+	now = now.Add(time.Second * 10)
+	_, openErr := c.ListServices()
+	now = now.Add(time.Second * 20)
+	_, trialErr := c.ListServices()
+
+	// Assert
+	retryAfter, open := CircuitOpen(openErr)
+	assert.True(open)
+	assert.Equal(time.Second*20, retryAfter)
+	assert.Nil(trialErr)
+	assert.False(c.breaker.isOpen())
+	mockClient.AssertNumberOfCalls(t, "ListServicesWithContext", 4)
+}
+
+func Test_RetryingClient_Metrics_Are_Labelled_With_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	registry := metrics.NewRegistry()
+	failing, healthy := new(mocks.BridgeClient), new(mocks.BridgeClient)
+	failing.On("ListServicesWithContext", mock.Anything).Return(nil, &client.ApiError{StatusCode: 500})
+	healthy.On("ListServicesWithContext", mock.Anything).Return([]client.Service{}, nil)
+	policy := RetryPolicy{Attempts: 1, BreakerFailures: 1, BreakerOpen: time.Minute}
+	staging := NewRetryingClient(failing, policy, "staging", registry)
+	production := NewRetryingClient(healthy, policy, "production", registry)
+
+	// Act
+	staging.ListServices()
+	production.ListServices()
+
+	// Assert
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	registry.ServeHTTP(rr, req)
+	body := rr.Body.String()
+	assert.Contains(body, `faas_rancher_cattle_calls_total{environment="staging",operation="list_services",result="error"} 1`)
+	assert.Contains(body, `faas_rancher_cattle_calls_total{environment="production",operation="list_services",result="success"} 1`)
+	assert.Contains(body, `faas_rancher_cattle_circuit_open{environment="staging"} 1`)
+	assert.Contains(body, `faas_rancher_cattle_circuit_open{environment="production"} 0`)
+}
+
+func Test_RetryingClient_Stops_Retrying_When_Cancelled(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(nil, connectionReset)
+	c := NewRetryingClient(mockClient, RetryPolicy{Attempts: 5, Backoff: time.Hour, MaxBackoff: time.Hour}, "default", metrics.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*10, cancel)
+
+	// Act
+	_, err := c.ListServicesWithContext(ctx)
+
+	// Assert
+	assert.Equal(context.Canceled, err)
+	mockClient.AssertNumberOfCalls(t, "ListServicesWithContext", 1)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// StatsSample is a resource usage sample of a container as sent over the stats websocket,
+// the counters are cumulative since the container started
+type StatsSample struct {
+	ID        string       `json:"id"`
+	Timestamp time.Time    `json:"timestamp"`
+	MemLimit  uint64       `json:"memLimit"`
+	CPU       CPUStats     `json:"cpu"`
+	Memory    MemoryStats  `json:"memory"`
+	Network   NetworkStats `json:"network"`
+}
+
+// CPUStats is the CPU time used by a container
+type CPUStats struct {
+	Usage CPUUsage `json:"usage"`
+}
+
+// CPUUsage is the CPU time used by a container in nanoseconds
+type CPUUsage struct {
+	Total uint64 `json:"total"`
+}
+
+// MemoryStats is the memory used by a container in bytes
+type MemoryStats struct {
+	Usage uint64 `json:"usage"`
+}
+
+// NetworkStats is the traffic of a container's network interfaces
+type NetworkStats struct {
+	Interfaces []InterfaceStats `json:"interfaces"`
+}
+
+// InterfaceStats is the traffic of a network interface in bytes
+type InterfaceStats struct {
+	Name    string `json:"name"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// ParseStatsSamples decodes a message of the stats websocket, which holds either a list of samples or a single one
+func ParseStatsSamples(data []byte) ([]StatsSample, error) {
+	samples := []StatsSample{}
+	if len(data) > 0 && data[0] == '[' {
+		err := json.Unmarshal(data, &samples)
+		return samples, err
+	}
+
+	sample := StatsSample{}
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return nil, err
+	}
+	return append(samples, sample), nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package rancher
+
+import (
+	"net/url"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// DialHostAccess opens the websocket to a host granted by a container action such as logs
+func DialHostAccess(access *client.HostAccess) (*websocket.Conn, error) {
+	return dial(access.Url, access.Token)
+}
+
+// DialStatsAccess opens the websocket streaming the resource usage of a container
+func DialStatsAccess(access *client.StatsAccess) (*websocket.Conn, error) {
+	return dial(access.Url, access.Token)
+}
+
+func dial(rawURL string, token string) (*websocket.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// the websocket dialer rejects URLs without a path
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
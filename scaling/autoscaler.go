@@ -0,0 +1,241 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package scaling
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/handlers"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+)
+
+// AutoscalerConfig tunes the autoscaler
+type AutoscalerConfig struct {
+	// Tolerance is the relative deviation from the target concurrency which is ignored, e.g. 0.1
+	Tolerance float64
+	// ScaleUpWindow is how long a higher scale has to be recommended before scaling up
+	ScaleUpWindow time.Duration
+	// ScaleDownWindow is how long a lower scale has to be recommended before scaling down
+	ScaleDownWindow time.Duration
+	// MaxReplicas caps the scale of every function, ignored when zero
+	MaxReplicas int64
+	// AuditLog records the scalings, nothing is recorded when nil
+	AuditLog *audit.Log
+}
+
+// FunctionLoad is the load of a function measured between two reconciliations
+type FunctionLoad struct {
+	RequestsPerSecond float64
+	// Concurrency is the average number of in-flight invocations
+	Concurrency float64
+	InFlight    int64
+}
+
+// Autoscaler periodically scales functions labelled with com.openfaas.scale.target so that
+// each replica handles the target number of concurrent invocations
+type Autoscaler struct {
+	client  rancher.BridgeClient
+	tracker *handlers.InvocationTracker
+	config  AutoscalerConfig
+	clock   handlers.Clock
+
+	lock      sync.Mutex
+	functions map[string]*functionHistory
+}
+
+type functionHistory struct {
+	last            handlers.InvocationSnapshot
+	load            FunctionLoad
+	recommendations []recommendation
+}
+
+type recommendation struct {
+	at       time.Time
+	replicas int64
+}
+
+// NewAutoscaler creates an autoscaler driven by the invocations recorded by the tracker
+func NewAutoscaler(client rancher.BridgeClient, tracker *handlers.InvocationTracker, config AutoscalerConfig, clock handlers.Clock) *Autoscaler {
+	return &Autoscaler{
+		client:    client,
+		tracker:   tracker,
+		config:    config,
+		clock:     clock,
+		functions: make(map[string]*functionHistory),
+	}
+}
+
+// Run reconciles every interval until stop is closed
+func (a *Autoscaler) Run(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(audit.WithSystem(context.Background(), a.config.AuditLog, "autoscaler"))
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.Reconcile(ctx)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Load returns the load measured for the function by the last reconciliation
+func (a *Autoscaler) Load(functionName string) (FunctionLoad, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	history, ok := a.functions[functionName]
+	if !ok {
+		return FunctionLoad{}, false
+	}
+	return history.load, true
+}
+
+// Reconcile measures the load of every autoscaled function and updates its scale when needed
+func (a *Autoscaler) Reconcile(ctx context.Context) {
+	services, err := a.client.ListServicesWithContext(ctx)
+	if err != nil {
+		logging.Default().Error("Unable to list services for autoscaling", "error", err)
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i := range services {
+		service := &services[i]
+		// functions at zero are left to the wake-up of the proxy
+		if service.State != "active" || service.Scale == 0 || !handlers.IsFunction(service) {
+			continue
+		}
+		target, ok := handlers.TargetConcurrency(service)
+		if !ok {
+			continue
+		}
+
+		replicas, ok := a.recommend(service.Name, service.Scale, target)
+		if !ok {
+			continue
+		}
+
+		min, max := handlers.ReplicaBounds(service, a.config.MaxReplicas)
+		replicas = clamp(replicas, min, max)
+		if replicas == service.Scale {
+			continue
+		}
+
+		load := a.functions[service.Name].load
+		logging.Default().Info("Autoscaling function", "function", service.Name, "from", service.Scale, "to", replicas,
+			"requestsPerSecond", load.RequestsPerSecond, "concurrency", load.Concurrency)
+
+		updates := make(map[string]string)
+		updates["scale"] = strconv.FormatInt(replicas, 10)
+		_, updateErr := a.client.UpdateServiceWithContext(ctx, service, updates)
+		handlers.RecordScale(ctx, service, replicas, "autoscale", updateErr)
+		if updateErr != nil {
+			logging.Default().Error("Unable to autoscale function", "function", service.Name, "error", updateErr)
+		}
+	}
+}
+
+// recommend returns the stabilized scale for the function, false until it was measured twice
+func (a *Autoscaler) recommend(functionName string, current int64, target float64) (int64, bool) {
+	snapshot := a.tracker.Snapshot(functionName)
+
+	history, ok := a.functions[functionName]
+	if !ok {
+		a.functions[functionName] = &functionHistory{last: snapshot}
+		return 0, false
+	}
+
+	elapsed := snapshot.Taken.Sub(history.last.Taken).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	history.load = FunctionLoad{
+		RequestsPerSecond: float64(snapshot.Invocations-history.last.Invocations) / elapsed,
+		Concurrency:       (snapshot.InFlightSeconds - history.last.InFlightSeconds) / elapsed,
+		InFlight:          snapshot.InFlight,
+	}
+	history.last = snapshot
+
+	desired := current
+	ratio := history.load.Concurrency / (target * float64(current))
+	if math.Abs(ratio-1) > a.config.Tolerance {
+		desired = int64(math.Ceil(history.load.Concurrency / target))
+	}
+
+	now := snapshot.Taken
+	history.recommendations = append(history.recommendations, recommendation{at: now, replicas: desired})
+	history.prune(now, a.longestWindow())
+
+	return history.stabilize(now, current, a.config.ScaleUpWindow, a.config.ScaleDownWindow), true
+}
+
+func (a *Autoscaler) longestWindow() time.Duration {
+	if a.config.ScaleUpWindow > a.config.ScaleDownWindow {
+		return a.config.ScaleUpWindow
+	}
+	return a.config.ScaleDownWindow
+}
+
+// prune drops the recommendations older than window, keeping the latest one
+func (h *functionHistory) prune(now time.Time, window time.Duration) {
+	kept := h.recommendations[:0]
+	for i, r := range h.recommendations {
+		if now.Sub(r.at) <= window || i == len(h.recommendations)-1 {
+			kept = append(kept, r)
+		}
+	}
+	h.recommendations = kept
+}
+
+// stabilize only scales up to the lowest recommendation of the scale-up window and
+// only scales down to the highest recommendation of the scale-down window
+func (h *functionHistory) stabilize(now time.Time, current int64, upWindow time.Duration, downWindow time.Duration) int64 {
+	up := int64(math.MaxInt64)
+	down := int64(math.MinInt64)
+	for _, r := range h.recommendations {
+		age := now.Sub(r.at)
+		if age <= upWindow && r.replicas < up {
+			up = r.replicas
+		}
+		if age <= downWindow && r.replicas > down {
+			down = r.replicas
+		}
+	}
+
+	if up > current {
+		return up
+	}
+	if down < current {
+		return down
+	}
+	return current
+}
+
+func clamp(value int64, min int64, max int64) int64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,177 @@
+package scaling
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/handlers"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func makeAutoscaler(services []client.Service, config AutoscalerConfig) (*Autoscaler, *handlers.InvocationTracker, *fakeClock, *mocks.BridgeClient) {
+	clock := &fakeClock{now: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := handlers.NewInvocationTrackerWithClock(clock)
+	mockClient := new(mocks.BridgeClient)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
+	return NewAutoscaler(mockClient, tracker, config, clock), tracker, clock, mockClient
+}
+
+func invoke(tracker *handlers.InvocationTracker, name string, concurrent int) {
+	for i := 0; i < concurrent; i++ {
+		tracker.Begin(name)
+	}
+}
+
+func Test_Autoscaler_Scales_Up_To_Target_Concurrency(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 1, map[string]interface{}{handlers.ScaleTargetLabel: "2"}),
+	}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, AutoscalerConfig{Tolerance: 0.1})
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "3"}).Return(nil, nil)
+
+	// Act
+	autoscaler.Reconcile(context.Background())
+	invoke(tracker, "fn", 6)
+	clock.Advance(10 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertExpectations(t)
+	load, ok := autoscaler.Load("fn")
+	assert.True(ok)
+	assert.Equal(0.6, load.RequestsPerSecond)
+	assert.Equal(6.0, load.Concurrency)
+	assert.Equal(int64(6), load.InFlight)
+}
+
+func Test_Autoscaler_Records_Scalings(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 1, map[string]interface{}{handlers.ScaleTargetLabel: "2"}),
+	}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, AutoscalerConfig{Tolerance: 0.1})
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "3"}).Return(nil, nil)
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	ctx := audit.WithSystem(context.Background(), auditLog, "autoscaler")
+
+	// Act
+	autoscaler.Reconcile(ctx)
+	invoke(tracker, "fn", 6)
+	clock.Advance(10 * time.Second)
+	autoscaler.Reconcile(ctx)
+
+	// Assert
+	entries, _ := auditLog.Since(time.Time{})
+	if assert.Len(entries, 1) {
+		assert.Equal("system:autoscaler", entries[0].Principal)
+		assert.Equal("scale", entries[0].Operation)
+		assert.Equal("fn", entries[0].Function)
+	}
+}
+
+func Test_Autoscaler_Ignores_Load_Within_Tolerance(t *testing.T) {
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 2, map[string]interface{}{handlers.ScaleTargetLabel: "2"}),
+	}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, AutoscalerConfig{Tolerance: 0.1})
+
+	// Act
+	autoscaler.Reconcile(context.Background())
+	invoke(tracker, "fn", 4)
+	clock.Advance(10 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_Autoscaler_Stabilizes_Scale_Down(t *testing.T) {
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 4, map[string]interface{}{handlers.ScaleTargetLabel: "1"}),
+	}
+	config := AutoscalerConfig{Tolerance: 0.1, ScaleDownWindow: time.Minute}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, config)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "1"}).Return(nil, nil)
+
+	// Act, load drops to a single concurrent invocation for less than the window
+	autoscaler.Reconcile(context.Background())
+	invoke(tracker, "fn", 4)
+	clock.Advance(30 * time.Second)
+	autoscaler.Reconcile(context.Background())
+	for i := 0; i < 3; i++ {
+		tracker.End("fn")
+	}
+	clock.Advance(30 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+
+	// Act, the lower recommendation outlived the window
+	clock.Advance(45 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Autoscaler_Respects_Max_Label(t *testing.T) {
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 1, map[string]interface{}{
+			handlers.ScaleTargetLabel: "1",
+			handlers.ScaleMaxLabel:    "3",
+		}),
+	}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, AutoscalerConfig{Tolerance: 0.1})
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "3"}).Return(nil, nil)
+
+	// Act
+	autoscaler.Reconcile(context.Background())
+	invoke(tracker, "fn", 10)
+	clock.Advance(10 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertExpectations(t)
+}
+
+func Test_Autoscaler_Skips_Functions_Without_Target(t *testing.T) {
+	// Arrange
+	services := []client.Service{
+		makeFunctionService("fn", 1, nil),
+	}
+	autoscaler, tracker, clock, mockClient := makeAutoscaler(services, AutoscalerConfig{Tolerance: 0.1})
+
+	// Act
+	autoscaler.Reconcile(context.Background())
+	invoke(tracker, "fn", 10)
+	clock.Advance(10 * time.Second)
+	autoscaler.Reconcile(context.Background())
+
+	// Assert
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
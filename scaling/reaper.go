@@ -0,0 +1,108 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package scaling
+
+import (
+	"context"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/handlers"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/rancher/go-rancher/v2"
+)
+
+// Reaper scales functions to zero once the proxy hasn't seen an invocation for IdleTimeout
+type Reaper struct {
+	client      rancher.BridgeClient
+	tracker     *handlers.InvocationTracker
+	idleTimeout time.Duration
+	started     time.Time
+	clock       handlers.Clock
+	auditLog    *audit.Log
+}
+
+// NewReaper creates a reaper for the functions tracked by the proxy, its scalings are recorded
+// into auditLog
+func NewReaper(client rancher.BridgeClient, tracker *handlers.InvocationTracker, idleTimeout time.Duration, auditLog *audit.Log) *Reaper {
+	return &Reaper{
+		client:      client,
+		tracker:     tracker,
+		idleTimeout: idleTimeout,
+		started:     time.Now(),
+		clock:       handlers.SystemClock{},
+		auditLog:    auditLog,
+	}
+}
+
+// Run reaps idle functions every interval until stop is closed
+func (r *Reaper) Run(interval time.Duration, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(audit.WithSystem(context.Background(), r.auditLog, "reaper"))
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Reap(ctx)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Reap scales every idle function to zero, functions labelled com.openfaas.scale.zero=false are skipped
+func (r *Reaper) Reap(ctx context.Context) {
+	// functions not invoked since the provider started are measured from the start
+	idleSince := r.clock.Now().Add(-r.idleTimeout)
+	if r.started.After(idleSince) {
+		return
+	}
+
+	services, err := r.client.ListServicesWithContext(ctx)
+	if err != nil {
+		logging.Default().Error("Unable to list services for scale to zero", "error", err)
+		return
+	}
+
+	for i := range services {
+		service := &services[i]
+		if service.State != "active" || service.Scale == 0 || !handlers.IsFunction(service) {
+			continue
+		}
+		if !handlers.ScaleToZeroAllowed(service) {
+			continue
+		}
+		r.scaleToZero(ctx, service, idleSince)
+	}
+}
+
+// scaleToZero scales the function to zero if it is still idle once invocations are held back
+func (r *Reaper) scaleToZero(ctx context.Context, service *client.Service, idleSince time.Time) {
+	// held so that invocations arriving meanwhile wait for the scale down before waking it up
+	lock := r.tracker.WakeLock(service.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !r.tracker.MarkIdleIfIdle(service.Name, idleSince) {
+		return
+	}
+
+	logging.Default().Info("Scaling function to zero", "function", service.Name, "idleSince", idleSince)
+	updates := make(map[string]string)
+	updates["scale"] = "0"
+	_, updateErr := r.client.UpdateServiceWithContext(ctx, service, updates)
+	handlers.RecordScale(ctx, service, 0, "idle", updateErr)
+	if updateErr != nil {
+		logging.Default().Error("Unable to scale function to zero", "function", service.Name, "error", updateErr)
+		r.tracker.MarkAwake(service.Name)
+	}
+}
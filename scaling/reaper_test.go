@@ -0,0 +1,159 @@
+package scaling
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/handlers"
+	"github.com/kenfdev/faas-rancher/mocks"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeFunctionService(name string, scale int64, labels map[string]interface{}) client.Service {
+	if labels == nil {
+		labels = map[string]interface{}{}
+	}
+	labels[handlers.FaasFunctionLabel] = name
+	return client.Service{
+		Name:         name,
+		State:        "active",
+		Scale:        scale,
+		LaunchConfig: &client.LaunchConfig{Labels: labels},
+	}
+}
+
+func Test_Reaper_Scales_Idle_Functions_To_Zero(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := handlers.NewInvocationTracker()
+	reaper := NewReaper(mockClient, tracker, time.Minute, nil)
+	clock := &fakeClock{now: time.Now()}
+	reaper.started = clock.Now().Add(-time.Hour)
+	reaper.clock = clock
+
+	tracker.Begin("busy")
+	tracker.Begin("recent")
+	tracker.End("recent")
+
+	services := []client.Service{
+		makeFunctionService("idle", 1, nil),
+		makeFunctionService("busy", 1, nil),
+		makeFunctionService("recent", 1, nil),
+		makeFunctionService("opted-out", 1, map[string]interface{}{handlers.ScaleZeroLabel: "false"}),
+		makeFunctionService("already-zero", 0, nil),
+	}
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.MatchedBy(func(s *client.Service) bool {
+		return s.Name == "idle"
+	}), map[string]string{"scale": "0"}).Return(nil, nil)
+
+	// Act
+	reaper.Reap(context.Background())
+
+	// Assert
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNumberOfCalls(t, "UpdateServiceWithContext", 1)
+	assert.False(tracker.Awake("idle"))
+}
+
+func Test_Reaper_Run_Records_Scalings(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := handlers.NewInvocationTracker()
+	auditLog := audit.NewLog(ioutil.Discard, 10)
+	reaper := NewReaper(mockClient, tracker, time.Minute, auditLog)
+	reaper.started = time.Now().Add(-time.Hour)
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{makeFunctionService("idle", 1, nil)}, nil)
+	scaled := make(chan bool, 10)
+	mockClient.On("UpdateServiceWithContext", mock.Anything, mock.Anything, map[string]string{"scale": "0"}).Return(nil, nil).Run(func(mock.Arguments) {
+		scaled <- true
+	})
+	stop := make(chan struct{})
+	stopped := make(chan bool)
+
+	// Act
+	go func() {
+		reaper.Run(time.Millisecond, stop)
+		stopped <- true
+	}()
+	<-scaled
+	close(stop)
+	<-stopped
+
+	// Assert
+	entries, _ := auditLog.Since(time.Time{})
+	assert.NotEmpty(entries)
+	assert.Equal("system:reaper", entries[0].Principal)
+	assert.Equal("scale", entries[0].Operation)
+	assert.Equal("idle", entries[0].Function)
+}
+
+func Test_Reaper_Waits_For_Idle_Timeout_After_Start(t *testing.T) {
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := handlers.NewInvocationTracker()
+	reaper := NewReaper(mockClient, tracker, time.Minute, nil)
+
+	services := []client.Service{
+		makeFunctionService("never-invoked", 1, nil),
+	}
+	mockClient.On("ListServicesWithContext", mock.Anything).Return(services, nil)
+
+	// Act
+	reaper.Reap(context.Background())
+
+	// Assert
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_Reaper_Skips_Function_Invoked_While_Reaping(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	mockClient := new(mocks.BridgeClient)
+	tracker := handlers.NewInvocationTracker()
+	reaper := NewReaper(mockClient, tracker, time.Minute, nil)
+	clock := &fakeClock{now: time.Now().Add(time.Hour)}
+	reaper.started = time.Now().Add(-time.Hour)
+	reaper.clock = clock
+	tracker.MarkAwake("idle")
+
+	// an invocation begins once the services were listed
+	mockClient.On("ListServicesWithContext", mock.Anything).Return([]client.Service{makeFunctionService("idle", 1, nil)}, nil).
+		Run(func(mock.Arguments) { tracker.Begin("idle") })
+
+	// Act
+	reaper.Reap(context.Background())
+
+	// Assert
+	mockClient.AssertNotCalled(t, "UpdateServiceWithContext", mock.Anything, mock.Anything, mock.Anything)
+	assert.True(tracker.Awake("idle"))
+}
+
+func Test_InvocationTracker_MarkIdleIfIdle(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracker := handlers.NewInvocationTracker()
+	tracker.Begin("busy")
+	tracker.Begin("recent")
+	tracker.End("recent")
+	tracker.MarkAwake("never-invoked")
+	idleSince := time.Now().Add(-time.Minute)
+
+	// Act
+	busy := tracker.MarkIdleIfIdle("busy", time.Now().Add(time.Minute))
+	recent := tracker.MarkIdleIfIdle("recent", idleSince)
+	neverInvoked := tracker.MarkIdleIfIdle("never-invoked", idleSince)
+
+	// Assert
+	assert.False(busy)
+	assert.False(recent)
+	assert.True(neverInvoked)
+	assert.False(tracker.Awake("never-invoked"))
+}
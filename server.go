@@ -4,45 +4,107 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/alexellis/faas-provider"
 	bootTypes "github.com/alexellis/faas-provider/types"
+	"github.com/gorilla/mux"
+	"github.com/kenfdev/faas-rancher/audit"
+	"github.com/kenfdev/faas-rancher/auth"
 	"github.com/kenfdev/faas-rancher/handlers"
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
 	"github.com/kenfdev/faas-rancher/rancher"
+	"github.com/kenfdev/faas-rancher/scaling"
+	"github.com/kenfdev/faas-rancher/tracing"
+	"github.com/kenfdev/faas-rancher/types"
 )
 
 const (
 	// TimeoutSeconds seconds untile timeout for http client
 	TimeoutSeconds = 2
+	// loopsStopTimeout is how long the background loops have to return once the server shut down
+	loopsStopTimeout = 5 * time.Second
 )
 
 func main() {
-	functionStackName := os.Getenv("FUNCTION_STACK_NAME")
-	cattleURL := os.Getenv("CATTLE_URL")
-	cattleAccessKey := os.Getenv("CATTLE_ACCESS_KEY")
-	cattleSecretKey := os.Getenv("CATTLE_SECRET_KEY")
+	readConfig := types.ReadConfig{}
+	cfg := readConfig.Read(types.OsEnv{})
 
-	// creates the rancher client config
-	config, err := rancher.NewClientConfig(
-		functionStackName,
-		cattleURL,
-		cattleAccessKey,
-		cattleSecretKey)
+	logger, err := makeLogger(cfg)
 	if err != nil {
 		panic(err.Error())
 	}
+	logging.SetDefault(logger)
+	// what is still logged through the standard logger, e.g. by libraries, goes through the logger
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelError))
 
-	// create the rancher REST client
-	rancherClient, err := rancher.NewClientForConfig(config)
-	if err != nil {
-		panic(err.Error())
+	environments := &types.EnvironmentsConfig{
+		Default: "default",
+		Environments: []types.EnvironmentConfig{{
+			Name:           "default",
+			URL:            os.Getenv("CATTLE_URL"),
+			AccessKey:      os.Getenv("CATTLE_ACCESS_KEY"),
+			SecretKey:      os.Getenv("CATTLE_SECRET_KEY"),
+			FunctionsStack: os.Getenv("FUNCTION_STACK_NAME"),
+		}},
+	}
+	if len(cfg.EnvironmentsFile) > 0 {
+		data, readErr := ioutil.ReadFile(cfg.EnvironmentsFile)
+		if readErr != nil {
+			panic(readErr.Error())
+		}
+		parsed, parseErr := types.ParseEnvironmentsConfig(data, types.OsEnv{})
+		if parseErr != nil {
+			panic(parseErr.Error())
+		}
+		environments = parsed
+	}
+
+	registry := metrics.NewRegistry()
+	loops := newBackgroundLoops()
+
+	if len(cfg.TracingEndpoint) > 0 {
+		exporter := tracing.NewOTLPExporter(cfg.TracingEndpoint, cfg.TracingServiceName, registry)
+		loops.run(exporter.Run, cfg.TracingExportInterval)
+		tracing.SetDefault(tracing.NewTracer(exporter))
+		logger.Info("Exporting traces", "collector", cfg.TracingEndpoint, "interval", cfg.TracingExportInterval)
+	}
+
+	// the default environment is created last, so that the gauges of the retrying and cached
+	// clients describe it
+	router := rancher.NewEnvironmentRouter(environments.Default)
+	var config *rancher.Config
+	for _, environment := range environments.Environments {
+		if environment.Name != environments.Default {
+			addEnvironment(router, environment, cfg, registry, loops, false)
+		}
+	}
+	for _, environment := range environments.Environments {
+		if environment.Name == environments.Default {
+			config = addEnvironment(router, environment, cfg, registry, loops, true)
+		}
+	}
+	var rancherClient rancher.BridgeClient = router
+
+	var auditLog *audit.Log
+	if cfg.AuditLog != "none" {
+		auditLog, err = audit.OpenLog(cfg.AuditLog, int(cfg.AuditMemoryEntries))
+		if err != nil {
+			panic(err.Error())
+		}
 	}
-	fmt.Println("Created Rancher Client")
 
 	proxyClient := http.Client{
 		Transport: &http.Transport{
@@ -57,22 +119,396 @@ func main() {
 			ExpectContinueTimeout: 1500 * time.Millisecond,
 		},
 	}
+
+	functionProxy := handlers.MakeProxy(&proxyClient, config.FunctionsStackName)
+	tracker := handlers.NewInvocationTracker()
+	if cfg.ScaleToZeroIdle > 0 {
+		functionProxy = handlers.MakeWakeUpProxy(rancherClient, tracker, cfg.WakeTimeout, functionProxy)
+
+		reaper := scaling.NewReaper(rancherClient, tracker, cfg.ScaleToZeroIdle, auditLog)
+		loops.run(reaper.Run, cfg.ScaleToZeroInterval)
+		logger.Info("Scaling functions to zero", "idle", cfg.ScaleToZeroIdle)
+	}
+	if cfg.AutoscaleInterval > 0 {
+		autoscaler := scaling.NewAutoscaler(rancherClient, tracker, scaling.AutoscalerConfig{
+			Tolerance:       cfg.AutoscaleTolerance,
+			ScaleUpWindow:   cfg.AutoscaleUpWindow,
+			ScaleDownWindow: cfg.AutoscaleDownWindow,
+			MaxReplicas:     cfg.MaxReplicas,
+			AuditLog:        auditLog,
+		}, handlers.SystemClock{})
+		loops.run(autoscaler.Run, cfg.AutoscaleInterval)
+		logger.Info("Autoscaling functions", "interval", cfg.AutoscaleInterval)
+	}
+	functionProxy = handlers.MakeTracingProxy(handlers.MakeTrackingProxy(tracker, functionProxy))
+
+	var statsGauges *handlers.StatsGauges
+	if cfg.StatsMetricsInterval > 0 {
+		statsGauges = handlers.NewStatsGauges(registry, environments.Default)
+		collector := handlers.NewStatsCollector(rancherClient, statsGauges)
+		loops.run(collector.Run, cfg.StatsMetricsInterval)
+		logger.Info("Exporting function stats", "interval", cfg.StatsMetricsInterval)
+	}
+
+	driftReconciler := handlers.NewDriftReconciler(rancherClient, cfg.DriftEnforce, cfg.MaxReplicas, auditLog, registry)
+	if cfg.DriftInterval > 0 {
+		loops.run(driftReconciler.Run, cfg.DriftInterval)
+		logger.Info("Checking functions for drift", "interval", cfg.DriftInterval, "enforce", cfg.DriftEnforce)
+	}
+
+	janitor := handlers.NewJanitor(rancherClient, cfg.JanitorTTL, cfg.JanitorDryRun, auditLog, registry)
+	if cfg.JanitorInterval > 0 {
+		loops.run(janitor.Run, cfg.JanitorInterval)
+		logger.Info("Looking for failed functions", "interval", cfg.JanitorInterval, "dryRun", cfg.JanitorDryRun)
+	}
+
+	if (len(cfg.TLSCertFile) > 0) != (len(cfg.TLSKeyFile) > 0) {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE have to be set together")
+	}
+	if len(cfg.TLSClientCAFile) > 0 && len(cfg.TLSCertFile) == 0 {
+		log.Fatal("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE to be set")
+	}
+
+	authenticators := makeAuthenticators(cfg)
+
+	// require wraps the handler of a route with the permissions it requires once RBAC is enabled
+	require := func(permissions auth.PermissionsFunc, next http.HandlerFunc) http.HandlerFunc {
+		return next
+	}
+	if len(cfg.RBACPolicyFile) > 0 {
+		if len(authenticators) == 0 {
+			log.Fatal("RBAC_POLICY_FILE requires authentication to be configured")
+		}
+		authorizer, err := auth.NewAuthorizer(cfg.RBACPolicyFile, environments.Default)
+		if err != nil {
+			panic(err.Error())
+		}
+		if cfg.RBACReloadInterval > 0 {
+			loops.run(authorizer.Run, cfg.RBACReloadInterval)
+		}
+		require = authorizer.Require
+		logger.Info("Authorizing requests", "policy", cfg.RBACPolicyFile, "reloadInterval", cfg.RBACReloadInterval)
+	}
+	read := auth.Requires(auth.PermissionRead)
+
+	invokeFunction := functionProxy.ServeHTTP
+	if !cfg.AuthExemptFunctions {
+		invokeFunction = require(auth.Requires(auth.PermissionInvoke), invokeFunction)
+	}
+
 	bootstrapHandlers := bootTypes.FaaSHandlers{
-		FunctionProxy:  handlers.MakeProxy(&proxyClient, config.FunctionsStackName).ServeHTTP,
-		DeleteHandler:  handlers.MakeDeleteHandler(rancherClient).ServeHTTP,
-		DeployHandler:  handlers.MakeDeployHandler(rancherClient).ServeHTTP,
-		FunctionReader: handlers.MakeFunctionReader(rancherClient).ServeHTTP,
-		ReplicaReader:  handlers.MakeReplicaReader(rancherClient).ServeHTTP,
-		ReplicaUpdater: handlers.MakeReplicaUpdater(rancherClient).ServeHTTP,
+		FunctionProxy:  invokeFunction,
+		DeleteHandler:  require(auth.Requires(auth.PermissionDelete), handlers.MakeDeleteHandler(rancherClient).ServeHTTP),
+		DeployHandler:  require(auth.DeployPermissions, handlers.MakeDeployHandler(rancherClient).ServeHTTP),
+		FunctionReader: require(read, handlers.MakeFunctionReader(rancherClient).ServeHTTP),
+		ReplicaReader:  require(read, handlers.MakeReplicaReader(rancherClient).ServeHTTP),
+		ReplicaUpdater: require(auth.Requires(auth.PermissionScale), handlers.MakeReplicaUpdater(rancherClient, cfg.MaxReplicas).ServeHTTP),
 	}
+	handlers.LimitWait(cfg.WriteTimeout)
+
 	var port int
 	port = 8080
 	bootstrapConfig := bootTypes.FaaSConfig{
-		ReadTimeout:  time.Second * 8,
-		WriteTimeout: time.Second * 8,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 		TCPPort:      &port,
 	}
 
-	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
+	r := mux.NewRouter()
+	registerFaaSRoutes(r, &bootstrapHandlers)
+
+	if cfg.ScaleFactor <= 0 {
+		log.Fatal("SCALE_FACTOR has to be a positive percentage")
+	}
+	alertScaler := handlers.NewAlertScaler(rancherClient, cfg.ScaleFactor, cfg.AlertCooldown, cfg.MaxReplicas)
+	r.HandleFunc("/system/alert", require(auth.Requires(auth.PermissionScale), handlers.MakeAlertHandler(alertScaler).ServeHTTP)).Methods("POST")
+	applies := handlers.NewApplies(loops)
+	r.HandleFunc("/system/apply", require(auth.StackPermissions, handlers.MakeApplyHandler(rancherClient, applies).ServeHTTP)).Methods("POST")
+	r.HandleFunc("/system/apply/{id:[a-f0-9]+}", require(read, handlers.MakeApplyStatusHandler(applies).ServeHTTP)).Methods("GET")
+	r.HandleFunc("/system/export", require(read, handlers.MakeExportHandler(rancherClient).ServeHTTP)).Methods("GET")
+	r.HandleFunc("/system/import", require(auth.StackPermissions, handlers.MakeImportHandler(rancherClient).ServeHTTP)).Methods("POST")
+	r.HandleFunc("/system/drift", require(read, handlers.MakeDriftHandler(driftReconciler).ServeHTTP)).Methods("GET")
+	r.HandleFunc("/system/janitor", require(auth.JanitorPermissions, handlers.MakeJanitorHandler(janitor).ServeHTTP)).Methods("GET", "POST")
+	r.HandleFunc("/system/namespaces", require(read, handlers.MakeNamespacesHandler(rancherClient).ServeHTTP)).Methods("GET")
+	// the followed logs, streamed stats and exec sessions aren't waited for by Shutdown, they are ended by it
+	streams := handlers.NewStreams()
+	r.HandleFunc("/system/logs", require(read, handlers.MakeLogsHandler(rancherClient, streams).ServeHTTP)).Methods("GET")
+	r.HandleFunc("/system/functions/{name:[-a-zA-Z_0-9]+}/stats", require(read, handlers.MakeStatsHandler(rancherClient, statsGauges, streams).ServeHTTP)).Methods("GET")
+	r.HandleFunc("/metrics", require(read, registry.ServeHTTP)).Methods("GET")
+
+	var handler http.Handler = r
+	if auditLog != nil {
+		r.HandleFunc("/system/audit", require(auth.Requires(auth.PermissionAudit), handlers.MakeAuditHandler(auditLog).ServeHTTP)).Methods("GET")
+		handler = audit.MakeMiddleware(auditLog, handler)
+		logger.Info("Auditing function changes", "auditLog", cfg.AuditLog)
+	}
+
+	if cfg.EnableExec {
+		// once requests are authenticated exec is too, the admin token would be a second credential
+		// in the same Authorization header
+		execAdminToken := cfg.ExecAdminToken
+		if len(authenticators) > 0 {
+			if len(execAdminToken) > 0 {
+				logger.Warn("EXEC_ADMIN_TOKEN is ignored, exec is authenticated like every other request")
+			}
+			execAdminToken = ""
+		} else if len(execAdminToken) == 0 {
+			log.Fatal("ENABLE_EXEC requires EXEC_ADMIN_TOKEN or authentication to be set")
+		}
+		r.HandleFunc("/system/functions/{name:[-a-zA-Z_0-9]+}/exec", require(auth.Requires(auth.PermissionExec), handlers.MakeExecHandler(rancherClient, execAdminToken, streams).ServeHTTP)).Methods("GET")
+	}
+
+	handler = handlers.MakeNamespaceMiddleware(handlers.MakeEnvironmentMiddleware(router, handler))
+	if len(authenticators) > 0 {
+		handler = auth.MakeMiddleware(authenticators, cfg.AuthExemptFunctions, handler)
+		logger.Info("Authenticating requests", "exemptFunctions", cfg.AuthExemptFunctions)
+	} else {
+		logger.Warn("No authentication is configured, anyone reaching the provider can manage functions")
+	}
+	handler = logging.MakeMiddleware(logger, handler)
+
+	server := makeServer(handler, &bootstrapConfig)
+	server.RegisterOnShutdown(streams.Close)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.TLSCertFile) > 0 {
+		reloader, err := auth.NewCertificateReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		if cfg.TLSReloadInterval > 0 {
+			loops.run(reloader.Run, cfg.TLSReloadInterval)
+		}
+		server.TLSConfig, err = auth.NewTLSConfig(reloader, cfg.TLSClientCAFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		listener = tls.NewListener(listener, server.TLSConfig)
+		logger.Info("Serving TLS", "certFile", cfg.TLSCertFile, "clientCAFile", cfg.TLSClientCAFile, "reloadInterval", cfg.TLSReloadInterval)
+	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	logger.Info("Listening", "address", server.Addr)
+
+	serveErr := serve(server, listener, signals, cfg.ShutdownGracePeriod)
+	if serveErr != nil {
+		logger.Error("Stopped serving", "error", serveErr)
+	}
+	// waits for the streams ended by Shutdown, and ends them when the server was closed instead
+	streams.Close()
+	if !loops.stopAndWait(loopsStopTimeout) {
+		logger.Warn("Background loops did not stop in time", "timeout", loopsStopTimeout)
+	}
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	logger.Info("Shut down")
+}
+
+// makeLogger creates the logger of LOG_LEVEL and LOG_FORMAT, which writes to stderr
+func makeLogger(cfg types.BootstrapConfig) (*logging.Logger, error) {
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(os.Stderr, cfg.LogFormat, level)
+}
+
+// makeAuthenticators creates the authenticators which are configured, basic auth first
+func makeAuthenticators(cfg types.BootstrapConfig) []auth.Authenticator {
+	authenticators := []auth.Authenticator{}
+	if len(cfg.TLSClientCAFile) > 0 {
+		authenticators = append(authenticators, auth.ClientCertificate{})
+	}
+	if len(cfg.AuthHtpasswdFile) > 0 {
+		htpasswd, err := auth.NewHtpasswd(cfg.AuthHtpasswdFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		authenticators = append(authenticators, htpasswd)
+	}
+	if len(cfg.AuthTokensFile) > 0 {
+		tokens, err := auth.NewBearerTokens(cfg.AuthTokensFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if len(cfg.AuthHMACSecret) > 0 {
+		authenticators = append(authenticators, auth.NewHMAC([]byte(cfg.AuthHMACSecret), cfg.AuthHMACMaxSkew))
+	}
+	return authenticators
+}
+
+// addEnvironment creates the client of an environment and adds it to the router. Only the default
+// environment is cached, as the cache and the background loops only manage it.
+func addEnvironment(router *rancher.EnvironmentRouter, environment types.EnvironmentConfig, cfg types.BootstrapConfig,
+	registry *metrics.Registry, loops *backgroundLoops, isDefault bool) *rancher.Config {
+	// creates the rancher client config
+	config, err := rancher.NewClientConfig(
+		environment.FunctionsStack,
+		environment.URL,
+		environment.AccessKey,
+		environment.SecretKey)
+	if err != nil {
+		panic(err.Error())
+	}
+	config.Background = loops
+
+	// create the rancher REST client
+	rancherClient, err := rancher.NewClientForConfig(config)
+	if err != nil {
+		panic(err.Error())
+	}
+	logging.Default().Info("Created Rancher client", "environment", environment.Name)
+
+	rancherClient = rancher.NewRetryingClient(rancherClient, rancher.RetryPolicy{
+		Attempts:        int(cfg.RetryAttempts),
+		Backoff:         cfg.RetryBackoff,
+		MaxBackoff:      cfg.RetryMaxBackoff,
+		BreakerFailures: int(cfg.BreakerFailures),
+		BreakerOpen:     cfg.BreakerOpen,
+	}, environment.Name, registry)
+
+	if isDefault && cfg.CacheResyncInterval > 0 {
+		cachedClient, cacheErr := rancher.NewCachedClient(rancherClient, config, registry)
+		if cacheErr != nil {
+			panic(cacheErr.Error())
+		}
+		loops.run(cachedClient.Run, cfg.CacheResyncInterval)
+		rancherClient = cachedClient
+		logging.Default().Info("Caching functions", "resyncInterval", cfg.CacheResyncInterval)
+	}
+
+	if err := router.Add(rancher.Environment{
+		Name:               environment.Name,
+		FunctionsStackName: environment.FunctionsStack,
+		Namespaces:         environment.Namespaces,
+	}, rancherClient); err != nil {
+		panic(err.Error())
+	}
+	return config
+}
+
+// registerFaaSRoutes registers the handlers on the routes of the OpenFaaS provider spec,
+// as bootstrap.Serve does
+func registerFaaSRoutes(r *mux.Router, handlers *bootTypes.FaaSHandlers) {
+	r.HandleFunc("/system/functions", handlers.FunctionReader).Methods("GET")
+	r.HandleFunc("/system/functions", handlers.DeployHandler).Methods("POST")
+	r.HandleFunc("/system/functions", handlers.DeleteHandler).Methods("DELETE")
+
+	r.HandleFunc("/system/function/{name:[-a-zA-Z_0-9]+}", handlers.ReplicaReader).Methods("GET")
+	r.HandleFunc("/system/scale-function/{name:[-a-zA-Z_0-9]+}", handlers.ReplicaUpdater).Methods("POST")
+
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:[-a-zA-Z_0-9]+}/", handlers.FunctionProxy)
+}
+
+// makeServer creates the HTTP server of the provider
+func makeServer(handler http.Handler, config *bootTypes.FaaSConfig) *http.Server {
+	tcpPort := 8080
+	if config.TCPPort != nil {
+		tcpPort = *config.TCPPort
+	}
+
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%d", tcpPort),
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes, // 1MB - can be overridden by setting Server.MaxHeaderBytes.
+		Handler:        handler,
+		// HTTP/2 is off, its connections can't be hijacked to stream logs and stats past the
+		// WriteTimeout nor upgraded to the websockets of exec
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+}
+
+// serve answers the connections of the listener until a signal is received, then stops accepting
+// connections and waits up to the grace period for the requests in flight, such as function
+// invocations, to be answered. A second signal stops waiting. The error is the one which stopped
+// the server, or tells that requests were cut short.
+func serve(server *http.Server, listener net.Listener, signals <-chan os.Signal, gracePeriod time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case received := <-signals:
+		logging.Default().Info("Shutting down, waiting for the requests in flight", "signal", received, "gracePeriod", gracePeriod)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	go func() {
+		select {
+		case received := <-signals:
+			logging.Default().Warn("Shutting down without waiting any longer", "signal", received)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests in flight were cut short: %s", err)
+	}
+	return nil
+}
+
+// backgroundLoops runs the loops of the provider, such as the reaper and the cache resync,
+// until they are stopped
+type backgroundLoops struct {
+	stop    chan struct{}
+	running sync.WaitGroup
+	// ctx is the context of the work started by Go
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newBackgroundLoops() *backgroundLoops {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundLoops{stop: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+// run starts a loop running every interval
+func (b *backgroundLoops) run(loop func(interval time.Duration, stop <-chan struct{}), interval time.Duration) {
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		loop(interval, b.stop)
+	}()
+}
+
+// Go runs work which outlives its request, such as an apply, until it returns or is cancelled by
+// stopAndWait
+func (b *backgroundLoops) Go(work func(ctx context.Context)) {
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		work(b.ctx)
+	}()
+}
+
+// stopAndWait stops the loops, cancels the work started by Go and waits up to the timeout for them
+// to return, it tells whether they did
+func (b *backgroundLoops) stopAndWait(timeout time.Duration) bool {
+	close(b.stop)
+	b.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		b.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
@@ -0,0 +1,176 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	bootTypes "github.com/alexellis/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowServer listens on a random port and answers after being released
+func slowServer(t *testing.T) (*http.Server, net.Listener, chan struct{}, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	})}
+	return server, listener, started, release
+}
+
+func Test_serve_Answers_Requests_In_Flight_After_Signal(t *testing.T) {
+	// Arrange
+	server, listener, started, release := slowServer(t)
+	signals := make(chan os.Signal, 2)
+	served := make(chan error, 1)
+	go func() { served <- serve(server, listener, signals, 5*time.Second) }()
+
+	url := "http://" + listener.Addr().String()
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get(url)
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		responses <- string(body)
+	}()
+	<-started
+
+	// Act
+	signals <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	_, dialErr := net.Dial("tcp", listener.Addr().String())
+	close(release)
+
+	// Assert
+	assert.Error(t, dialErr)
+	assert.Equal(t, "done", <-responses)
+	assert.NoError(t, <-served)
+}
+
+func Test_serve_Fails_When_Grace_Period_Expires(t *testing.T) {
+	// Arrange
+	server, listener, started, release := slowServer(t)
+	defer close(release)
+	signals := make(chan os.Signal, 2)
+	served := make(chan error, 1)
+	go func() { served <- serve(server, listener, signals, 50*time.Millisecond) }()
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	// Act
+	signals <- syscall.SIGTERM
+
+	// Assert
+	select {
+	case err := <-served:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the grace period")
+	}
+}
+
+func Test_serve_Stops_Waiting_On_Second_Signal(t *testing.T) {
+	// Arrange
+	server, listener, started, release := slowServer(t)
+	defer close(release)
+	signals := make(chan os.Signal, 2)
+	served := make(chan error, 1)
+	go func() { served <- serve(server, listener, signals, time.Minute) }()
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	// Act
+	signals <- syscall.SIGTERM
+	signals <- os.Interrupt
+
+	// Assert
+	select {
+	case err := <-served:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the second signal")
+	}
+}
+
+func Test_backgroundLoops_stopAndWait_Waits_For_Loops(t *testing.T) {
+	// Arrange
+	loops := newBackgroundLoops()
+	returned := make(chan struct{}, 2)
+	loop := func(interval time.Duration, stop <-chan struct{}) {
+		<-stop
+		time.Sleep(interval)
+		returned <- struct{}{}
+	}
+	loops.run(loop, 10*time.Millisecond)
+	loops.run(loop, 20*time.Millisecond)
+
+	// Act
+	stopped := loops.stopAndWait(5 * time.Second)
+
+	// Assert
+	assert.True(t, stopped)
+	assert.Len(t, returned, 2)
+}
+
+func Test_backgroundLoops_stopAndWait_Gives_Up_After_Timeout(t *testing.T) {
+	// Arrange
+	loops := newBackgroundLoops()
+	block := make(chan struct{})
+	defer close(block)
+	loops.run(func(interval time.Duration, stop <-chan struct{}) { <-block }, time.Second)
+
+	// Act
+	stopped := loops.stopAndWait(20 * time.Millisecond)
+
+	// Assert
+	assert.False(t, stopped)
+}
+
+func Test_backgroundLoops_stopAndWait_Cancels_Work(t *testing.T) {
+	// Arrange
+	loops := newBackgroundLoops()
+	returned := make(chan struct{}, 1)
+	loops.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		returned <- struct{}{}
+	})
+
+	// Act
+	stopped := loops.stopAndWait(5 * time.Second)
+
+	// Assert
+	assert.True(t, stopped)
+	assert.Len(t, returned, 1)
+}
+
+func Test_makeServer_Turns_HTTP2_Off(t *testing.T) {
+	// Arrange
+	config := &bootTypes.FaaSConfig{}
+
+	// Act
+	server := makeServer(http.NotFoundHandler(), config)
+
+	// Assert
+	if assert.NotNil(t, server.TLSNextProto, "HTTP/2 is set up by default") {
+		assert.Empty(t, server.TLSNextProto)
+	}
+}
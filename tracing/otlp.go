@@ -0,0 +1,244 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/logging"
+	"github.com/kenfdev/faas-rancher/metrics"
+)
+
+const (
+	// otlpTracesPath is where OTLP/HTTP collectors receive spans
+	otlpTracesPath = "/v1/traces"
+	// exportBatchSize spans are sent at once, a full batch is sent without waiting for the interval
+	exportBatchSize = 512
+	// exportQueueSize spans at most wait to be sent, more are dropped
+	exportQueueSize = 4096
+)
+
+// OTLPExporter sends the spans in batches to an OpenTelemetry collector with OTLP/HTTP, encoded
+// as JSON. Spans are dropped rather than slowing down the provider when the collector can't keep up.
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	lock  sync.Mutex
+	queue []SpanData
+	full  chan struct{}
+	spans *metrics.Counter
+}
+
+// NewOTLPExporter creates an exporter sending to the collector at endpoint, e.g.
+// http://otel-collector:4318, the spans are attributed to the service name
+func NewOTLPExporter(endpoint string, serviceName string, registry *metrics.Registry) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, otlpTracesPath) {
+		url += otlpTracesPath
+	}
+	return &OTLPExporter{
+		url:         url,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		full:        make(chan struct{}, 1),
+		spans:       registry.NewCounter("faas_rancher_tracing_spans_total", "Spans handed to the OTLP collector by result.", "result"),
+	}
+}
+
+// Export queues the span to be sent
+func (e *OTLPExporter) Export(span SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(e.queue) >= exportQueueSize {
+		e.spans.Inc("dropped")
+		return
+	}
+	e.queue = append(e.queue, span)
+	if len(e.queue) >= exportBatchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends the queued spans every interval, or as soon as a batch is full, until stop is closed.
+// What is left is sent before returning.
+func (e *OTLPExporter) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.full:
+		case <-stop:
+			e.flushLogged()
+			return
+		}
+		e.flushLogged()
+	}
+}
+
+func (e *OTLPExporter) flushLogged() {
+	if err := e.Flush(); err != nil {
+		logging.Default().Warn("Unable to export spans", "collector", e.url, "error", err)
+	}
+}
+
+// Flush sends the queued spans, those of a batch the collector failed to receive are dropped
+func (e *OTLPExporter) Flush() error {
+	for {
+		e.lock.Lock()
+		batch := e.queue
+		if len(batch) > exportBatchSize {
+			batch = batch[:exportBatchSize]
+		}
+		e.queue = e.queue[len(batch):]
+		e.lock.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.send(batch); err != nil {
+			e.spans.Add(float64(len(batch)), "error")
+			return err
+		}
+		e.spans.Add(float64(len(batch)), "success")
+	}
+}
+
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(e.makeRequest(batch))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("the collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of an ExportTraceServiceRequest. Ids are hex, and 64 bit
+// integers are strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	// Code is 0 when unset and 2 for errors
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (e *OTLPExporter) makeRequest(batch []SpanData) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, data := range batch {
+		span := otlpSpan{
+			TraceID:           data.Context.TraceID.String(),
+			SpanID:            data.Context.SpanID.String(),
+			Name:              data.Name,
+			Kind:              data.Kind,
+			StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+		}
+		if data.Parent != (SpanID{}) {
+			span.ParentSpanID = data.Parent.String()
+		}
+		for _, attribute := range data.Attributes {
+			span.Attributes = append(span.Attributes, makeAttribute(attribute.Key, attribute.Value))
+		}
+		if len(data.Error) > 0 {
+			span.Status = otlpStatus{Code: 2, Message: data.Error}
+		}
+		spans = append(spans, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{makeAttribute("service.name", e.serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/kenfdev/faas-rancher/tracing"}, Spans: spans}},
+	}}}
+}
+
+func makeAttribute(key string, value interface{}) otlpAttribute {
+	attribute := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case bool:
+		attribute.Value.BoolValue = &v
+	case int, int32, int64, uint, uint32:
+		intValue := fmt.Sprint(v)
+		attribute.Value.IntValue = &intValue
+	case float32:
+		doubleValue := float64(v)
+		attribute.Value.DoubleValue = &doubleValue
+	case float64:
+		attribute.Value.DoubleValue = &v
+	default:
+		stringValue := fmt.Sprint(v)
+		attribute.Value.StringValue = &stringValue
+	}
+	return attribute
+}
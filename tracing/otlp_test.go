@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kenfdev/faas-rancher/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCollector receives OTLP/HTTP JSON exports
+type fakeCollector struct {
+	*httptest.Server
+	lock     sync.Mutex
+	paths    []string
+	requests []map[string]interface{}
+}
+
+func newFakeCollector() *fakeCollector {
+	collector := &fakeCollector{}
+	collector.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&request)
+		collector.lock.Lock()
+		collector.paths = append(collector.paths, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type"))
+		collector.requests = append(collector.requests, request)
+		collector.lock.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	return collector
+}
+
+// spans are the spans the collector received, in order
+func (c *fakeCollector) spans() []map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	spans := []map[string]interface{}{}
+	for _, request := range c.requests {
+		for _, resourceSpans := range request["resourceSpans"].([]interface{}) {
+			for _, scopeSpans := range resourceSpans.(map[string]interface{})["scopeSpans"].([]interface{}) {
+				for _, span := range scopeSpans.(map[string]interface{})["spans"].([]interface{}) {
+					spans = append(spans, span.(map[string]interface{}))
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func Test_OTLPExporter_Exports_Spans_To_Collector(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	collector := newFakeCollector()
+	defer collector.Close()
+	exporter := NewOTLPExporter(collector.URL, "faas-rancher", metrics.NewRegistry())
+	tracer := NewTracer(exporter)
+	started := time.Unix(1520000000, 0)
+	tracer.now = func() time.Time { return started }
+
+	parent, _ := Extract(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+	ctx, span := tracer.Start(WithRemoteParent(context.Background(), parent), "invoke figlet", KindServer)
+	span.SetAttribute("faas.function", "figlet")
+	_, child := tracer.Start(ctx, "rancher GET services", KindClient)
+	child.SetAttribute("http.status_code", 404)
+	child.SetError(errors.New("not found"))
+
+	// Act
+	child.End()
+	span.End()
+	span.End()
+	err := exporter.Flush()
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal([]string{"POST /v1/traces application/json"}, collector.paths)
+	resource := collector.requests[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})["resource"]
+	assert.Equal(map[string]interface{}{"attributes": []interface{}{
+		map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "faas-rancher"}},
+	}}, resource)
+
+	spans := collector.spans()
+	assert.Len(spans, 2)
+	assert.Equal(map[string]interface{}{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            child.Context().SpanID.String(),
+		"parentSpanId":      span.Context().SpanID.String(),
+		"name":              "rancher GET services",
+		"kind":              float64(KindClient),
+		"startTimeUnixNano": "1520000000000000000",
+		"endTimeUnixNano":   "1520000000000000000",
+		"attributes": []interface{}{
+			map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "404"}},
+		},
+		"status": map[string]interface{}{"code": float64(2), "message": "not found"},
+	}, spans[0])
+	assert.Equal("00f067aa0ba902b7", spans[1]["parentSpanId"])
+	assert.Equal("invoke figlet", spans[1]["name"])
+	assert.Equal(map[string]interface{}{}, spans[1]["status"])
+}
+
+func Test_Tracer_Without_Exporter_Records_Nothing(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	tracer := NewTracer(nil)
+
+	// Act
+	ctx, span := tracer.Start(context.Background(), "invoke figlet", KindServer)
+	span.SetAttribute("faas.function", "figlet")
+	span.End()
+
+	// Assert
+	assert.Nil(span)
+	_, ok := SpanContextFromContext(ctx)
+	assert.False(ok)
+}
+
+func Test_Tracer_Follows_Callers_Sampling(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	collector := newFakeCollector()
+	defer collector.Close()
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "faas-rancher", metrics.NewRegistry())
+	tracer := NewTracer(exporter)
+	parent, _ := Extract(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
+
+	// Act
+	_, span := tracer.Start(WithRemoteParent(context.Background(), parent), "invoke figlet", KindServer)
+	span.End()
+	exporter.Flush()
+
+	// Assert
+	assert.Nil(span)
+	assert.Empty(collector.paths)
+}
+
+func Test_OTLPExporter_Run_Exports_On_Stop(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	collector := newFakeCollector()
+	defer collector.Close()
+	exporter := NewOTLPExporter(collector.URL, "faas-rancher", metrics.NewRegistry())
+	tracer := NewTracer(exporter)
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		exporter.Run(time.Hour, stop)
+		close(finished)
+	}()
+
+	// Act
+	_, span := tracer.Start(context.Background(), "invoke figlet", KindServer)
+	span.End()
+	close(stop)
+	<-finished
+
+	// Assert
+	spans := collector.spans()
+	assert.Len(spans, 1)
+	assert.Equal(span.Context().TraceID.String(), spans[0]["traceId"])
+	_, hasParent := spans[0]["parentSpanId"]
+	assert.False(hasParent)
+}
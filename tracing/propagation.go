@@ -0,0 +1,126 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The headers spans are propagated with, W3C trace context first and B3 of Zipkin otherwise
+const (
+	TraceparentHeader  = "traceparent"
+	B3Header           = "b3"
+	B3TraceIDHeader    = "X-B3-TraceId"
+	B3SpanIDHeader     = "X-B3-SpanId"
+	B3ParentSpanHeader = "X-B3-ParentSpanId"
+	B3SampledHeader    = "X-B3-Sampled"
+	B3FlagsHeader      = "X-B3-Flags"
+)
+
+// Extract reads the span context propagated by the caller, from traceparent, the single b3
+// header or the X-B3-* headers, in that order
+func Extract(header http.Header) (SpanContext, bool) {
+	if value := header.Get(TraceparentHeader); len(value) > 0 {
+		sc, err := parseTraceparent(value)
+		return sc, err == nil
+	}
+	if value := header.Get(B3Header); len(value) > 0 {
+		sc, err := parseB3(value)
+		return sc, err == nil
+	}
+	if traceID := header.Get(B3TraceIDHeader); len(traceID) > 0 {
+		sampled := header.Get(B3SampledHeader)
+		if header.Get(B3FlagsHeader) == "1" {
+			sampled = "d"
+		}
+		sc, err := makeB3SpanContext(traceID, header.Get(B3SpanIDHeader), sampled)
+		return sc, err == nil
+	}
+	return SpanContext{}, false
+}
+
+// Inject writes the span context for the callee, both as traceparent and as B3 so that it
+// continues the trace whichever it understands. The B3 headers of the caller are replaced.
+func Inject(header http.Header, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	flags, sampled := "00", "0"
+	if sc.Sampled {
+		flags, sampled = "01", "1"
+	}
+
+	header.Set(TraceparentHeader, fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags))
+	header.Set(B3Header, fmt.Sprintf("%s-%s-%s", sc.TraceID, sc.SpanID, sampled))
+	header.Set(B3TraceIDHeader, sc.TraceID.String())
+	header.Set(B3SpanIDHeader, sc.SpanID.String())
+	header.Set(B3SampledHeader, sampled)
+	header.Del(B3ParentSpanHeader)
+	header.Del(B3FlagsHeader)
+}
+
+// parseTraceparent parses version-traceid-spanid-flags, versions after 00 may append fields
+func parseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	sc := SpanContext{}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 ||
+		decodeID(parts[1], sc.TraceID[:]) != nil || decodeID(parts[2], sc.SpanID[:]) != nil || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// parseB3 parses traceid-spanid[-sampled[-parentspanid]], a lone sampling decision carries no span
+func parseB3(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 {
+		return SpanContext{}, fmt.Errorf("invalid b3 %q", value)
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return makeB3SpanContext(parts[0], parts[1], sampled)
+}
+
+// makeB3SpanContext accepts trace ids of 64 or 128 bits. Without a sampling decision the trace
+// is sampled, as the caller deferred the decision.
+func makeB3SpanContext(traceID string, spanID string, sampled string) (SpanContext, error) {
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+
+	sc := SpanContext{}
+	if decodeID(strings.ToLower(traceID), sc.TraceID[:]) != nil || decodeID(strings.ToLower(spanID), sc.SpanID[:]) != nil || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid b3 ids %q and %q", traceID, spanID)
+	}
+
+	switch sampled {
+	case "", "1", "true", "d":
+		sc.Sampled = true
+	case "0", "false":
+		sc.Sampled = false
+	default:
+		return SpanContext{}, fmt.Errorf("invalid b3 sampling %q", sampled)
+	}
+	return sc, nil
+}
+
+// decodeID decodes lower case hex of exactly the length of id
+func decodeID(value string, id []byte) error {
+	if len(value) != hex.EncodedLen(len(id)) || strings.ToLower(value) != value {
+		return fmt.Errorf("invalid id %q", value)
+	}
+	_, err := hex.Decode(id, []byte(value))
+	return err
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Extract(t *testing.T) {
+	assert := assert.New(t)
+	cases := []struct {
+		name    string
+		header  map[string]string
+		ok      bool
+		traceID string
+		spanID  string
+		sampled bool
+	}{
+		{"traceparent", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"traceparent not sampled", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+			true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+		{"traceparent of a later version", map[string]string{"traceparent": "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"},
+			true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"traceparent with zero trace id", map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+			false, "", "", false},
+		{"traceparent in upper case", map[string]string{"traceparent": "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01"},
+			false, "", "", false},
+		{"single b3", map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"},
+			true, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"single b3 deferring sampling", map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"},
+			true, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"single b3 sampling only", map[string]string{"b3": "0"}, false, "", "", false},
+		{"multi b3 with a 64 bit trace id", map[string]string{"X-B3-TraceId": "a3ce929d0e0e4736", "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Sampled": "0"},
+			true, "0000000000000000a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+		{"multi b3 debug", map[string]string{"X-B3-TraceId": "a3ce929d0e0e4736", "X-B3-SpanId": "00f067aa0ba902b7", "X-B3-Flags": "1"},
+			true, "0000000000000000a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"multi b3 without span", map[string]string{"X-B3-TraceId": "a3ce929d0e0e4736"}, false, "", "", false},
+		{"traceparent before b3", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"},
+			true, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"none", map[string]string{}, false, "", "", false},
+	}
+
+	for _, tc := range cases {
+		header := http.Header{}
+		for key, value := range tc.header {
+			header.Set(key, value)
+		}
+
+		sc, ok := Extract(header)
+
+		assert.Equal(tc.ok, ok, tc.name)
+		if tc.ok {
+			assert.Equal(tc.traceID, sc.TraceID.String(), tc.name)
+			assert.Equal(tc.spanID, sc.SpanID.String(), tc.name)
+			assert.Equal(tc.sampled, sc.Sampled, tc.name)
+		}
+	}
+}
+
+func Test_Inject_Replaces_Callers_Headers(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	header := http.Header{}
+	header.Set("X-B3-TraceId", "a3ce929d0e0e4736")
+	header.Set("X-B3-SpanId", "1111111111111111")
+	header.Set("X-B3-ParentSpanId", "2222222222222222")
+	sc, _ := Extract(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+
+	// Act
+	Inject(header, sc)
+
+	// Assert
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", header.Get("traceparent"))
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", header.Get("b3"))
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", header.Get("X-B3-TraceId"))
+	assert.Equal("00f067aa0ba902b7", header.Get("X-B3-SpanId"))
+	assert.Equal("1", header.Get("X-B3-Sampled"))
+	assert.Empty(header.Get("X-B3-ParentSpanId"))
+	injected, _ := Extract(header)
+	assert.Equal(sc, injected)
+}
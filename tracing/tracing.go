@@ -0,0 +1,203 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package tracing records spans of the invocations and of the calls to Rancher, continuing the
+// traces of the callers, and exports them to an OpenTelemetry collector
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within its trace
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is what is propagated of a span to the spans it is the parent of
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid tells whether both ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// SpanKind tells the role of a span in a request, as OTLP numbers them
+type SpanKind int
+
+// The kinds of spans
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attribute is a key value pair describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is an ended span, as handed to the exporter
+type SpanData struct {
+	Context    SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error tells why the operation failed, empty when it succeeded
+	Error string
+}
+
+// Exporter receives the spans once they end
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// Span is an operation being timed. The methods of a nil span do nothing, it is what is started
+// while tracing is disabled or the trace isn't sampled.
+type Span struct {
+	tracer *Tracer
+
+	lock  sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context is the span context to propagate to the children of the span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute describes the span with a string, boolean or number
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed, unless err is nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End times the span and exports it, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.lock.Unlock()
+
+	s.tracer.exporter.Export(data)
+}
+
+// Tracer starts spans and hands them to its exporter once they end
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// NewTracer creates a tracer exporting to the exporter, a nil exporter disables tracing
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, now: time.Now}
+}
+
+type spanContextKey struct{}
+
+// WithRemoteParent returns a context in which spans are started as children of the span of
+// another process, typically extracted from the headers of a request
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, parent)
+}
+
+// SpanContextFromContext is the context of the span started last in ctx, or of its remote parent
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Start starts a span, the child of the span of ctx if there is one. The returned context carries
+// the new span to the operations it is made of. Traces their caller didn't sample are not recorded.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t.exporter == nil {
+		return ctx, nil
+	}
+
+	data := SpanData{Name: name, Kind: kind, Start: t.now()}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		if !parent.Sampled {
+			return ctx, nil
+		}
+		data.Context.TraceID = parent.TraceID
+		data.Parent = parent.SpanID
+	} else {
+		rand.Read(data.Context.TraceID[:])
+	}
+	rand.Read(data.Context.SpanID[:])
+	data.Context.Sampled = true
+
+	span := &Span{tracer: t, data: data}
+	return context.WithValue(ctx, spanContextKey{}, span.data.Context), span
+}
+
+var (
+	defaultLock   sync.Mutex
+	defaultTracer = NewTracer(nil)
+)
+
+// Default is the tracer of the provider, which records nothing unless replaced by SetDefault
+func Default() *Tracer {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultTracer
+}
+
+// SetDefault replaces the default tracer
+func SetDefault(tracer *Tracer) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultTracer = tracer
+}
+
+// Start starts a span with the default tracer, see Tracer.Start
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return Default().Start(ctx, name, kind)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EnvironmentsConfig lists the Rancher environments the provider manages functions in
+type EnvironmentsConfig struct {
+	// Default is the environment of requests which aren't routed to another, the first one when empty
+	Default      string              `json:"default"`
+	Environments []EnvironmentConfig `json:"environments"`
+}
+
+// EnvironmentConfig is a Rancher environment with its API endpoint, credentials and functions stack
+type EnvironmentConfig struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	AccessKey      string `json:"accessKey"`
+	SecretKey      string `json:"secretKey"`
+	FunctionsStack string `json:"functionsStack"`
+	// Namespaces are routed to this environment without naming it
+	Namespaces []string `json:"namespaces"`
+}
+
+// ParseEnvironmentsConfig reads an environments config written in YAML or JSON. References such
+// as ${CATTLE_SECRET_KEY} are replaced by the value of the environment variable, so that the
+// credentials don't have to be written into the file.
+func ParseEnvironmentsConfig(data []byte, hasEnv HasEnv) (*EnvironmentsConfig, error) {
+	expanded := os.Expand(string(data), hasEnv.Getenv)
+
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(expanded), &document); err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(yamlToJSON(document))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &EnvironmentsConfig{}
+	if err := json.Unmarshal(jsonData, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *EnvironmentsConfig) validate() error {
+	if len(c.Environments) == 0 {
+		return fmt.Errorf("no environments are configured")
+	}
+	if len(c.Default) == 0 {
+		c.Default = c.Environments[0].Name
+	}
+
+	names := make(map[string]bool)
+	namespaces := make(map[string]string)
+	for _, environment := range c.Environments {
+		if len(environment.Name) == 0 {
+			return fmt.Errorf("an environment has no name")
+		}
+		if names[environment.Name] {
+			return fmt.Errorf("environment %s is configured more than once", environment.Name)
+		}
+		names[environment.Name] = true
+
+		if len(environment.URL) == 0 || len(environment.FunctionsStack) == 0 {
+			return fmt.Errorf("environment %s needs a url and a functionsStack", environment.Name)
+		}
+		for _, namespace := range environment.Namespaces {
+			if other, ok := namespaces[namespace]; ok {
+				return fmt.Errorf("namespace %s is mapped to environments %s and %s", namespace, other, environment.Name)
+			}
+			namespaces[namespace] = environment.Name
+		}
+	}
+
+	if !names[c.Default] {
+		return fmt.Errorf("the default environment %s is not configured", c.Default)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseEnvironmentsConfig_Expands_Variables(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	data := []byte(`
+default: prod
+environments:
+  - name: staging
+    url: http://staging:8080/v2-beta
+    accessKey: staging-key
+    secretKey: ${STAGING_SECRET_KEY}
+    functionsStack: faas-functions
+  - name: prod
+    url: http://prod:8080/v2-beta
+    accessKey: prod-key
+    secretKey: ${PROD_SECRET_KEY}
+    functionsStack: faas-functions
+    namespaces: [team-a, team-b]
+`)
+	env := fakeEnv{values: map[string]string{"STAGING_SECRET_KEY": "s3cr3t", "PROD_SECRET_KEY": "pr0d"}}
+
+	// Act
+	config, err := ParseEnvironmentsConfig(data, env)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("prod", config.Default)
+	assert.Len(config.Environments, 2)
+	assert.Equal("s3cr3t", config.Environments[0].SecretKey)
+	assert.Equal("pr0d", config.Environments[1].SecretKey)
+	assert.Equal([]string{"team-a", "team-b"}, config.Environments[1].Namespaces)
+}
+
+func Test_ParseEnvironmentsConfig_Defaults_To_First_Environment(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	data := []byte(`{"environments": [{"name": "staging", "url": "http://staging", "functionsStack": "faas-functions"}]}`)
+
+	// Act
+	config, err := ParseEnvironmentsConfig(data, fakeEnv{})
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("staging", config.Default)
+}
+
+func Test_ParseEnvironmentsConfig_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := []string{
+		`environments: []`,
+		`environments: [{url: http://staging, functionsStack: faas-functions}]`,
+		`environments: [{name: staging, functionsStack: faas-functions}]`,
+		`{default: prod, environments: [{name: staging, url: http://staging, functionsStack: faas-functions}]}`,
+		`environments: [{name: a, url: http://a, functionsStack: f}, {name: a, url: http://b, functionsStack: f}]`,
+		`environments: [{name: a, url: http://a, functionsStack: f, namespaces: [x]}, {name: b, url: http://b, functionsStack: f, namespaces: [x]}]`,
+	}
+	for _, data := range invalid {
+		_, err := ParseEnvironmentsConfig([]byte(data), fakeEnv{})
+		assert.NotNil(err, data)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// OsEnv implements HasEnv by wrapping os.Getenv
+type OsEnv struct {
+}
+
+// Getenv wraps os.Getenv
+func (OsEnv) Getenv(key string) string {
+	return os.Getenv(key)
+}
+
+// HasEnv provides an interface for os.Getenv
+type HasEnv interface {
+	Getenv(key string) string
+}
+
+// ReadConfig constitutes the provider config from environment variables
+type ReadConfig struct {
+}
+
+// BootstrapConfig holds the settings of the provider besides the Rancher API endpoint and credentials
+type BootstrapConfig struct {
+	// ReadTimeout of the HTTP server
+	ReadTimeout time.Duration
+	// WriteTimeout of the HTTP server, ?wait= is shortened to end a second before it
+	WriteTimeout time.Duration
+
+	// MaxReplicas caps the scale of every function, 0 disables the cap
+	MaxReplicas int64
+
+	// ScaleToZeroIdle is how long a function may go without invocations before it is scaled to zero, 0 disables it
+	ScaleToZeroIdle time.Duration
+	// ScaleToZeroInterval is how often idle functions are looked for
+	ScaleToZeroInterval time.Duration
+	// WakeTimeout bounds how long an invocation waits for a function scaled to zero to become ready
+	WakeTimeout time.Duration
+
+	// AutoscaleInterval is how often the autoscaler reconciles the scale of functions, 0 disables it
+	AutoscaleInterval time.Duration
+	// AutoscaleTolerance is the relative deviation from a function's target concurrency which is ignored
+	AutoscaleTolerance float64
+	// AutoscaleUpWindow is how long a higher scale has to be recommended before scaling up
+	AutoscaleUpWindow time.Duration
+	// AutoscaleDownWindow is how long a lower scale has to be recommended before scaling down
+	AutoscaleDownWindow time.Duration
+
+	// EnableExec registers the exec endpoint, which also requires ExecAdminToken
+	EnableExec bool
+	// ExecAdminToken is the bearer token admins present to exec into function containers
+	ExecAdminToken string
+
+	// ScaleFactor is the percentage of a function's maximum replicas added per firing alert
+	ScaleFactor int64
+	// AlertCooldown is the minimum time between two alert driven scaling operations of a function
+	AlertCooldown time.Duration
+
+	// StatsMetricsInterval is how often the usage of every function is exported to /metrics, 0 disables it
+	StatsMetricsInterval time.Duration
+
+	// CacheResyncInterval is how often the services cache is fully resynced, 0 disables the cache
+	CacheResyncInterval time.Duration
+
+	// RetryAttempts is the maximum number of times a failing call to Cattle is made, 1 disables retrying
+	RetryAttempts int64
+	// RetryBackoff is the delay before the first retry, doubled for every further retry up to RetryMaxBackoff
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// BreakerFailures is the number of consecutive failed calls to Cattle after which calls fail fast, 0 disables it
+	BreakerFailures int64
+	// BreakerOpen is how long calls to Cattle fail fast
+	BreakerOpen time.Duration
+
+	// DriftInterval is how often functions are checked for changes made outside of faas-rancher, 0 disables it
+	DriftInterval time.Duration
+	// DriftEnforce restores the spec and scale bounds of drifted functions
+	DriftEnforce bool
+
+	// JanitorInterval is how often failed function services are looked for, 0 disables it
+	JanitorInterval time.Duration
+	// JanitorTTL is how long a function service has to fail before it is garbage
+	JanitorTTL time.Duration
+	// JanitorDryRun only reports the garbage instead of removing it
+	JanitorDryRun bool
+
+	// AuthHtpasswdFile is an htpasswd file with bcrypt hashes checked against basic auth
+	AuthHtpasswdFile string
+	// AuthTokensFile lists the static bearer tokens as name:token lines
+	AuthTokensFile string
+	// AuthHMACSecret is shared with the gateway to sign its requests
+	AuthHMACSecret string
+	// AuthHMACMaxSkew is how far the timestamp of a signed request may be from now
+	AuthHMACMaxSkew time.Duration
+	// AuthExemptFunctions lets function invocations through unauthenticated
+	AuthExemptFunctions bool
+
+	// RBACPolicyFile binds roles to the authenticated principals, RBAC is disabled when empty
+	RBACPolicyFile string
+	// RBACReloadInterval is how often the policy file is checked for changes
+	RBACReloadInterval time.Duration
+
+	// AuditLog is the JSON lines file the audit entries are appended to, stdout, or none to disable auditing
+	AuditLog string
+	// AuditMemoryEntries is how many audit entries are kept for queries when they aren't written to a file
+	AuditMemoryEntries int64
+
+	// LogLevel is the lowest level logged, debug, info, warn or error
+	LogLevel string
+	// LogFormat is json or logfmt
+	LogFormat string
+
+	// TracingEndpoint is the OTLP/HTTP collector spans are exported to, tracing is disabled when empty
+	TracingEndpoint string
+	// TracingServiceName is the service the spans are attributed to
+	TracingServiceName string
+	// TracingExportInterval is how often the spans are sent to the collector
+	TracingExportInterval time.Duration
+
+	// ShutdownGracePeriod is how long the requests in flight are waited for on SIGTERM or SIGINT
+	ShutdownGracePeriod time.Duration
+
+	// TLSCertFile is the certificate served over TLS, TLS is disabled when empty
+	TLSCertFile string
+	// TLSKeyFile is the private key of the certificate
+	TLSKeyFile string
+	// TLSClientCAFile holds the CAs client certificates have to be signed by, enables mutual TLS
+	TLSClientCAFile string
+	// TLSReloadInterval is how often the certificate and key files are checked for changes
+	TLSReloadInterval time.Duration
+
+	// EnvironmentsFile is the path of the environments config, when empty the single environment of
+	// CATTLE_URL is used
+	EnvironmentsFile string
+}
+
+// Read fetches the config from environment variables, falling back to defaults
+func (ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
+	cfg := BootstrapConfig{}
+
+	cfg.ReadTimeout = parseIntOrDurationValue(hasEnv.Getenv("READ_TIMEOUT"), time.Second*8)
+	cfg.WriteTimeout = parseIntOrDurationValue(hasEnv.Getenv("WRITE_TIMEOUT"), time.Second*8)
+
+	cfg.MaxReplicas = parseIntValue(hasEnv.Getenv("MAX_REPLICAS"), 100)
+
+	cfg.ScaleToZeroIdle = parseIntOrDurationValue(hasEnv.Getenv("SCALE_TO_ZERO_IDLE"), 0)
+	cfg.ScaleToZeroInterval = parseIntOrDurationValue(hasEnv.Getenv("SCALE_TO_ZERO_INTERVAL"), time.Minute)
+	if cfg.ScaleToZeroInterval <= 0 {
+		cfg.ScaleToZeroInterval = time.Minute
+	}
+	cfg.WakeTimeout = parseIntOrDurationValue(hasEnv.Getenv("WAKE_TIMEOUT"), time.Second*5)
+
+	cfg.AutoscaleInterval = parseIntOrDurationValue(hasEnv.Getenv("AUTOSCALE_INTERVAL"), 0)
+	cfg.AutoscaleTolerance = float64(parseIntValue(hasEnv.Getenv("AUTOSCALE_TOLERANCE"), 10)) / 100
+	cfg.AutoscaleUpWindow = parseIntOrDurationValue(hasEnv.Getenv("AUTOSCALE_UP_WINDOW"), time.Second*30)
+	cfg.AutoscaleDownWindow = parseIntOrDurationValue(hasEnv.Getenv("AUTOSCALE_DOWN_WINDOW"), time.Minute*5)
+
+	cfg.EnableExec = parseBoolValue(hasEnv.Getenv("ENABLE_EXEC"), false)
+	cfg.ExecAdminToken = hasEnv.Getenv("EXEC_ADMIN_TOKEN")
+
+	cfg.ScaleFactor = parseIntValue(hasEnv.Getenv("SCALE_FACTOR"), 20)
+	cfg.AlertCooldown = parseIntOrDurationValue(hasEnv.Getenv("ALERT_COOLDOWN"), time.Second*30)
+
+	cfg.StatsMetricsInterval = parseIntOrDurationValue(hasEnv.Getenv("STATS_METRICS_INTERVAL"), 0)
+
+	cfg.CacheResyncInterval = parseIntOrDurationValue(hasEnv.Getenv("CACHE_RESYNC_INTERVAL"), 0)
+
+	cfg.RetryAttempts = parseIntValue(hasEnv.Getenv("RANCHER_RETRY_ATTEMPTS"), 3)
+	cfg.RetryBackoff = parseIntOrDurationValue(hasEnv.Getenv("RANCHER_RETRY_BACKOFF"), time.Millisecond*200)
+	cfg.RetryMaxBackoff = parseIntOrDurationValue(hasEnv.Getenv("RANCHER_RETRY_MAX_BACKOFF"), time.Second*2)
+	cfg.BreakerFailures = parseIntValue(hasEnv.Getenv("RANCHER_BREAKER_FAILURES"), 5)
+	cfg.BreakerOpen = parseIntOrDurationValue(hasEnv.Getenv("RANCHER_BREAKER_OPEN"), time.Second*30)
+
+	cfg.DriftInterval = parseIntOrDurationValue(hasEnv.Getenv("DRIFT_INTERVAL"), 0)
+	cfg.DriftEnforce = parseBoolValue(hasEnv.Getenv("DRIFT_ENFORCE"), false)
+
+	cfg.JanitorInterval = parseIntOrDurationValue(hasEnv.Getenv("JANITOR_INTERVAL"), 0)
+	cfg.JanitorTTL = parseIntOrDurationValue(hasEnv.Getenv("JANITOR_TTL"), time.Hour)
+	cfg.JanitorDryRun = parseBoolValue(hasEnv.Getenv("JANITOR_DRY_RUN"), true)
+
+	cfg.AuthHtpasswdFile = hasEnv.Getenv("AUTH_HTPASSWD_FILE")
+	cfg.AuthTokensFile = hasEnv.Getenv("AUTH_TOKENS_FILE")
+	cfg.AuthHMACSecret = hasEnv.Getenv("AUTH_HMAC_SECRET")
+	cfg.AuthHMACMaxSkew = parseIntOrDurationValue(hasEnv.Getenv("AUTH_HMAC_MAX_SKEW"), time.Minute*5)
+	cfg.AuthExemptFunctions = parseBoolValue(hasEnv.Getenv("AUTH_EXEMPT_FUNCTIONS"), false)
+
+	cfg.RBACPolicyFile = hasEnv.Getenv("RBAC_POLICY_FILE")
+	cfg.RBACReloadInterval = parseIntOrDurationValue(hasEnv.Getenv("RBAC_RELOAD_INTERVAL"), time.Second*10)
+
+	cfg.AuditLog = hasEnv.Getenv("AUDIT_LOG")
+	if len(cfg.AuditLog) == 0 {
+		cfg.AuditLog = "stdout"
+	}
+	cfg.AuditMemoryEntries = parseIntValue(hasEnv.Getenv("AUDIT_MEMORY_ENTRIES"), 1000)
+
+	cfg.LogLevel = hasEnv.Getenv("LOG_LEVEL")
+	if len(cfg.LogLevel) == 0 {
+		cfg.LogLevel = "info"
+	}
+	cfg.LogFormat = hasEnv.Getenv("LOG_FORMAT")
+	if len(cfg.LogFormat) == 0 {
+		cfg.LogFormat = "logfmt"
+	}
+
+	cfg.TracingEndpoint = hasEnv.Getenv("TRACING_OTLP_ENDPOINT")
+	cfg.TracingServiceName = hasEnv.Getenv("TRACING_SERVICE_NAME")
+	if len(cfg.TracingServiceName) == 0 {
+		cfg.TracingServiceName = "faas-rancher"
+	}
+	cfg.TracingExportInterval = parseIntOrDurationValue(hasEnv.Getenv("TRACING_EXPORT_INTERVAL"), time.Second*5)
+	if cfg.TracingExportInterval <= 0 {
+		cfg.TracingExportInterval = time.Second * 5
+	}
+
+	cfg.ShutdownGracePeriod = parseIntOrDurationValue(hasEnv.Getenv("SHUTDOWN_GRACE_PERIOD"), time.Second*10)
+
+	cfg.TLSCertFile = hasEnv.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = hasEnv.Getenv("TLS_KEY_FILE")
+	cfg.TLSClientCAFile = hasEnv.Getenv("TLS_CLIENT_CA_FILE")
+	cfg.TLSReloadInterval = parseIntOrDurationValue(hasEnv.Getenv("TLS_RELOAD_INTERVAL"), time.Second*10)
+
+	cfg.EnvironmentsFile = hasEnv.Getenv("ENVIRONMENTS_FILE")
+
+	return cfg
+}
+
+// parseBoolValue parses a boolean such as "true" or "1", falling back when it isn't one
+func parseBoolValue(val string, fallback bool) bool {
+	parsedVal, parseErr := strconv.ParseBool(val)
+	if parseErr != nil {
+		return fallback
+	}
+	return parsedVal
+}
+
+// parseIntValue parses a positive integer, falling back when it isn't one
+func parseIntValue(val string, fallback int64) int64 {
+	parsedVal, parseErr := strconv.ParseInt(val, 10, 64)
+	if parseErr != nil || parsedVal < 0 {
+		return fallback
+	}
+	return parsedVal
+}
+
+// parseIntOrDurationValue accepts either a number of seconds or a Go duration such as "1m30s"
+func parseIntOrDurationValue(val string, fallback time.Duration) time.Duration {
+	if len(val) > 0 {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal >= 0 {
+			return time.Duration(parsedVal) * time.Second
+		}
+	}
+
+	duration, durationErr := time.ParseDuration(val)
+	if durationErr != nil {
+		return fallback
+	}
+	return duration
+}
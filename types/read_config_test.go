@@ -0,0 +1,111 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEnv struct {
+	values map[string]string
+}
+
+func (f fakeEnv) Getenv(key string) string {
+	return f.values[key]
+}
+
+func Test_Read_Defaults(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	env := fakeEnv{values: map[string]string{}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(time.Second*8, cfg.ReadTimeout)
+	assert.Equal(time.Second*8, cfg.WriteTimeout)
+}
+
+func Test_Read_Timeouts_As_Seconds_And_Durations(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	env := fakeEnv{values: map[string]string{
+		"READ_TIMEOUT":  "20",
+		"WRITE_TIMEOUT": "1m30s",
+	}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(time.Second*20, cfg.ReadTimeout)
+	assert.Equal(time.Second*90, cfg.WriteTimeout)
+}
+
+func Test_Read_Invalid_Timeout_Falls_Back(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	env := fakeEnv{values: map[string]string{
+		"WRITE_TIMEOUT": "soon",
+	}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(time.Second*8, cfg.WriteTimeout)
+}
+
+func Test_Read_Retry_Policy(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	env := fakeEnv{values: map[string]string{
+		"RANCHER_RETRY_ATTEMPTS":   "1",
+		"RANCHER_RETRY_BACKOFF":    "50ms",
+		"RANCHER_BREAKER_FAILURES": "0",
+		"RANCHER_BREAKER_OPEN":     "10",
+	}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(int64(1), cfg.RetryAttempts)
+	assert.Equal(time.Millisecond*50, cfg.RetryBackoff)
+	assert.Equal(time.Second*2, cfg.RetryMaxBackoff)
+	assert.Equal(int64(0), cfg.BreakerFailures)
+	assert.Equal(time.Second*10, cfg.BreakerOpen)
+}
+
+func Test_Read_Drift_And_Janitor_Defaults(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	env := fakeEnv{values: map[string]string{
+		"DRIFT_INTERVAL": "1m",
+	}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(time.Minute, cfg.DriftInterval)
+	assert.False(cfg.DriftEnforce)
+	assert.Equal(time.Duration(0), cfg.JanitorInterval)
+	assert.Equal(time.Hour, cfg.JanitorTTL)
+	assert.True(cfg.JanitorDryRun)
+}
+
+func Test_Read_Zero_Scale_To_Zero_Interval_Falls_Back(t *testing.T) {
+	// Arrange
+	env := fakeEnv{values: map[string]string{
+		"SCALE_TO_ZERO_INTERVAL": "0",
+	}}
+
+	// Act
+	cfg := ReadConfig{}.Read(env)
+
+	// Assert
+	assert.Equal(t, time.Minute, cfg.ScaleToZeroInterval)
+}
@@ -3,7 +3,35 @@
 
 package types
 
+import "github.com/alexellis/faas/gateway/requests"
+
 type ScaleServiceRequest struct {
 	ServiceName string `json:"serviceName"`
 	Replicas    int64  `json:"replicas"`
 }
+
+// CreateFunctionRequest extends the gateway's request with the fields faas-rancher understands
+type CreateFunctionRequest struct {
+	requests.CreateFunctionRequest
+
+	// Labels are added to the function's containers, they also carry per-function settings
+	// such as com.openfaas.scale.min
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Limits caps the resources of each of the function's containers
+	Limits *FunctionResources `json:"limits,omitempty"`
+
+	// Secrets are the names of Rancher secrets mounted into /run/secrets of the function's containers
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Scale is the number of replicas a new function starts with, 1 if unset
+	Scale int64 `json:"scale,omitempty"`
+}
+
+// FunctionResources are the resources of a container
+type FunctionResources struct {
+	// Memory in bytes with an optional k, m or g suffix, e.g. 128m
+	Memory string `json:"memory,omitempty"`
+	// CPU in cores, e.g. 0.5
+	CPU string `json:"cpu,omitempty"`
+}
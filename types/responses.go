@@ -0,0 +1,91 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import "time"
+
+// ServiceStatus is the observed state of a function's Rancher service
+type ServiceStatus struct {
+	Name                 string `json:"name"`
+	State                string `json:"state"`
+	HealthState          string `json:"healthState"`
+	Transitioning        string `json:"transitioning"`
+	TransitioningMessage string `json:"transitioningMessage,omitempty"`
+	Scale                int64  `json:"scale"`
+	CurrentScale         int64  `json:"currentScale"`
+}
+
+// ScaleServiceResponse reports the scale of a function before and after a scaling request
+type ScaleServiceResponse struct {
+	ServiceName string `json:"serviceName"`
+	OldReplicas int64  `json:"oldReplicas"`
+	NewReplicas int64  `json:"newReplicas"`
+	// Status is the state observed when the request waited for the function to be ready
+	Status *ServiceStatus `json:"status,omitempty"`
+}
+
+// LogMessage is a line logged by one of a function's instances
+type LogMessage struct {
+	Name      string    `json:"name"`
+	Instance  string    `json:"instance"`
+	Timestamp time.Time `json:"timestamp"`
+	// Stream is stdout or stderr
+	Stream string `json:"stream,omitempty"`
+	Text   string `json:"text"`
+}
+
+// FunctionStats is the resource usage of a function summed over its instances
+type FunctionStats struct {
+	Name string `json:"name"`
+	// Replicas is the number of instances which reported their usage
+	Replicas  int64     `json:"replicas"`
+	Timestamp time.Time `json:"timestamp"`
+	// CPUPercent is the CPU time used in percent of a single core
+	CPUPercent       float64 `json:"cpuPercent"`
+	MemoryBytes      uint64  `json:"memoryBytes"`
+	MemoryLimitBytes uint64  `json:"memoryLimitBytes"`
+	// NetworkRxBytes and NetworkTxBytes are counted since the instances started
+	NetworkRxBytes          uint64  `json:"networkRxBytes"`
+	NetworkTxBytes          uint64  `json:"networkTxBytes"`
+	NetworkRxBytesPerSecond float64 `json:"networkRxBytesPerSecond"`
+	NetworkTxBytesPerSecond float64 `json:"networkTxBytesPerSecond"`
+}
+
+// FunctionDrift is how the service of a function differs from the spec it was deployed with
+type FunctionDrift struct {
+	Name string `json:"name"`
+	// Fields lists what was changed outside of faas-rancher: image, envProcess, envVars, labels,
+	// constraints, limits, secrets or scale. It is spec when the deployed spec is unknown.
+	Fields    []string `json:"fields"`
+	Corrected bool     `json:"corrected"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// DriftReport lists the functions which drifted from their spec
+type DriftReport struct {
+	Checked   time.Time       `json:"checked"`
+	Functions []FunctionDrift `json:"functions"`
+}
+
+// FailedService is a function service which is inactive, errored or never became healthy
+type FailedService struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	HealthState string `json:"healthState"`
+	// Reason is inactive, error or never-healthy
+	Reason string `json:"reason"`
+	// Since is when the janitor first saw the service failing
+	Since time.Time `json:"since"`
+	// Expired is set once the service failed for longer than the janitor's TTL, making it garbage
+	Expired bool   `json:"expired"`
+	Removed bool   `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// JanitorReport lists the failed function services
+type JanitorReport struct {
+	Checked  time.Time       `json:"checked"`
+	DryRun   bool            `json:"dryRun"`
+	Services []FailedService `json:"services"`
+}
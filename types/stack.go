@@ -0,0 +1,84 @@
+// Copyright (c) Ken Fukuyama 2017. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// StackDocument declares the functions a stack should consist of
+type StackDocument struct {
+	Functions []CreateFunctionRequest `json:"functions"`
+}
+
+// ParseStackDocument reads a stack document written in YAML or JSON. The fields are named as in
+// the JSON of a deploy request, e.g. envVars.
+func ParseStackDocument(data []byte) (*StackDocument, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(yamlToJSON(document))
+	if err != nil {
+		return nil, err
+	}
+
+	stack := &StackDocument{}
+	if err := json.Unmarshal(jsonData, stack); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// yamlToJSON converts the maps decoded from YAML, which may have any keys, to maps which can be
+// encoded to JSON
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = yamlToJSON(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = yamlToJSON(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// ApplyChange is what applying a stack document does to one function
+type ApplyChange struct {
+	Name string `json:"name"`
+	// Action is create, update, unchanged or delete
+	Action string `json:"action"`
+	// Applied is set once the change was made, it stays unset on dry runs
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ApplyResult lists the changes of applying a stack document in the order they are made
+type ApplyResult struct {
+	// ID identifies an apply made in the background, its progress is at /system/apply/{id}
+	ID     string `json:"id,omitempty"`
+	DryRun bool   `json:"dryRun"`
+	// State is running, succeeded or failed, dry runs have none
+	State   string        `json:"state,omitempty"`
+	Changes []ApplyChange `json:"changes"`
+}
+
+// ImportResult is the outcome of deploying one function of an imported stack document
+type ImportResult struct {
+	Name string `json:"name"`
+	// Status is the status code the deploy request of the function would have been answered with
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+}
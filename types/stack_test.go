@@ -0,0 +1,57 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseStackDocument_YAML(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	data := []byte(`
+functions:
+  - service: some-fn
+    image: some/image
+    envProcess: cat
+    envVars:
+      SOME_ENV: SOME_VALUE
+    labels:
+      com.openfaas.scale.min: "2"
+`)
+
+	// Act
+	document, err := ParseStackDocument(data)
+
+	// Assert
+	assert.Nil(err)
+	assert.Len(document.Functions, 1)
+	function := document.Functions[0]
+	assert.Equal("some-fn", function.Service)
+	assert.Equal("some/image", function.Image)
+	assert.Equal("cat", function.EnvProcess)
+	assert.Equal("SOME_VALUE", function.EnvVars["SOME_ENV"])
+	assert.Equal("2", function.Labels["com.openfaas.scale.min"])
+}
+
+func Test_ParseStackDocument_JSON(t *testing.T) {
+	assert := assert.New(t)
+	// Arrange
+	data := []byte(`{"functions":[{"service":"some-fn","image":"some/image"}]}`)
+
+	// Act
+	document, err := ParseStackDocument(data)
+
+	// Assert
+	assert.Nil(err)
+	assert.Equal("some-fn", document.Functions[0].Service)
+}
+
+func Test_ParseStackDocument_Invalid(t *testing.T) {
+	assert := assert.New(t)
+	// Act
+	_, err := ParseStackDocument([]byte(`functions: {service: [`))
+
+	// Assert
+	assert.NotNil(err)
+}